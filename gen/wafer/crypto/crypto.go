@@ -10,6 +10,7 @@ const (
 	CryptoErrorSignError
 	CryptoErrorVerifyError
 	CryptoErrorOther
+	CryptoErrorKeyNotFound
 )
 
 func (e CryptoError) Error() string {
@@ -22,6 +23,8 @@ func (e CryptoError) Error() string {
 		return "sign error"
 	case CryptoErrorVerifyError:
 		return "verify error"
+	case CryptoErrorKeyNotFound:
+		return "key not found"
 	default:
 		return "crypto error"
 	}
@@ -32,3 +35,14 @@ var CompareHash func(password string, hash string) error
 var Sign func(claims string, expirySecs uint64) (string, error)
 var Verify func(token string) (string, error)
 var RandomBytes func(n uint32) ([]byte, error)
+
+// DataKey is a key-encryption key held by the host, identified by an ID so
+// that data encrypted under retired keys can still be decrypted.
+type DataKey struct {
+	ID       string
+	Material []byte
+}
+
+var CurrentKey func() (DataKey, error)
+var GetKey func(id string) ([]byte, error)
+var IndexKey func() ([]byte, error)
@@ -40,6 +40,28 @@ type Filter struct {
 	Value    string // JSON-encoded value
 }
 
+// GroupOp enumerates boolean operators for filter groups.
+type GroupOp uint8
+
+const (
+	GroupOpAnd GroupOp = iota
+	GroupOpOr
+	GroupOpNot // negates the AND of the group's members
+)
+
+// FilterGroup is one node of a boolean filter expression. WIT types cannot be
+// recursive, so child groups are referenced by index into FilterTree.Groups.
+type FilterGroup struct {
+	Op      GroupOp
+	Filters []Filter
+	Groups  []uint32
+}
+
+// FilterTree is a boolean filter expression rooted at Groups[0].
+type FilterTree struct {
+	Groups []FilterGroup
+}
+
 // SortField is a sort directive.
 type SortField struct {
 	Field string
@@ -49,9 +71,76 @@ type SortField struct {
 // ListOptions configures a list query.
 type ListOptions struct {
 	Filters []Filter
+	Where   *FilterTree // combined with Filters using AND
 	Sort    []SortField
 	Limit   int64
 	Offset  int64
+	Select  []string // fields to return; empty returns the full document
+}
+
+// AggregateFunc enumerates aggregate functions.
+type AggregateFunc uint8
+
+const (
+	AggregateFuncCount AggregateFunc = iota
+	AggregateFuncSum
+	AggregateFuncAvg
+	AggregateFuncMin
+	AggregateFuncMax
+)
+
+// AggregateField computes Func over a JSON field, reported under Alias.
+type AggregateField struct {
+	Func  AggregateFunc
+	Field string // empty for count of rows
+	Alias string
+}
+
+// AggregateOptions configures an aggregation query.
+type AggregateOptions struct {
+	Filters    []Filter
+	Where      *FilterTree
+	GroupBy    []string
+	Aggregates []AggregateField
+	Sort       []SortField // by group-by field or aggregate alias
+	Limit      int64
+}
+
+// AggregateRow is one result group: a JSON object keyed by group-by field
+// names and aggregate aliases.
+type AggregateRow struct {
+	Data string
+}
+
+// PatchFormat enumerates partial update document formats.
+type PatchFormat uint8
+
+const (
+	PatchFormatMergePatch PatchFormat = iota // RFC 7396
+	PatchFormatJSONPatch                     // RFC 6902
+)
+
+// BatchItem is the per-item outcome of a batch operation. Error is nil when
+// the item succeeded.
+type BatchItem struct {
+	Record DbRecord
+	Error  *DatabaseError
+}
+
+// ChangeOp enumerates change feed operations.
+type ChangeOp uint8
+
+const (
+	ChangeOpCreate ChangeOp = iota
+	ChangeOpUpdate
+	ChangeOpDelete
+)
+
+// WatchOptions configures a change feed subscription.
+type WatchOptions struct {
+	Ops        []ChangeOp // empty subscribes to every operation
+	Filters    []Filter   // matched against the new record, or the old one for deletes
+	ResumeFrom string     // checkpoint to resume after; empty starts from now
 }
 
 // DatabaseError enumerates database errors.
@@ -60,6 +149,8 @@ type DatabaseError uint8
 const (
 	DatabaseErrorNotFound DatabaseError = iota
 	DatabaseErrorInternal
+	DatabaseErrorUnsupported
+	DatabaseErrorConflict
 )
 
 func (e DatabaseError) Error() string {
@@ -68,6 +159,10 @@ func (e DatabaseError) Error() string {
 		return "not found"
 	case DatabaseErrorInternal:
 		return "internal error"
+	case DatabaseErrorUnsupported:
+		return "unsupported operation"
+	case DatabaseErrorConflict:
+		return "conflict"
 	default:
 		return "unknown error"
 	}
@@ -78,10 +173,21 @@ func (e DatabaseError) Error() string {
 // For now they are stubs that will be linked at compile time via //go:wasmimport.
 
 var Get func(collection string, id string) (DbRecord, error)
+var GetSelect func(collection string, id string, fields []string) (DbRecord, error)
 var List func(collection string, options ListOptions) (RecordList, error)
 var Create func(collection string, data string) (DbRecord, error)
 var Update func(collection string, id string, data string) (DbRecord, error)
 var Delete func(collection string, id string) error
 var Count func(collection string, filters []Filter) (int64, error)
+var CountWhere func(collection string, filters []Filter, where FilterTree) (int64, error)
 var QueryRaw func(query string, args string) ([]DbRecord, error)
 var ExecRaw func(query string, args string) (int64, error)
+var CreateMany func(collection string, data []string) ([]BatchItem, error)
+var UpdateMany func(collection string, filters []Filter, data string) ([]BatchItem, error)
+var DeleteMany func(collection string, filters []Filter) ([]BatchItem, error)
+var Upsert func(collection string, filters []Filter, data string) (DbRecord, error)
+var UpdateIf func(collection string, id string, conditions []Filter, data string) (DbRecord, error)
+var Patch func(collection string, id string, format PatchFormat, patch string) (DbRecord, error)
+var Aggregate func(collection string, options AggregateOptions) ([]AggregateRow, error)
+var Watch func(collection string, options WatchOptions) (string, error)
+var Unwatch func(subscription string) error
@@ -7,6 +7,13 @@ type ObjectInfo struct {
 	Size         int64
 	ContentType  string
 	LastModified string
+	Metadata     []MetadataEntry
+}
+
+// MetadataEntry is a user-defined key/value stored with an object.
+type MetadataEntry struct {
+	Key   string
+	Value string
 }
 
 type ObjectList struct {
@@ -19,6 +26,7 @@ type StorageError uint8
 const (
 	StorageErrorNotFound StorageError = iota
 	StorageErrorInternal
+	StorageErrorUnsupported
 )
 
 func (e StorageError) Error() string {
@@ -27,6 +35,8 @@ func (e StorageError) Error() string {
 		return "not found"
 	case StorageErrorInternal:
 		return "internal error"
+	case StorageErrorUnsupported:
+		return "unsupported operation"
 	default:
 		return "unknown error"
 	}
@@ -36,3 +46,16 @@ var Put func(folder string, key string, data []byte, contentType string) error
 var Get func(folder string, key string) ([]byte, ObjectInfo, error)
 var Delete func(folder string, key string) error
 var List func(folder string, prefix string, limit int64, offset int64) (ObjectList, error)
+
+// Chunked transfer: ranged reads and multi-call uploads, so objects larger
+// than guest memory can be streamed.
+var GetRange func(folder string, key string, offset int64, length int64) ([]byte, ObjectInfo, error)
+var BeginUpload func(folder string, key string, contentType string, metadata []MetadataEntry) (string, error)
+var UploadChunk func(upload string, data []byte) error
+var CommitUpload func(upload string) (ObjectInfo, error)
+var AbortUpload func(upload string) error
+
+var Head func(folder string, key string) (ObjectInfo, error)
+var PutWithMetadata func(folder string, key string, data []byte, contentType string, metadata []MetadataEntry) error
+var Copy func(srcFolder string, srcKey string, dstFolder string, dstKey string) (ObjectInfo, error)
+var Move func(srcFolder string, srcKey string, dstFolder string, dstKey string) (ObjectInfo, error)
@@ -176,6 +176,18 @@ func (b *ResponseBuilder) Meta(key, value string) *ResponseBuilder {
 	return b
 }
 
+// Status sets the HTTP status code of the response.
+func (b *ResponseBuilder) Status(status int) *ResponseBuilder {
+	b.meta["resp.status"] = strconv.Itoa(status)
+	return b
+}
+
+// Header sets an HTTP response header.
+func (b *ResponseBuilder) Header(name, value string) *ResponseBuilder {
+	b.meta["resp.header."+name] = value
+	return b
+}
+
 // Build constructs the Response from the builder state.
 func (b *ResponseBuilder) Build() *Response {
 	return &Response{
@@ -0,0 +1,259 @@
+package services
+
+import (
+	"encoding/json"
+	"sort"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+	"github.com/wafer-run/wafer-sdk-go/gen/wafer/database"
+)
+
+// AggregateFunc is a convenience alias for database.AggregateFunc.
+type AggregateFunc = database.AggregateFunc
+
+// AggregateField is a convenience alias for database.AggregateField.
+type AggregateField = database.AggregateField
+
+// AggregateOptions is a convenience alias for database.AggregateOptions.
+type AggregateOptions = database.AggregateOptions
+
+// Re-export aggregate function constants for convenience.
+const (
+	AggCount = database.AggregateFuncCount
+	AggSum   = database.AggregateFuncSum
+	AggAvg   = database.AggregateFuncAvg
+	AggMin   = database.AggregateFuncMin
+	AggMax   = database.AggregateFuncMax
+)
+
+// AggregateRow is one group of an aggregation result.
+type AggregateRow struct {
+	// Group holds the values of the group-by fields.
+	Group map[string]any
+	// Values holds the aggregate results keyed by alias. Counts, sums and
+	// averages are float64; min and max keep the type of the field.
+	Values map[string]any
+}
+
+// Float returns an aggregate value as a float64.
+func (r AggregateRow) Float(alias string) (float64, bool) {
+	f, ok := r.Values[alias].(float64)
+	return f, ok
+}
+
+// DatabaseAggregate groups the records matching the options' filters by the
+// group-by fields and computes the aggregates for each group. Without
+// GroupBy a single row covers every matching record. When the host does not
+// support aggregation, matching records are listed and aggregated
+// guest-side.
+func DatabaseAggregate(collection string, opts AggregateOptions) ([]AggregateRow, error) {
+	rows, err := aggregateRows(collection, opts)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]AggregateRow, len(rows))
+	for i, row := range rows {
+		var doc map[string]any
+		if err := json.Unmarshal([]byte(row.Data), &doc); err != nil {
+			return nil, &wafer.WaferError{
+				Code:    "internal",
+				Message: "failed to decode aggregate row: " + err.Error(),
+			}
+		}
+		out[i] = AggregateRow{Group: make(map[string]any), Values: make(map[string]any)}
+		for _, f := range opts.GroupBy {
+			out[i].Group[f] = doc[f]
+		}
+		for _, a := range opts.Aggregates {
+			alias := aggregateAlias(a)
+			out[i].Values[alias] = doc[alias]
+		}
+	}
+	return out, nil
+}
+
+// DatabaseAggregateInto runs DatabaseAggregate and decodes each row into T.
+// Row fields are named after the group-by fields and aggregate aliases, so T
+// uses matching json tags.
+func DatabaseAggregateInto[T any](collection string, opts AggregateOptions) ([]T, error) {
+	rows, err := aggregateRows(collection, opts)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]T, len(rows))
+	for i, row := range rows {
+		if err := json.Unmarshal([]byte(row.Data), &out[i]); err != nil {
+			return nil, &wafer.WaferError{
+				Code:    "internal",
+				Message: "failed to decode aggregate row: " + err.Error(),
+			}
+		}
+	}
+	return out, nil
+}
+
+func aggregateRows(collection string, opts AggregateOptions) ([]database.AggregateRow, error) {
+	if database.Aggregate != nil {
+		rows, err := database.Aggregate(collection, opts)
+		if !isUnsupported(err) {
+			return rows, err
+		}
+	}
+	matched, err := listMatching(collection, opts.Filters)
+	if err != nil {
+		return nil, err
+	}
+	return EvaluateAggregate(matched, opts)
+}
+
+// EvaluateAggregate computes an aggregation over records guest-side. Records
+// are filtered with MatchRecord, so Filters and Where are both honoured.
+// Groups keep the order in which they are first seen unless Sort is given.
+func EvaluateAggregate(records []Record, opts AggregateOptions) ([]database.AggregateRow, error) {
+	type group struct {
+		keys []any
+		accs []aggregateAcc
+	}
+	groups := make(map[string]*group)
+	var ordered []*group
+
+	for _, rec := range records {
+		ok, err := MatchRecord(rec, opts.Filters, opts.Where)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		var doc map[string]any
+		_ = json.Unmarshal([]byte(rec.Data), &doc)
+		if doc == nil {
+			doc = make(map[string]any)
+		}
+
+		keys := make([]any, len(opts.GroupBy))
+		for i, f := range opts.GroupBy {
+			keys[i], _ = LookupField(doc, f)
+		}
+		k := jsonValue(keys)
+		g, ok := groups[k]
+		if !ok {
+			g = &group{keys: keys, accs: make([]aggregateAcc, len(opts.Aggregates))}
+			groups[k] = g
+			ordered = append(ordered, g)
+		}
+		for i, a := range opts.Aggregates {
+			var v any = true
+			if a.Field != "" {
+				v, _ = LookupField(doc, a.Field)
+			}
+			g.accs[i].add(a.Func, v)
+		}
+	}
+	if len(ordered) == 0 && len(opts.GroupBy) == 0 {
+		ordered = append(ordered, &group{accs: make([]aggregateAcc, len(opts.Aggregates))})
+	}
+
+	docs := make([]map[string]any, len(ordered))
+	for i, g := range ordered {
+		doc := make(map[string]any, len(g.keys)+len(g.accs))
+		for j, f := range opts.GroupBy {
+			doc[f] = g.keys[j]
+		}
+		for j, a := range opts.Aggregates {
+			doc[aggregateAlias(a)] = g.accs[j].result(a.Func)
+		}
+		docs[i] = doc
+	}
+	if len(opts.Sort) > 0 {
+		sort.SliceStable(docs, func(i, j int) bool {
+			for _, s := range opts.Sort {
+				c := CompareSortValues(docs[i][s.Field], docs[j][s.Field])
+				if c == 0 {
+					continue
+				}
+				if s.Desc {
+					return c > 0
+				}
+				return c < 0
+			}
+			return false
+		})
+	}
+	if opts.Limit > 0 && int64(len(docs)) > opts.Limit {
+		docs = docs[:opts.Limit]
+	}
+
+	rows := make([]database.AggregateRow, len(docs))
+	for i, doc := range docs {
+		data, err := json.Marshal(doc)
+		if err != nil {
+			return nil, &wafer.WaferError{
+				Code:    "internal",
+				Message: "failed to marshal aggregate row: " + err.Error(),
+			}
+		}
+		rows[i] = database.AggregateRow{Data: string(data)}
+	}
+	return rows, nil
+}
+
+// aggregateAlias returns the alias of an aggregate, defaulting to
+// "<func>_<field>" or "count" for a row count.
+func aggregateAlias(a AggregateField) string {
+	if a.Alias != "" {
+		return a.Alias
+	}
+	name := [...]string{"count", "sum", "avg", "min", "max"}[min(int(a.Func), 4)]
+	if a.Field == "" {
+		return name
+	}
+	return name + "_" + a.Field
+}
+
+// aggregateAcc accumulates one aggregate for one group.
+type aggregateAcc struct {
+	count int64
+	sum   float64
+	best  any
+}
+
+func (acc *aggregateAcc) add(fn AggregateFunc, v any) {
+	if v == nil {
+		return
+	}
+	switch fn {
+	case AggCount:
+		acc.count++
+	case AggSum, AggAvg:
+		if f, ok := v.(float64); ok {
+			acc.count++
+			acc.sum += f
+		}
+	case AggMin, AggMax:
+		if acc.best == nil {
+			acc.best = v
+			return
+		}
+		c, ok := CompareValues(v, acc.best)
+		if ok && ((fn == AggMin && c < 0) || (fn == AggMax && c > 0)) {
+			acc.best = v
+		}
+	}
+}
+
+func (acc *aggregateAcc) result(fn AggregateFunc) any {
+	switch fn {
+	case AggCount:
+		return float64(acc.count)
+	case AggSum:
+		return acc.sum
+	case AggAvg:
+		if acc.count == 0 {
+			return nil
+		}
+		return acc.sum / float64(acc.count)
+	default:
+		return acc.best
+	}
+}
@@ -0,0 +1,435 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"path"
+	"strconv"
+	"strings"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+)
+
+// ArchiveFormat selects the archive format of WriteArchive, ServeArchive and
+// ExtractArchive.
+type ArchiveFormat string
+
+// Supported archive formats.
+const (
+	ArchiveZip   ArchiveFormat = "zip"
+	ArchiveTarGz ArchiveFormat = "tar.gz"
+)
+
+// ArchiveLimits bounds the archives created and extracted. Zero fields take
+// their value from DefaultArchiveLimits.
+type ArchiveLimits struct {
+	// MaxEntries is the most entries an archive may hold, directories
+	// included.
+	MaxEntries int
+	// MaxEntrySize is the largest uncompressed size of one file.
+	MaxEntrySize int64
+	// MaxTotalSize is the largest uncompressed size of all files together.
+	MaxTotalSize int64
+}
+
+// DefaultArchiveLimits are the limits used for fields left zero.
+var DefaultArchiveLimits = ArchiveLimits{
+	MaxEntries:   10000,
+	MaxEntrySize: 256 << 20,
+	MaxTotalSize: 1 << 30,
+}
+
+func (l ArchiveLimits) withDefaults() ArchiveLimits {
+	if l.MaxEntries <= 0 {
+		l.MaxEntries = DefaultArchiveLimits.MaxEntries
+	}
+	if l.MaxEntrySize <= 0 {
+		l.MaxEntrySize = DefaultArchiveLimits.MaxEntrySize
+	}
+	if l.MaxTotalSize <= 0 {
+		l.MaxTotalSize = DefaultArchiveLimits.MaxTotalSize
+	}
+	return l
+}
+
+// ServeArchiveMaxSize caps the uncompressed size of archives built by
+// ServeArchive, which holds the whole archive in memory.
+const ServeArchiveMaxSize = 64 << 20
+
+// archiveListPageSize is the number of objects requested per StorageList
+// call while archiving.
+const archiveListPageSize = 1000
+
+// WriteArchive writes every object in folder whose key starts with prefix to
+// w as a zip or tar.gz archive. Entry names are the keys without prefix.
+// Objects are streamed one chunk at a time. Keys that would not make safe
+// entry names, such as ones containing "..", fail with "invalid_argument" and
+// exceeding limits with "resource_exhausted", both before anything is
+// written.
+func WriteArchive(w io.Writer, format ArchiveFormat, folder, prefix string, limits ArchiveLimits) error {
+	limits = limits.withDefaults()
+	var objects []ObjectInfo
+	var names []string
+	var total int64
+	for offset := int64(0); ; offset += archiveListPageSize {
+		list, err := StorageList(folder, prefix, archiveListPageSize, offset)
+		if err != nil {
+			return err
+		}
+		for _, obj := range list.Objects {
+			if len(objects) == limits.MaxEntries {
+				return archiveLimitError("archive would exceed " + strconv.Itoa(limits.MaxEntries) + " entries")
+			}
+			if obj.Size > limits.MaxEntrySize {
+				return archiveLimitError(obj.Key + " exceeds the maximum entry size of " + strconv.FormatInt(limits.MaxEntrySize, 10) + " bytes")
+			}
+			if total += obj.Size; total > limits.MaxTotalSize {
+				return archiveLimitError("archive would exceed " + strconv.FormatInt(limits.MaxTotalSize, 10) + " bytes")
+			}
+			name, ok := archiveEntryName(strings.TrimPrefix(strings.TrimPrefix(obj.Key, prefix), "/"))
+			if !ok {
+				return unsafeEntry(obj.Key)
+			}
+			objects = append(objects, obj)
+			names = append(names, name)
+		}
+		if len(list.Objects) < archiveListPageSize {
+			break
+		}
+	}
+
+	aw, err := newArchiveWriter(w, format)
+	if err != nil {
+		return err
+	}
+	for i, obj := range objects {
+		r, err := openStorageReader(folder, obj.Key)
+		if err != nil {
+			return err
+		}
+		err = aw.add(names[i], r.info, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return aw.close()
+}
+
+// ServeArchive responds with an archive of every object in folder whose key
+// starts with prefix, offered for download as filename. The response is
+// built in memory, so the total size is capped at ServeArchiveMaxSize
+// whatever limits allow. For larger archives, write one to storage with
+// WriteArchive and a StorageWriter and serve the stored object.
+func ServeArchive(format ArchiveFormat, folder, prefix, filename string, limits ArchiveLimits) *wafer.BlockResult {
+	limits = limits.withDefaults()
+	limits.MaxTotalSize = min(limits.MaxTotalSize, ServeArchiveMaxSize)
+	var buf bytes.Buffer
+	if err := WriteArchive(&buf, format, folder, prefix, limits); err != nil {
+		var we *wafer.WaferError
+		if errors.As(err, &we) && we.Code == "resource_exhausted" {
+			return wafer.ErrorStatus(413, we.Code, we.Message)
+		}
+		return storageErrorResult(err)
+	}
+	contentType := "application/zip"
+	if format == ArchiveTarGz {
+		contentType = "application/gzip"
+	}
+	return wafer.NewResponseBuilder().
+		Status(200).
+		Meta("content-type", contentType).
+		Header("Content-Disposition", `attachment; filename="`+strings.NewReplacer(`"`, "", `\`, "", "\r", "", "\n", "").Replace(filename)+`"`).
+		Data(buf.Bytes()).
+		Respond()
+}
+
+// ExtractArchive stores every file of a zip or tar.gz archive in folder, under
+// prefix plus its path in the archive, and returns the keys written. An empty
+// format is detected from the archive's leading bytes. Entries with absolute
+// paths or ".." segments, links and special files fail with
+// "invalid_argument", and exceeding limits with "resource_exhausted"; sizes
+// are checked against the bytes actually decompressed, not the archive's
+// headers. Existing objects are never replaced: an entry whose key is
+// already taken, in storage or by an earlier entry, fails with
+// "already_exists". On failure the objects already written are removed. Each
+// file's content type is derived from its extension, and the folder's upload
+// policy applies.
+func ExtractArchive(r io.ReaderAt, size int64, format ArchiveFormat, folder, prefix string, limits ArchiveLimits) ([]string, error) {
+	limits = limits.withDefaults()
+	if format == "" {
+		head := make([]byte, min(size, SniffLength))
+		n, _ := r.ReadAt(head, 0)
+		switch SniffContentType(head[:n]) {
+		case "application/zip":
+			format = ArchiveZip
+		case "application/gzip":
+			format = ArchiveTarGz
+		default:
+			return nil, invalidArchive(errors.New("unrecognised archive format"))
+		}
+	}
+	x := &extractor{folder: folder, prefix: prefix, limits: limits, written: make(map[string]bool)}
+	var err error
+	switch format {
+	case ArchiveZip:
+		err = x.zip(r, size)
+	case ArchiveTarGz:
+		err = x.tarGz(io.NewSectionReader(r, 0, size))
+	default:
+		err = unsupportedArchive(format)
+	}
+	if err != nil {
+		for _, key := range x.keys {
+			StorageDelete(folder, key)
+		}
+		return nil, err
+	}
+	return x.keys, nil
+}
+
+// archiveEntryName cleans an entry name, reporting false for names that are
+// empty, absolute or escape their directory.
+func archiveEntryName(name string) (string, bool) {
+	name = strings.ReplaceAll(name, `\`, "/")
+	if name == "" || strings.HasPrefix(name, "/") || strings.ContainsRune(name, 0) ||
+		len(name) >= 2 && name[1] == ':' {
+		return "", false
+	}
+	for _, seg := range strings.Split(name, "/") {
+		if seg == ".." {
+			return "", false
+		}
+	}
+	name = path.Clean(name)
+	return name, name != "."
+}
+
+// archiveWriter adds entries to a zip or tar.gz stream.
+type archiveWriter struct {
+	zw *zip.Writer
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func newArchiveWriter(w io.Writer, format ArchiveFormat) (*archiveWriter, error) {
+	switch format {
+	case ArchiveZip:
+		return &archiveWriter{zw: zip.NewWriter(w)}, nil
+	case ArchiveTarGz:
+		gz := gzip.NewWriter(w)
+		return &archiveWriter{gz: gz, tw: tar.NewWriter(gz)}, nil
+	}
+	return nil, unsupportedArchive(format)
+}
+
+func (a *archiveWriter) add(name string, info ObjectInfo, r io.Reader) error {
+	modified := ParseLastModified(info.LastModified)
+	if a.zw != nil {
+		w, err := a.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, r)
+		return err
+	}
+	err := a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     info.Size,
+		Mode:     0o644,
+		ModTime:  modified,
+		Format:   tar.FormatPAX,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(a.tw, r, info.Size)
+	return err
+}
+
+func (a *archiveWriter) close() error {
+	if a.zw != nil {
+		return a.zw.Close()
+	}
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+// extractor writes archive entries to storage within limits.
+type extractor struct {
+	folder  string
+	prefix  string
+	limits  ArchiveLimits
+	entries int
+	total   int64
+	keys    []string
+	written map[string]bool
+}
+
+func (x *extractor) zip(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return invalidArchive(err)
+	}
+	if len(zr.File) > x.limits.MaxEntries {
+		return archiveLimitError("archive has more than " + strconv.Itoa(x.limits.MaxEntries) + " entries")
+	}
+	for _, f := range zr.File {
+		x.entries++
+		mode := f.Mode()
+		if mode.IsDir() {
+			continue
+		}
+		if !mode.IsRegular() {
+			return unsafeEntry(f.Name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return invalidArchive(err)
+		}
+		err = x.store(f.Name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *extractor) tarGz(r io.Reader) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return invalidArchive(err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return invalidArchive(err)
+		}
+		if x.entries++; x.entries > x.limits.MaxEntries {
+			return archiveLimitError("archive has more than " + strconv.Itoa(x.limits.MaxEntries) + " entries")
+		}
+		switch h.Typeflag {
+		case tar.TypeDir, tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeReg:
+		default:
+			return unsafeEntry(h.Name)
+		}
+		if err := x.store(h.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// store streams one file to storage, counting the bytes read against the
+// limits.
+func (x *extractor) store(entry string, r io.Reader) error {
+	name, ok := archiveEntryName(entry)
+	if !ok {
+		return unsafeEntry(entry)
+	}
+	key := x.prefix + name
+	if x.written[key] {
+		return entryExists(entry, key)
+	}
+	if exists, err := StorageExists(x.folder, key); err != nil {
+		return err
+	} else if exists {
+		return entryExists(entry, key)
+	}
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w := StorageCreateWriter(x.folder, key, contentType).(*StorageWriter)
+	limit := min(x.limits.MaxEntrySize, x.limits.MaxTotalSize-x.total)
+	src := &entryReader{r: io.LimitReader(r, limit+1)}
+	n, err := io.Copy(w, src)
+	if src.err != nil {
+		err = invalidArchive(src.err)
+	}
+	if err == nil && n > limit {
+		if n > x.limits.MaxEntrySize {
+			err = archiveLimitError(entry + " exceeds the maximum entry size of " + strconv.FormatInt(x.limits.MaxEntrySize, 10) + " bytes")
+		} else {
+			err = archiveLimitError("archive exceeds " + strconv.FormatInt(x.limits.MaxTotalSize, 10) + " bytes uncompressed")
+		}
+	}
+	if err != nil {
+		w.Abort()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	x.total += n
+	x.keys = append(x.keys, key)
+	x.written[key] = true
+	return nil
+}
+
+// entryReader records decompression errors, so they can be told apart from
+// storage errors.
+type entryReader struct {
+	r   io.Reader
+	err error
+}
+
+func (e *entryReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF {
+		e.err = err
+	}
+	return n, err
+}
+
+func unsupportedArchive(format ArchiveFormat) error {
+	return &wafer.WaferError{
+		Code:    "invalid_argument",
+		Message: "unsupported archive format " + strconv.Quote(string(format)),
+	}
+}
+
+func invalidArchive(err error) error {
+	return &wafer.WaferError{
+		Code:    "invalid_argument",
+		Message: "invalid archive: " + err.Error(),
+	}
+}
+
+func unsafeEntry(name string) error {
+	return &wafer.WaferError{
+		Code:    "invalid_argument",
+		Message: "archive entry " + strconv.Quote(name) + " is not a regular file with a relative path",
+		Meta:    map[string]string{"entry": name},
+	}
+}
+
+func entryExists(name, key string) error {
+	return &wafer.WaferError{
+		Code:    "already_exists",
+		Message: "archive entry " + strconv.Quote(name) + " would replace " + key,
+		Meta:    map[string]string{"entry": name, "key": key},
+	}
+}
+
+func archiveLimitError(message string) error {
+	return &wafer.WaferError{
+		Code:    "resource_exhausted",
+		Message: message,
+	}
+}
@@ -0,0 +1,229 @@
+package services_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/fs"
+	"testing"
+
+	. "github.com/wafer-run/wafer-sdk-go/services"
+)
+
+// entry is a file in a test archive.
+type entry struct {
+	name     string
+	data     string
+	typeflag byte        // tar only; zero means a regular file
+	mode     fs.FileMode // zip only
+}
+
+func zipArchive(t *testing.T, entries ...entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		h := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		if e.mode != 0 {
+			h.SetMode(e.mode)
+		}
+		w, err := zw.CreateHeader(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(e.data))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarGzArchive(t *testing.T, entries ...entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Typeflag: e.typeflag, Mode: 0o644, Size: int64(len(e.data))}
+		switch e.typeflag {
+		case 0:
+			h.Typeflag = tar.TypeReg
+		case tar.TypeSymlink:
+			h.Linkname, h.Size = e.data, 0
+		case tar.TypeXGlobalHeader:
+			h = &tar.Header{Typeflag: e.typeflag, PAXRecords: map[string]string{"comment": e.data}}
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Size > 0 {
+			tw.Write([]byte(e.data))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractArchive(t *testing.T) {
+	tests := []struct {
+		name     string
+		archive  func(t *testing.T) []byte
+		existing map[string]string
+		code     string
+		want     map[string]string // objects in folder "files" afterwards
+	}{
+		{
+			name: "zip",
+			archive: func(t *testing.T) []byte {
+				return zipArchive(t, entry{name: "a.txt", data: "a"}, entry{name: "d/b.txt", data: "b"})
+			},
+			want: map[string]string{"up/a.txt": "a", "up/d/b.txt": "b"},
+		},
+		{
+			name:    "tar.gz",
+			archive: func(t *testing.T) []byte { return tarGzArchive(t, entry{name: "a.txt", data: "a"}) },
+			want:    map[string]string{"up/a.txt": "a"},
+		},
+		{
+			name: "tar.gz with global header",
+			archive: func(t *testing.T) []byte {
+				return tarGzArchive(t, entry{name: "pax_global_header", data: "git", typeflag: tar.TypeXGlobalHeader}, entry{name: "a.txt", data: "a"})
+			},
+			want: map[string]string{"up/a.txt": "a"},
+		},
+		{
+			name: "zip slip",
+			archive: func(t *testing.T) []byte {
+				return zipArchive(t, entry{name: "a.txt", data: "a"}, entry{name: "../evil.txt", data: "x"})
+			},
+			code: "invalid_argument",
+			want: map[string]string{},
+		},
+		{
+			name:    "zip slip through a directory",
+			archive: func(t *testing.T) []byte { return zipArchive(t, entry{name: "d/../../evil.txt", data: "x"}) },
+			code:    "invalid_argument",
+			want:    map[string]string{},
+		},
+		{
+			name:    "zip slip with backslashes",
+			archive: func(t *testing.T) []byte { return zipArchive(t, entry{name: `..\evil.txt`, data: "x"}) },
+			code:    "invalid_argument",
+			want:    map[string]string{},
+		},
+		{
+			name:    "absolute path",
+			archive: func(t *testing.T) []byte { return zipArchive(t, entry{name: "/etc/evil", data: "x"}) },
+			code:    "invalid_argument",
+			want:    map[string]string{},
+		},
+		{
+			name:    "drive letter",
+			archive: func(t *testing.T) []byte { return zipArchive(t, entry{name: "C:/evil", data: "x"}) },
+			code:    "invalid_argument",
+			want:    map[string]string{},
+		},
+		{
+			name:    "tar slip",
+			archive: func(t *testing.T) []byte { return tarGzArchive(t, entry{name: "../../evil.txt", data: "x"}) },
+			code:    "invalid_argument",
+			want:    map[string]string{},
+		},
+		{
+			name: "zip symlink",
+			archive: func(t *testing.T) []byte {
+				return zipArchive(t, entry{name: "link", data: "/etc/passwd", mode: fs.ModeSymlink | 0o777})
+			},
+			code: "invalid_argument",
+			want: map[string]string{},
+		},
+		{
+			name: "tar symlink",
+			archive: func(t *testing.T) []byte {
+				return tarGzArchive(t, entry{name: "link", data: "../../etc", typeflag: tar.TypeSymlink})
+			},
+			code: "invalid_argument",
+			want: map[string]string{},
+		},
+		{
+			name: "existing object",
+			archive: func(t *testing.T) []byte {
+				return zipArchive(t, entry{name: "b.txt", data: "b"}, entry{name: "a.txt", data: "new"})
+			},
+			existing: map[string]string{"up/a.txt": "old"},
+			code:     "already_exists",
+			want:     map[string]string{"up/a.txt": "old"},
+		},
+		{
+			name: "duplicate entries",
+			archive: func(t *testing.T) []byte {
+				return zipArchive(t, entry{name: "a.txt", data: "1"}, entry{name: "./a.txt", data: "2"})
+			},
+			code: "already_exists",
+			want: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, st := setup(t)
+			for key, data := range tt.existing {
+				st.Put("files", key, []byte(data), "text/plain")
+			}
+			data := tt.archive(t)
+			keys, err := ExtractArchive(bytes.NewReader(data), int64(len(data)), "", "files", "up/", ArchiveLimits{})
+			if tt.code != "" {
+				if !isCode(err, tt.code) {
+					t.Fatalf("ExtractArchive error = %v, want %s", err, tt.code)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if len(keys) != len(tt.want) {
+				t.Errorf("keys = %v, want %d", keys, len(tt.want))
+			}
+			list, err := StorageList("files", "", 100, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(list.Objects) != len(tt.want) {
+				t.Errorf("%d objects stored, want %d", len(list.Objects), len(tt.want))
+			}
+			for key, want := range tt.want {
+				if got, ok := st.Object("files", key); !ok || string(got) != want {
+					t.Errorf("%s = %q, %v; want %q", key, got, ok, want)
+				}
+			}
+			if _, ok := st.Object("", "evil.txt"); ok {
+				t.Error("entry escaped its folder")
+			}
+		})
+	}
+}
+
+func TestServeArchiveSizeCap(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		limits ArchiveLimits
+		status string
+	}{
+		{name: "small", size: 10, status: "200"},
+		{name: "over the cap", size: ServeArchiveMaxSize + 1, limits: ArchiveLimits{MaxTotalSize: 1 << 40}, status: "413"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, st := setup(t)
+			st.Put("files", "a.bin", make([]byte, tt.size), "application/octet-stream")
+			r := ServeArchive(ArchiveZip, "files", "", "a.zip", tt.limits)
+			if got := status(r); got != tt.status {
+				t.Errorf("status = %s, want %s", got, tt.status)
+			}
+		})
+	}
+}
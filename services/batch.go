@@ -0,0 +1,223 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+	"github.com/wafer-run/wafer-sdk-go/gen/wafer/database"
+)
+
+// BatchChunkSize is the number of items sent to the host per batch call, and
+// the page size used when falling back to single calls.
+const BatchChunkSize = 500
+
+// BatchResult is the per-item outcome of a batch operation. Err is nil when
+// the item succeeded.
+type BatchResult struct {
+	Record Record
+	Err    error
+}
+
+// DatabaseCreateMany inserts every item into collection. Each item is
+// JSON-encoded like DatabaseCreate. Results are returned in input order; a
+// failed item does not stop the rest of the batch. When the host does not
+// support batch writes, the items are created with chunked single calls.
+func DatabaseCreateMany[T any](collection string, items []T) ([]BatchResult, error) {
+	results := make([]BatchResult, len(items))
+	var pending []int
+	var payloads []string
+	for i, item := range items {
+		jsonData, err := json.Marshal(item)
+		if err != nil {
+			results[i].Err = &wafer.WaferError{
+				Code:    "internal",
+				Message: "failed to marshal record: " + err.Error(),
+			}
+			continue
+		}
+		if err := validateRecord(collection, string(jsonData)); err != nil {
+			results[i].Err = err
+			continue
+		}
+		pending = append(pending, i)
+		payloads = append(payloads, string(jsonData))
+	}
+
+	for start := 0; start < len(pending); start += BatchChunkSize {
+		end := min(start+BatchChunkSize, len(pending))
+		if database.CreateMany != nil {
+			batch, err := database.CreateMany(collection, payloads[start:end])
+			if err == nil {
+				if len(batch) != end-start {
+					return results, &wafer.WaferError{
+						Code:    "internal",
+						Message: "host returned " + strconv.Itoa(len(batch)) + " results for a batch of " + strconv.Itoa(end-start),
+					}
+				}
+				for j, item := range batch {
+					results[pending[start+j]] = batchResult(item)
+				}
+				continue
+			}
+			if !isUnsupported(err) {
+				return results, err
+			}
+		}
+		for j := start; j < end; j++ {
+			rec, err := database.Create(collection, payloads[j])
+			results[pending[j]] = BatchResult{Record: rec, Err: err}
+		}
+	}
+	return results, nil
+}
+
+// DatabaseUpdateMany merges the top-level fields of data into every record
+// matching filters and returns one result per matched record. When the host
+// does not support batch writes, matching records are fetched page by page
+// and updated with single calls. Collections with a registered schema always
+// take that path, so each merged record is validated before it is written.
+func DatabaseUpdateMany(collection string, filters []Filter, data any) ([]BatchResult, error) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, &wafer.WaferError{
+			Code:    "internal",
+			Message: "failed to marshal record: " + err.Error(),
+		}
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(jsonData, &fields); err != nil || fields == nil {
+		return nil, &wafer.WaferError{
+			Code:    "invalid_argument",
+			Message: "update data must be a JSON object",
+		}
+	}
+
+	if database.UpdateMany != nil && !hasSchema(collection) {
+		batch, err := database.UpdateMany(collection, filters, string(jsonData))
+		if err == nil {
+			return batchResults(batch), nil
+		}
+		if !isUnsupported(err) {
+			return nil, err
+		}
+	}
+
+	matched, err := listMatching(collection, filters)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]BatchResult, len(matched))
+	for i, rec := range matched {
+		merged, err := mergeFields(rec.Data, fields)
+		if err != nil {
+			results[i] = BatchResult{Record: rec, Err: err}
+			continue
+		}
+		if err := validateRecord(collection, merged); err != nil {
+			results[i] = BatchResult{Record: rec, Err: err}
+			continue
+		}
+		updated, err := database.Update(collection, rec.ID, merged)
+		results[i] = BatchResult{Record: updated, Err: err}
+		if err != nil {
+			results[i].Record = rec
+		}
+	}
+	return results, nil
+}
+
+// DatabaseDeleteMany removes every record matching filters and returns one
+// result per matched record. When the host does not support batch writes,
+// matching records are fetched page by page and deleted with single calls.
+func DatabaseDeleteMany(collection string, filters []Filter) ([]BatchResult, error) {
+	if database.DeleteMany != nil {
+		batch, err := database.DeleteMany(collection, filters)
+		if err == nil {
+			return batchResults(batch), nil
+		}
+		if !isUnsupported(err) {
+			return nil, err
+		}
+	}
+
+	matched, err := listMatching(collection, filters)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]BatchResult, len(matched))
+	for i, rec := range matched {
+		results[i] = BatchResult{Record: rec, Err: database.Delete(collection, rec.ID)}
+	}
+	return results, nil
+}
+
+// listMatching collects every record matching filters in BatchChunkSize
+// pages, ordered by ID so that pages are stable. All pages are read before
+// the caller starts writing so that updates which change filtered fields do
+// not shift later pages.
+func listMatching(collection string, filters []Filter) ([]Record, error) {
+	var out []Record
+	for offset := int64(0); ; offset += BatchChunkSize {
+		rl, err := database.List(collection, ListOptions{
+			Filters: filters,
+			Sort:    []SortField{{Field: "id"}},
+			Limit:   BatchChunkSize,
+			Offset:  offset,
+		})
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rl.Records...)
+		if len(rl.Records) < BatchChunkSize {
+			return out, nil
+		}
+	}
+}
+
+// mergeFields overlays fields onto the JSON object in data.
+func mergeFields(data string, fields map[string]json.RawMessage) (string, error) {
+	doc := make(map[string]json.RawMessage)
+	if data != "" {
+		if err := json.Unmarshal([]byte(data), &doc); err != nil {
+			return "", &wafer.WaferError{
+				Code:    "internal",
+				Message: "failed to decode record: " + err.Error(),
+			}
+		}
+	}
+	for k, v := range fields {
+		doc[k] = v
+	}
+	merged, err := json.Marshal(doc)
+	if err != nil {
+		return "", &wafer.WaferError{
+			Code:    "internal",
+			Message: "failed to marshal record: " + err.Error(),
+		}
+	}
+	return string(merged), nil
+}
+
+func batchResult(item database.BatchItem) BatchResult {
+	r := BatchResult{Record: item.Record}
+	if item.Error != nil {
+		r.Err = *item.Error
+	}
+	return r
+}
+
+func batchResults(items []database.BatchItem) []BatchResult {
+	out := make([]BatchResult, len(items))
+	for i, item := range items {
+		out[i] = batchResult(item)
+	}
+	return out
+}
+
+// isUnsupported reports whether err means the host does not implement the
+// requested operation.
+func isUnsupported(err error) bool {
+	return errors.Is(err, database.DatabaseErrorUnsupported)
+}
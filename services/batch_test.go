@@ -0,0 +1,112 @@
+package services_test
+
+import (
+	"testing"
+
+	"github.com/wafer-run/wafer-sdk-go/gen/wafer/database"
+	. "github.com/wafer-run/wafer-sdk-go/services"
+)
+
+type createManyFunc = func(collection string, data []string) ([]database.BatchItem, error)
+
+func TestDatabaseCreateMany(t *testing.T) {
+	tests := []struct {
+		name    string
+		wrap    func(host createManyFunc) createManyFunc
+		wantErr bool
+	}{
+		{
+			name: "host batch",
+			wrap: func(host createManyFunc) createManyFunc { return host },
+		},
+		{
+			name: "unsupported falls back to single creates",
+			wrap: func(createManyFunc) createManyFunc {
+				return func(string, []string) ([]database.BatchItem, error) {
+					return nil, database.DatabaseErrorUnsupported
+				}
+			},
+		},
+		{
+			name: "short host result",
+			wrap: func(host createManyFunc) createManyFunc {
+				return func(collection string, data []string) ([]database.BatchItem, error) {
+					items, err := host(collection, data)
+					return items[:len(items)-1], err
+				}
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := setup(t)
+			database.CreateMany = tt.wrap(db.CreateMany)
+			items := []map[string]any{{"n": 1}, {"n": 2}, {"n": 3}}
+			results, err := DatabaseCreateMany("items", items)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DatabaseCreateMany() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(results) != len(items) {
+				t.Fatalf("got %d results, want %d", len(results), len(items))
+			}
+			if tt.wantErr {
+				return
+			}
+			for i, r := range results {
+				if r.Err != nil || r.Record.ID == "" {
+					t.Errorf("result %d = %+v", i, r)
+				}
+			}
+		})
+	}
+}
+
+func TestDatabaseDeleteManyFallback(t *testing.T) {
+	tests := []struct {
+		name    string
+		records int
+		filters []Filter
+		want    int
+	}{
+		{name: "single page", records: 3, want: 3},
+		{name: "several pages", records: 2*BatchChunkSize + 1, want: 2*BatchChunkSize + 1},
+		{
+			name:    "filtered",
+			records: 10,
+			filters: []Filter{{Field: "even", Operator: OpEqual, Value: "true"}},
+			want:    5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := setup(t)
+			database.DeleteMany = nil
+			for i := 0; i < tt.records; i++ {
+				if _, err := DatabaseCreate("items", map[string]any{"n": i, "even": i%2 == 0}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			results, err := DatabaseDeleteMany("items", tt.filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen := make(map[string]bool)
+			for _, r := range results {
+				if r.Err != nil {
+					t.Errorf("delete %s: %v", r.Record.ID, r.Err)
+				}
+				if seen[r.Record.ID] {
+					t.Errorf("record %s deleted twice", r.Record.ID)
+				}
+				seen[r.Record.ID] = true
+			}
+			if len(results) != tt.want {
+				t.Errorf("deleted %d records, want %d", len(results), tt.want)
+			}
+			if left := len(db.Records("items")); left != tt.records-tt.want {
+				t.Errorf("%d records left, want %d", left, tt.records-tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+	"github.com/wafer-run/wafer-sdk-go/gen/wafer/storage"
+)
+
+// Defaults used by ContentStoreOptions.
+const (
+	DefaultBlobFolder     = "_wafer_blobs"
+	DefaultBlobCollection = "_wafer_blob_refs"
+)
+
+// ContentStoreOptions configures a ContentStore.
+type ContentStoreOptions struct {
+	// Folder holds the blobs, keyed by digest. Defaults to DefaultBlobFolder.
+	Folder string
+	// Collection holds one alias record per name. Defaults to
+	// DefaultBlobCollection.
+	Collection string
+}
+
+func (o ContentStoreOptions) withDefaults() ContentStoreOptions {
+	if o.Folder == "" {
+		o.Folder = DefaultBlobFolder
+	}
+	if o.Collection == "" {
+		o.Collection = DefaultBlobCollection
+	}
+	return o
+}
+
+// Blob describes the content stored under a name.
+type Blob struct {
+	Name        string `json:"name"`
+	Digest      string `json:"digest"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+}
+
+// ContentStore is a content-addressed object store. Content is stored once
+// per SHA-256 digest, however many names refer to it; names are alias
+// records in a database collection, and a blob is removed when its last
+// alias is. Reads verify the digest and fail with "data_loss" if the stored
+// content no longer matches.
+//
+// Reference counting spans separate storage and database calls, so a Put
+// racing the Delete of the last alias of the same content can lose the blob.
+type ContentStore struct {
+	opts ContentStoreOptions
+}
+
+// NewContentStore creates a ContentStore.
+func NewContentStore(opts ContentStoreOptions) *ContentStore {
+	return &ContentStore{opts: opts.withDefaults()}
+}
+
+// Put stores data under name, replacing whatever name referred to before.
+// Content already in the store is not uploaded again.
+func (c *ContentStore) Put(name string, data []byte, contentType string) (Blob, error) {
+	sum := sha256.Sum256(data)
+	blob := Blob{Name: name, Digest: hex.EncodeToString(sum[:]), Size: int64(len(data)), ContentType: contentType}
+	exists, err := StorageExists(c.opts.Folder, c.blobKey(blob.Digest))
+	if err != nil {
+		return Blob{}, err
+	}
+	if !exists {
+		if err := StoragePut(c.opts.Folder, c.blobKey(blob.Digest), data, contentType); err != nil {
+			return Blob{}, err
+		}
+	}
+	return blob, c.link(blob)
+}
+
+// PutFrom stores everything read from r under name. The content is streamed
+// to a temporary key while it is hashed, then moved to its digest key, or
+// discarded if that content is already stored. The blob folder's upload
+// policy applies as for Put.
+func (c *ContentStore) PutFrom(name string, r io.Reader, contentType string) (Blob, error) {
+	suffix, err := CryptoRandomBytes(16)
+	if err != nil {
+		return Blob{}, err
+	}
+	tmp := "tmp/" + hex.EncodeToString(suffix)
+	w := &StorageWriter{folder: c.opts.Folder, key: tmp, contentType: contentType, check: newUploadCheck(c.opts.Folder, contentType)}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, h), r)
+	if err != nil {
+		w.Abort()
+		return Blob{}, err
+	}
+	if err := w.Close(); err != nil {
+		return Blob{}, err
+	}
+	blob := Blob{Name: name, Digest: hex.EncodeToString(h.Sum(nil)), Size: n, ContentType: contentType}
+	exists, err := StorageExists(c.opts.Folder, c.blobKey(blob.Digest))
+	if err == nil && !exists {
+		_, err = StorageMove(c.opts.Folder, tmp, c.opts.Folder, c.blobKey(blob.Digest))
+	} else {
+		StorageDelete(c.opts.Folder, tmp)
+	}
+	if err != nil {
+		return Blob{}, err
+	}
+	return blob, c.link(blob)
+}
+
+// Get returns the content stored under name after verifying its digest.
+func (c *ContentStore) Get(name string) ([]byte, Blob, error) {
+	blob, _, err := c.lookup(name)
+	if err != nil {
+		return nil, Blob{}, err
+	}
+	data, _, err := StorageGet(c.opts.Folder, c.blobKey(blob.Digest))
+	if err != nil {
+		return nil, Blob{}, c.blobError(blob, err)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != blob.Digest || int64(len(data)) != blob.Size {
+		return nil, Blob{}, digestMismatch(blob)
+	}
+	return data, blob, nil
+}
+
+// Open returns a streaming reader over the content stored under name. The
+// digest is checked as the content is read: the final Read returns a
+// "data_loss" error instead of io.EOF if it does not match.
+func (c *ContentStore) Open(name string) (io.ReadCloser, Blob, error) {
+	blob, _, err := c.lookup(name)
+	if err != nil {
+		return nil, Blob{}, err
+	}
+	r, err := openStorageReader(c.opts.Folder, c.blobKey(blob.Digest))
+	if err != nil {
+		return nil, Blob{}, c.blobError(blob, err)
+	}
+	return &verifyingReader{r: r, h: sha256.New(), blob: blob}, blob, nil
+}
+
+// Stat returns the blob stored under name.
+func (c *ContentStore) Stat(name string) (Blob, error) {
+	blob, _, err := c.lookup(name)
+	return blob, err
+}
+
+// Delete removes name, and the blob it refers to once no other name does.
+func (c *ContentStore) Delete(name string) error {
+	blob, id, err := c.lookup(name)
+	if err != nil {
+		return err
+	}
+	if err := DatabaseDelete(c.opts.Collection, id); err != nil {
+		return err
+	}
+	return c.release(blob.Digest)
+}
+
+// References returns the number of names that refer to a digest.
+func (c *ContentStore) References(digest string) (int64, error) {
+	return DatabaseCount(c.opts.Collection, []Filter{{Field: "digest", Operator: OpEqual, Value: jsonValue(digest)}})
+}
+
+// link points name at blob, releasing the blob it referred to before.
+func (c *ContentStore) link(blob Blob) error {
+	previous, _, err := c.lookup(blob.Name)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	if _, err := DatabaseUpsert(c.opts.Collection, c.nameFilter(blob.Name), blob); err != nil {
+		return err
+	}
+	if previous.Digest != "" && previous.Digest != blob.Digest {
+		return c.release(previous.Digest)
+	}
+	return nil
+}
+
+// release deletes a blob that is no longer referenced.
+func (c *ContentStore) release(digest string) error {
+	refs, err := c.References(digest)
+	if err != nil || refs > 0 {
+		return err
+	}
+	err = StorageDelete(c.opts.Folder, c.blobKey(digest))
+	if errors.Is(err, storage.StorageErrorNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (c *ContentStore) lookup(name string) (Blob, string, error) {
+	rl, err := DatabaseList(c.opts.Collection, ListOptions{Filters: c.nameFilter(name), Limit: 1})
+	if err != nil {
+		return Blob{}, "", err
+	}
+	if len(rl.Records) == 0 {
+		return Blob{}, "", &wafer.WaferError{
+			Code:    "not_found",
+			Message: "blob " + name + " not found",
+		}
+	}
+	var blob Blob
+	if err := json.Unmarshal([]byte(rl.Records[0].Data), &blob); err != nil {
+		return Blob{}, "", &wafer.WaferError{
+			Code:    "internal",
+			Message: "failed to decode blob record: " + err.Error(),
+		}
+	}
+	return blob, rl.Records[0].ID, nil
+}
+
+func (c *ContentStore) nameFilter(name string) []Filter {
+	return []Filter{{Field: "name", Operator: OpEqual, Value: jsonValue(name)}}
+}
+
+// blobKey spreads blobs over prefixes by the first digest byte.
+func (c *ContentStore) blobKey(digest string) string {
+	return "sha256/" + digest[:min(2, len(digest))] + "/" + digest
+}
+
+// blobError reports a blob missing behind an existing alias as data loss.
+func (c *ContentStore) blobError(blob Blob, err error) error {
+	if !errors.Is(err, storage.StorageErrorNotFound) {
+		return err
+	}
+	return &wafer.WaferError{
+		Code:    "data_loss",
+		Message: "blob " + blob.Digest + " for " + blob.Name + " is missing",
+	}
+}
+
+func digestMismatch(blob Blob) error {
+	return &wafer.WaferError{
+		Code:    "data_loss",
+		Message: "content of " + blob.Name + " does not match digest " + blob.Digest,
+	}
+}
+
+func isNotFound(err error) bool {
+	var we *wafer.WaferError
+	return errors.As(err, &we) && we.Code == "not_found"
+}
+
+// verifyingReader hashes content as it is read and checks the digest at EOF.
+type verifyingReader struct {
+	r    *StorageReader
+	h    hash.Hash
+	n    int64
+	blob Blob
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.h.Write(p[:n])
+	v.n += int64(n)
+	if err == io.EOF && (v.n != v.blob.Size || hex.EncodeToString(v.h.Sum(nil)) != v.blob.Digest) {
+		return n, digestMismatch(v.blob)
+	}
+	return n, err
+}
+
+func (v *verifyingReader) Close() error {
+	return v.r.Close()
+}
@@ -0,0 +1,162 @@
+package services_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+
+	. "github.com/wafer-run/wafer-sdk-go/services"
+	"github.com/wafer-run/wafer-sdk-go/services/fakes"
+)
+
+// blobKeys lists the objects in the default blob folder.
+func blobKeys(t *testing.T) []string {
+	t.Helper()
+	list, err := StorageList(DefaultBlobFolder, "", 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, o := range list.Objects {
+		keys = append(keys, o.Key)
+	}
+	return keys
+}
+
+func TestContentStoreDedup(t *testing.T) {
+	setup(t)
+	cs := NewContentStore(ContentStoreOptions{})
+	a, err := cs.Put("a", []byte("same"), "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.Put("b", []byte("same"), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	c, err := cs.PutFrom("c", strings.NewReader("same"), "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("same"))
+	if a.Digest != hex.EncodeToString(sum[:]) || c.Digest != a.Digest || c.Size != 4 {
+		t.Fatalf("blobs = %+v, %+v", a, c)
+	}
+	if keys := blobKeys(t); len(keys) != 1 {
+		t.Fatalf("blob folder holds %v, want one blob", keys)
+	}
+	if refs, err := cs.References(a.Digest); err != nil || refs != 3 {
+		t.Fatalf("References = %d, %v; want 3", refs, err)
+	}
+
+	// Pointing a name at other content releases nothing still referenced.
+	if _, err := cs.Put("a", []byte("other"), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if keys := blobKeys(t); len(keys) != 2 {
+		t.Fatalf("blob folder holds %v, want two blobs", keys)
+	}
+	if err := cs.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if keys := blobKeys(t); len(keys) != 2 {
+		t.Fatalf("blob removed before its last alias: %v", keys)
+	}
+	if err := cs.Delete("c"); err != nil {
+		t.Fatal(err)
+	}
+	if keys := blobKeys(t); len(keys) != 1 {
+		t.Fatalf("blob folder holds %v after deleting the last alias, want one blob", keys)
+	}
+	if _, _, err := cs.Get("b"); !isCode(err, "not_found") {
+		t.Errorf("Get deleted name = %v, want not_found", err)
+	}
+	if data, _, err := cs.Get("a"); err != nil || string(data) != "other" {
+		t.Errorf("Get = %q, %v; want other", data, err)
+	}
+}
+
+func TestContentStoreIntegrity(t *testing.T) {
+	blobKey := func(data string) string {
+		sum := sha256.Sum256([]byte(data))
+		digest := hex.EncodeToString(sum[:])
+		return "sha256/" + digest[:2] + "/" + digest
+	}
+	tests := []struct {
+		name   string
+		tamper func(st *fakes.Storage)
+		code   string
+	}{
+		{name: "intact"},
+		{
+			name:   "same length",
+			tamper: func(st *fakes.Storage) { st.Put(DefaultBlobFolder, blobKey("hello"), []byte("jello"), "text/plain") },
+			code:   "data_loss",
+		},
+		{
+			name:   "truncated",
+			tamper: func(st *fakes.Storage) { st.Put(DefaultBlobFolder, blobKey("hello"), []byte("hell"), "text/plain") },
+			code:   "data_loss",
+		},
+		{
+			name:   "missing",
+			tamper: func(st *fakes.Storage) { StorageDelete(DefaultBlobFolder, blobKey("hello")) },
+			code:   "data_loss",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, st := setup(t)
+			cs := NewContentStore(ContentStoreOptions{})
+			if _, err := cs.Put("a", []byte("hello"), "text/plain"); err != nil {
+				t.Fatal(err)
+			}
+			if tt.tamper != nil {
+				tt.tamper(st)
+			}
+
+			data, _, err := cs.Get("a")
+			if tt.code != "" {
+				if !isCode(err, tt.code) {
+					t.Errorf("Get error = %v, want %s", err, tt.code)
+				}
+			} else if err != nil || string(data) != "hello" {
+				t.Errorf("Get = %q, %v; want hello", data, err)
+			}
+
+			r, _, err := cs.Open("a")
+			if err == nil {
+				data, err = io.ReadAll(r)
+				r.Close()
+			}
+			if tt.code != "" {
+				if !isCode(err, tt.code) {
+					t.Errorf("Open and read error = %v, want %s", err, tt.code)
+				}
+			} else if err != nil || string(data) != "hello" {
+				t.Errorf("Open and read = %q, %v; want hello", data, err)
+			}
+		})
+	}
+}
+
+func TestContentStorePutFromPolicy(t *testing.T) {
+	_, st := setup(t)
+	registerPolicy(t, DefaultBlobFolder, UploadPolicy{MaxSize: StorageChunkSize})
+	cs := NewContentStore(ContentStoreOptions{})
+	data := bytes.Repeat([]byte("x"), StorageChunkSize+1)
+	if _, err := cs.PutFrom("big", bytes.NewReader(data), "text/plain"); policyReason(err) != UploadTooLarge {
+		t.Fatalf("PutFrom = %v, want %s", err, UploadTooLarge)
+	}
+	if keys := blobKeys(t); len(keys) != 0 {
+		t.Errorf("blob folder holds %v, want nothing", keys)
+	}
+	if st.Uploads() != 0 {
+		t.Errorf("%d uploads still open", st.Uploads())
+	}
+	if _, err := cs.Stat("big"); !isCode(err, "not_found") {
+		t.Errorf("Stat = %v, want not_found", err)
+	}
+}
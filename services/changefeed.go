@@ -0,0 +1,297 @@
+package services
+
+import (
+	"encoding/json"
+	"slices"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+	"github.com/wafer-run/wafer-sdk-go/gen/wafer/database"
+)
+
+// KindDatabaseChange is the Message kind used to deliver change feed events
+// to a block.
+const KindDatabaseChange = "database.change"
+
+// DefaultCheckpointsCollection is the collection that stores the checkpoints
+// of named subscriptions.
+const DefaultCheckpointsCollection = "_wafer_checkpoints"
+
+// ChangeOp is a convenience alias for database.ChangeOp.
+type ChangeOp = database.ChangeOp
+
+// WatchOptions is a convenience alias for database.WatchOptions.
+type WatchOptions = database.WatchOptions
+
+// Re-export change operation constants for convenience.
+const (
+	ChangeCreate = database.ChangeOpCreate
+	ChangeUpdate = database.ChangeOpUpdate
+	ChangeDelete = database.ChangeOpDelete
+)
+
+var changeOpNames = [...]string{"create", "update", "delete"}
+
+// ChangeEvent is a create, update or delete of a record, delivered as the
+// JSON payload of a KindDatabaseChange message. Before is nil for creates and
+// After is nil for deletes.
+type ChangeEvent struct {
+	Subscription string
+	Collection   string
+	Op           ChangeOp
+	ID           string
+	Before       *Record
+	After        *Record
+	Checkpoint   string
+	Timestamp    string
+}
+
+// changeEventJSON is the wire form of a ChangeEvent. Records are embedded as
+// JSON documents and the operation is spelled out.
+type changeEventJSON struct {
+	Subscription string          `json:"subscription"`
+	Collection   string          `json:"collection"`
+	Op           string          `json:"op"`
+	ID           string          `json:"id"`
+	Before       json.RawMessage `json:"before"`
+	After        json.RawMessage `json:"after"`
+	Checkpoint   string          `json:"checkpoint"`
+	Timestamp    string          `json:"timestamp,omitempty"`
+}
+
+// MarshalJSON encodes the event in its wire form.
+func (e ChangeEvent) MarshalJSON() ([]byte, error) {
+	w := changeEventJSON{
+		Subscription: e.Subscription,
+		Collection:   e.Collection,
+		ID:           e.ID,
+		Before:       json.RawMessage("null"),
+		After:        json.RawMessage("null"),
+		Checkpoint:   e.Checkpoint,
+		Timestamp:    e.Timestamp,
+	}
+	if int(e.Op) < len(changeOpNames) {
+		w.Op = changeOpNames[e.Op]
+	}
+	if e.Before != nil && e.Before.Data != "" {
+		w.Before = json.RawMessage(e.Before.Data)
+	}
+	if e.After != nil && e.After.Data != "" {
+		w.After = json.RawMessage(e.After.Data)
+	}
+	return json.Marshal(w)
+}
+
+// UnmarshalJSON decodes the event from its wire form.
+func (e *ChangeEvent) UnmarshalJSON(data []byte) error {
+	var w changeEventJSON
+	if err := json.Unmarshal(data, &w); err != nil {
+		return err
+	}
+	*e = ChangeEvent{
+		Subscription: w.Subscription,
+		Collection:   w.Collection,
+		ID:           w.ID,
+		Checkpoint:   w.Checkpoint,
+		Timestamp:    w.Timestamp,
+	}
+	for i, name := range changeOpNames {
+		if w.Op == name {
+			e.Op = ChangeOp(i)
+		}
+	}
+	if len(w.Before) > 0 && string(w.Before) != "null" {
+		e.Before = &Record{ID: w.ID, Data: string(w.Before)}
+	}
+	if len(w.After) > 0 && string(w.After) != "null" {
+		e.After = &Record{ID: w.ID, Data: string(w.After)}
+	}
+	return nil
+}
+
+// BeforeInto unmarshals the previous version of the record into v. It returns
+// a "not_found" WaferError for creates.
+func (e *ChangeEvent) BeforeInto(v any) error {
+	return decodeChangeRecord(e.Before, "before", v)
+}
+
+// AfterInto unmarshals the new version of the record into v. It returns a
+// "not_found" WaferError for deletes.
+func (e *ChangeEvent) AfterInto(v any) error {
+	return decodeChangeRecord(e.After, "after", v)
+}
+
+func decodeChangeRecord(rec *Record, which string, v any) error {
+	if rec == nil {
+		return &wafer.WaferError{
+			Code:    "not_found",
+			Message: "change event has no " + which + " record",
+		}
+	}
+	return json.Unmarshal([]byte(rec.Data), v)
+}
+
+// NewChangeMessage wraps an event in a KindDatabaseChange message. The
+// subscription, collection, operation and checkpoint are also set as meta so
+// chains can route on them without decoding the payload.
+func NewChangeMessage(e ChangeEvent) (*wafer.Message, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, &wafer.WaferError{
+			Code:    "internal",
+			Message: "failed to marshal change event: " + err.Error(),
+		}
+	}
+	msg := &wafer.Message{Kind: KindDatabaseChange, Data: data}
+	msg.SetMeta("change.subscription", e.Subscription)
+	msg.SetMeta("change.collection", e.Collection)
+	if int(e.Op) < len(changeOpNames) {
+		msg.SetMeta("change.op", changeOpNames[e.Op])
+	}
+	msg.SetMeta("change.checkpoint", e.Checkpoint)
+	return msg, nil
+}
+
+// IsChangeEvent reports whether msg carries a change feed event.
+func IsChangeEvent(msg *wafer.Message) bool {
+	return msg.Kind == KindDatabaseChange
+}
+
+// ParseChangeEvent decodes the change feed event carried by msg.
+func ParseChangeEvent(msg *wafer.Message) (ChangeEvent, error) {
+	var e ChangeEvent
+	if !IsChangeEvent(msg) {
+		return e, &wafer.WaferError{
+			Code:    "invalid_argument",
+			Message: "message kind " + msg.Kind + " is not " + KindDatabaseChange,
+		}
+	}
+	if err := json.Unmarshal(msg.Data, &e); err != nil {
+		return e, &wafer.WaferError{
+			Code:    "invalid_argument",
+			Message: "failed to decode change event: " + err.Error(),
+		}
+	}
+	return e, nil
+}
+
+// DatabaseWatch subscribes to changes on a collection and returns the
+// subscription ID. Events are delivered to the block's Handle method as
+// KindDatabaseChange messages.
+func DatabaseWatch(collection string, opts WatchOptions) (string, error) {
+	if database.Watch == nil {
+		return "", unsupportedChangeFeed()
+	}
+	id, err := database.Watch(collection, opts)
+	if isUnsupported(err) {
+		return "", unsupportedChangeFeed()
+	}
+	return id, err
+}
+
+// DatabaseUnwatch cancels a subscription.
+func DatabaseUnwatch(subscription string) error {
+	if database.Unwatch == nil {
+		return unsupportedChangeFeed()
+	}
+	err := database.Unwatch(subscription)
+	if isUnsupported(err) {
+		return unsupportedChangeFeed()
+	}
+	return err
+}
+
+// DatabaseWatchResumable subscribes to changes on a collection under a durable
+// name. The subscription resumes after the last checkpoint committed for that
+// name with CommitCheckpoint, so events are not lost across restarts as long
+// as the host retains them.
+func DatabaseWatchResumable(name, collection string, opts WatchOptions) (string, error) {
+	checkpoint, err := LoadCheckpoint(name)
+	if err != nil {
+		return "", err
+	}
+	if checkpoint != "" {
+		opts.ResumeFrom = checkpoint
+	}
+	return DatabaseWatch(collection, opts)
+}
+
+// LoadCheckpoint returns the last checkpoint committed for a named
+// subscription, or "" if none has been committed.
+func LoadCheckpoint(name string) (string, error) {
+	rl, err := DatabaseList(DefaultCheckpointsCollection, ListOptions{
+		Filters: []Filter{{Field: "name", Operator: OpEqual, Value: jsonValue(name)}},
+		Limit:   1,
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(rl.Records) == 0 {
+		return "", nil
+	}
+	var doc struct {
+		Checkpoint string `json:"checkpoint"`
+	}
+	if err := json.Unmarshal([]byte(rl.Records[0].Data), &doc); err != nil {
+		return "", &wafer.WaferError{
+			Code:    "internal",
+			Message: "failed to decode checkpoint: " + err.Error(),
+		}
+	}
+	return doc.Checkpoint, nil
+}
+
+// checkpointHistory is how many superseded checkpoints CommitCheckpoint
+// remembers per subscription, so that late commits of them are recognised.
+const checkpointHistory = 64
+
+// checkpointDoc is the stored form of a named subscription's checkpoint.
+type checkpointDoc struct {
+	Name       string   `json:"name"`
+	Checkpoint string   `json:"checkpoint"`
+	Previous   []string `json:"previous,omitempty"`
+}
+
+// CommitCheckpoint records that every event up to and including checkpoint
+// has been processed for a named subscription. Blocks call it after handling
+// an event, typically with ChangeEvent.Checkpoint. Checkpoints are opaque, so
+// any checkpoint other than the committed one replaces it, except one of the
+// last checkpointHistory it replaced: committing those again, as a late or
+// retried handler does, is ignored rather than moving the subscription back.
+func CommitCheckpoint(name, checkpoint string) error {
+	filters := []Filter{{Field: "name", Operator: OpEqual, Value: jsonValue(name)}}
+	return RetryOnConflict(0, func() error {
+		rl, err := DatabaseList(DefaultCheckpointsCollection, ListOptions{Filters: filters, Limit: 1})
+		if err != nil {
+			return err
+		}
+		if len(rl.Records) == 0 {
+			_, err := DatabaseUpsert(DefaultCheckpointsCollection, filters, checkpointDoc{Name: name, Checkpoint: checkpoint})
+			return err
+		}
+		var current checkpointDoc
+		if err := json.Unmarshal([]byte(rl.Records[0].Data), &current); err != nil {
+			return &wafer.WaferError{
+				Code:    "internal",
+				Message: "failed to decode checkpoint: " + err.Error(),
+			}
+		}
+		if checkpoint == current.Checkpoint || slices.Contains(current.Previous, checkpoint) {
+			return nil
+		}
+		previous := append(current.Previous, current.Checkpoint)
+		doc := checkpointDoc{
+			Name:       name,
+			Checkpoint: checkpoint,
+			Previous:   previous[max(len(previous)-checkpointHistory, 0):],
+		}
+		_, err = DatabaseUpdateIf(DefaultCheckpointsCollection, rl.Records[0].ID, "checkpoint", current.Checkpoint, doc)
+		return err
+	})
+}
+
+func unsupportedChangeFeed() error {
+	return &wafer.WaferError{
+		Code:    "unimplemented",
+		Message: "the host does not support database change feeds",
+	}
+}
@@ -0,0 +1,82 @@
+package services_test
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+	. "github.com/wafer-run/wafer-sdk-go/services"
+)
+
+func TestCommitCheckpoint(t *testing.T) {
+	tests := []struct {
+		name    string
+		commits []string
+		want    string
+	}{
+		{name: "first", commits: []string{"7"}, want: "7"},
+		{name: "forward", commits: []string{"7", "8", "12"}, want: "12"},
+		{name: "repeat", commits: []string{"7", "7"}, want: "7"},
+		{name: "opaque tokens", commits: []string{"zz", "b", "a1"}, want: "a1"},
+		{name: "stale commit ignored", commits: []string{"7", "8", "12", "8"}, want: "12"},
+		{name: "stale commit of the first", commits: []string{"7", "8", "7"}, want: "8"},
+		{name: "stale commit within the history", commits: checkpointSeq(70, "c10"), want: "c69"},
+		{name: "commit past the history", commits: checkpointSeq(70, "c0"), want: "c0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := setup(t)
+			for _, c := range tt.commits {
+				if err := CommitCheckpoint("indexer", c); err != nil {
+					t.Fatalf("CommitCheckpoint(%q) error = %v", c, err)
+				}
+			}
+			got, err := LoadCheckpoint("indexer")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("LoadCheckpoint() = %q, want %q", got, tt.want)
+			}
+			if n := len(db.Records(DefaultCheckpointsCollection)); n != 1 {
+				t.Errorf("%d checkpoint records, want 1", n)
+			}
+		})
+	}
+}
+
+// checkpointSeq returns n distinct checkpoints followed by extra.
+func checkpointSeq(n int, extra ...string) []string {
+	var out []string
+	for i := range n {
+		out = append(out, "c"+strconv.Itoa(i))
+	}
+	return append(out, extra...)
+}
+
+func TestFakeChangeFeedOrder(t *testing.T) {
+	db, _ := setup(t)
+	var subs []string
+	for i := 0; i < 8; i++ {
+		id, err := DatabaseWatch("tasks", WatchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		subs = append(subs, id)
+	}
+	if err := DatabaseUnwatch(subs[3]); err != nil {
+		t.Fatal(err)
+	}
+	subs = slices.Delete(subs, 3, 4)
+	if _, err := DatabaseCreate("tasks", map[string]any{"title": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	db.Deliver(func(msg *wafer.Message) {
+		got = append(got, msg.GetMeta("change.subscription"))
+	})
+	if !slices.Equal(got, subs) {
+		t.Errorf("delivered to %v, want %v", got, subs)
+	}
+}
@@ -30,3 +30,29 @@ func CryptoVerify(token string) (string, error) {
 func CryptoRandomBytes(n uint32) ([]byte, error) {
 	return crypto.RandomBytes(n)
 }
+
+// CryptoCurrentKey returns the host's current key-encryption key and its ID.
+func CryptoCurrentKey() (crypto.DataKey, error) {
+	if crypto.CurrentKey == nil {
+		return crypto.DataKey{}, crypto.CryptoErrorKeyNotFound
+	}
+	return crypto.CurrentKey()
+}
+
+// CryptoGetKey returns the host key-encryption key with the given ID,
+// including retired keys that are still needed for decryption.
+func CryptoGetKey(id string) ([]byte, error) {
+	if crypto.GetKey == nil {
+		return nil, crypto.CryptoErrorKeyNotFound
+	}
+	return crypto.GetKey(id)
+}
+
+// CryptoIndexKey returns the host key used for blind-index hashing. Unlike
+// encryption keys it is not rotated, since that would change every index.
+func CryptoIndexKey() ([]byte, error) {
+	if crypto.IndexKey == nil {
+		return nil, crypto.CryptoErrorKeyNotFound
+	}
+	return crypto.IndexKey()
+}
@@ -46,6 +46,23 @@ func DatabaseGet(collection, id string) (Record, error) {
 	return database.Get(collection, id)
 }
 
+// DatabaseGetSelect retrieves a single record with only the given fields in
+// its data. Dotted field names select nested values. When the host does not
+// support projection the full record is fetched and projected guest-side.
+func DatabaseGetSelect(collection, id string, fields ...string) (Record, error) {
+	if database.GetSelect != nil {
+		rec, err := database.GetSelect(collection, id, fields)
+		if !isUnsupported(err) {
+			return rec, err
+		}
+	}
+	rec, err := database.Get(collection, id)
+	if err != nil {
+		return Record{}, err
+	}
+	return ProjectRecord(rec, fields)
+}
+
 // DatabaseGetInto retrieves a single record and unmarshals its JSON data field
 // into the provided value.
 func DatabaseGetInto(collection, id string, v any) error {
@@ -57,8 +74,32 @@ func DatabaseGetInto(collection, id string, v any) error {
 }
 
 // DatabaseList retrieves records from a collection with the given options.
+// When the host does not support projection, the full records are listed and
+// projected to opts.Select guest-side. When it does not support filter
+// trees, the records matching opts.Filters are listed in full and opts.Where
+// is evaluated guest-side.
 func DatabaseList(collection string, opts ListOptions) (RecordList, error) {
-	return database.List(collection, opts)
+	rl, err := database.List(collection, opts)
+	if !isUnsupported(err) || (opts.Where == nil && len(opts.Select) == 0) {
+		return rl, err
+	}
+	if len(opts.Select) > 0 {
+		plain := opts
+		plain.Select = nil
+		rl, err = database.List(collection, plain)
+	}
+	if opts.Where != nil && isUnsupported(err) {
+		rl, err = listWhere(collection, opts)
+	}
+	if err != nil {
+		return rl, err
+	}
+	for i, rec := range rl.Records {
+		if rl.Records[i], err = ProjectRecord(rec, opts.Select); err != nil {
+			return RecordList{}, err
+		}
+	}
+	return rl, nil
 }
 
 // DatabaseListAll retrieves all records from a collection with no filters.
@@ -76,6 +117,9 @@ func DatabaseCreate(collection string, data any) (Record, error) {
 			Message: "failed to marshal record: " + err.Error(),
 		}
 	}
+	if err := validateRecord(collection, string(jsonData)); err != nil {
+		return Record{}, err
+	}
 	return database.Create(collection, string(jsonData))
 }
 
@@ -88,6 +132,9 @@ func DatabaseUpdate(collection, id string, data any) (Record, error) {
 			Message: "failed to marshal record: " + err.Error(),
 		}
 	}
+	if err := validateRecord(collection, string(jsonData)); err != nil {
+		return Record{}, err
+	}
 	return database.Update(collection, id, string(jsonData))
 }
 
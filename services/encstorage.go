@@ -0,0 +1,316 @@
+package services
+
+import (
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"strings"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+)
+
+// EncryptionAlgorithm identifies the format written by EncryptedStorage:
+// AES-256-GCM over fixed-size segments, each with its own nonce derived from
+// a per-object prefix, the segment index and a final-segment flag.
+const EncryptionAlgorithm = "AES-256-GCM-STREAM"
+
+// EncryptedSegmentSize is the plaintext size of each encrypted segment.
+// Objects written with another segment size are rejected.
+const EncryptedSegmentSize = 64 << 10
+
+// Object metadata keys written by EncryptedStorage.
+const (
+	MetaEncryptionAlgorithm = "enc-alg"
+	MetaEncryptionKeyID     = "enc-key-id"
+	MetaEncryptionKey       = "enc-key"
+	MetaEncryptionNonce     = "enc-nonce"
+	MetaEncryptionSegment   = "enc-segment-size"
+)
+
+// noncePrefixSize leaves room in the 12-byte GCM nonce for a 4-byte segment
+// index and a 1-byte final flag.
+const noncePrefixSize = 7
+
+// EncryptedStorage encrypts objects before they reach storage. Each object
+// gets a fresh data key, wrapped by the keyring's current key; the
+// algorithm, key ID, wrapped key and nonce prefix are kept in the object's
+// metadata, so the host must support object metadata. Objects are encrypted
+// in EncryptedSegmentSize segments, so the streaming reader and writer hold
+// one segment at a time. Segments cannot be reordered, dropped or truncated
+// without Get failing with "data_loss". The folder and key are authenticated
+// with every segment, so an object copied or moved to another key no longer
+// decrypts; re-encrypt it under the new key instead.
+type EncryptedStorage struct {
+	keyring *Keyring
+}
+
+// NewEncryptedStorage creates an EncryptedStorage. A nil keyring means
+// DefaultKeyring, whose master keys come from config.
+func NewEncryptedStorage(keyring *Keyring) *EncryptedStorage {
+	if keyring == nil {
+		keyring = DefaultKeyring()
+	}
+	return &EncryptedStorage{keyring: keyring}
+}
+
+// Put encrypts data and stores it.
+func (e *EncryptedStorage) Put(folder, key string, data []byte, contentType string) error {
+	w, err := e.CreateWriter(folder, key, contentType)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Get retrieves and decrypts an object. The returned ObjectInfo reports the
+// plaintext size and omits the encryption metadata.
+func (e *EncryptedStorage) Get(folder, key string) ([]byte, ObjectInfo, error) {
+	r, info, err := e.OpenReader(folder, key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	return data, info, nil
+}
+
+// CreateWriter returns a writer that encrypts everything written to it and
+// stores it as one object once closed.
+func (e *EncryptedStorage) CreateWriter(folder, key, contentType string) (io.WriteCloser, error) {
+	dataKey, err := CryptoRandomBytes(32)
+	if err != nil {
+		return nil, randomError(err)
+	}
+	prefix, err := CryptoRandomBytes(noncePrefixSize)
+	if err != nil {
+		return nil, randomError(err)
+	}
+	keyID, wrapped, err := e.keyring.WrapKey(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	metadata := map[string]string{
+		MetaEncryptionAlgorithm: EncryptionAlgorithm,
+		MetaEncryptionKeyID:     keyID,
+		MetaEncryptionKey:       base64.StdEncoding.EncodeToString(wrapped),
+		MetaEncryptionNonce:     base64.StdEncoding.EncodeToString(prefix),
+		MetaEncryptionSegment:   strconv.Itoa(EncryptedSegmentSize),
+	}
+	return &encryptingWriter{
+		w:      &StorageWriter{folder: folder, key: key, contentType: contentType, metadata: metadataEntries(metadata)},
+		check:  newUploadCheck(folder, contentType),
+		aead:   aead,
+		prefix: prefix,
+		aad:    objectAAD(folder, key),
+		buf:    make([]byte, 0, EncryptedSegmentSize),
+	}, nil
+}
+
+// OpenReader opens an encrypted object for streaming, decrypting reads. Each
+// segment is authenticated before any of its bytes are returned.
+func (e *EncryptedStorage) OpenReader(folder, key string) (io.ReadCloser, ObjectInfo, error) {
+	r, err := openStorageReader(folder, key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	meta := ObjectMetadata(r.info)
+	if meta[MetaEncryptionAlgorithm] != EncryptionAlgorithm {
+		r.Close()
+		return nil, ObjectInfo{}, &wafer.WaferError{
+			Code:    "failed_precondition",
+			Message: "object " + key + " is not encrypted with " + EncryptionAlgorithm,
+		}
+	}
+	segment, err := strconv.Atoi(meta[MetaEncryptionSegment])
+	wrapped, kerr := base64.StdEncoding.DecodeString(meta[MetaEncryptionKey])
+	prefix, nerr := base64.StdEncoding.DecodeString(meta[MetaEncryptionNonce])
+	if err != nil || kerr != nil || nerr != nil || segment != EncryptedSegmentSize || len(prefix) != noncePrefixSize {
+		r.Close()
+		return nil, ObjectInfo{}, &wafer.WaferError{
+			Code:    "data_loss",
+			Message: "object " + key + " has malformed encryption metadata",
+		}
+	}
+	dataKey, err := e.keyring.UnwrapKey(meta[MetaEncryptionKeyID], wrapped)
+	if err != nil {
+		r.Close()
+		return nil, ObjectInfo{}, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		r.Close()
+		return nil, ObjectInfo{}, err
+	}
+
+	sealed := int64(segment + aead.Overhead())
+	segments := max((r.info.Size+sealed-1)/sealed, 1)
+	info := r.info
+	info.Size -= segments * int64(aead.Overhead())
+	info.Metadata = nil
+	for _, m := range r.info.Metadata {
+		if !strings.HasPrefix(m.Key, "enc-") {
+			info.Metadata = append(info.Metadata, m)
+		}
+	}
+	return &decryptingReader{
+		r:        r,
+		aead:     aead,
+		prefix:   prefix,
+		aad:      objectAAD(folder, key),
+		sealed:   make([]byte, sealed),
+		segments: segments,
+	}, info, nil
+}
+
+// objectAAD binds segments to the object's location.
+func objectAAD(folder, key string) []byte {
+	return []byte(folder + "\x00" + key)
+}
+
+// segmentNonce derives the nonce of segment i.
+func segmentNonce(prefix []byte, i uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], i)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encryptingWriter buffers one segment. A full segment is only sealed once
+// more data arrives, so Close always has a final segment to flag.
+type encryptingWriter struct {
+	w      *StorageWriter
+	aead   cipher.AEAD
+	prefix []byte
+	aad    []byte
+	buf    []byte
+	index  uint32
+	check  *uploadCheck // applied to the plaintext
+	err    error        // set once the upload has been aborted
+}
+
+func (e *encryptingWriter) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	if e.check != nil {
+		if err := e.check.write(p); err != nil {
+			return 0, e.fail(err)
+		}
+	}
+	n := 0
+	for len(p) > 0 {
+		if len(e.buf) == EncryptedSegmentSize {
+			if err := e.seal(false); err != nil {
+				return n, e.fail(err)
+			}
+		}
+		take := min(len(p), EncryptedSegmentSize-len(e.buf))
+		e.buf = append(e.buf, p[:take]...)
+		p, n = p[take:], n+take
+	}
+	return n, nil
+}
+
+func (e *encryptingWriter) Close() error {
+	if e.err != nil {
+		return e.err
+	}
+	if e.check != nil {
+		if err := e.check.close(); err != nil {
+			return e.fail(err)
+		}
+	}
+	if err := e.seal(true); err != nil {
+		return e.fail(err)
+	}
+	return e.w.Close()
+}
+
+// fail aborts the upload, so a rejected object does not hold host resources
+// until Close.
+func (e *encryptingWriter) fail(err error) error {
+	e.err = err
+	e.buf = nil
+	e.w.Abort()
+	return err
+}
+
+func (e *encryptingWriter) seal(last bool) error {
+	if e.index == ^uint32(0) {
+		return &wafer.WaferError{
+			Code:    "resource_exhausted",
+			Message: "encrypted object exceeds the maximum number of segments",
+		}
+	}
+	out := e.aead.Seal(nil, segmentNonce(e.prefix, e.index, last), e.buf, e.aad)
+	e.index++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(out)
+	return err
+}
+
+// decryptingReader decrypts one segment at a time.
+type decryptingReader struct {
+	r        *StorageReader
+	aead     cipher.AEAD
+	prefix   []byte
+	aad      []byte
+	sealed   []byte
+	segments int64
+	index    int64
+	plain    []byte
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.index == d.segments {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(d.r, d.sealed)
+		// A short read is left for authentication to reject.
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		last := d.index == d.segments-1
+		plain, err := d.aead.Open(d.sealed[:0], segmentNonce(d.prefix, uint32(d.index), last), d.sealed[:n], d.aad)
+		if err != nil {
+			return 0, &wafer.WaferError{
+				Code:    "data_loss",
+				Message: "failed to decrypt object: ciphertext is corrupt or truncated",
+			}
+		}
+		d.plain = plain
+		d.index++
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptingReader) Close() error {
+	d.plain = nil
+	return d.r.Close()
+}
+
+func randomError(err error) error {
+	return &wafer.WaferError{
+		Code:    "internal",
+		Message: "failed to generate random bytes: " + err.Error(),
+	}
+}
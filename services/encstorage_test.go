@@ -0,0 +1,114 @@
+package services_test
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+
+	"github.com/wafer-run/wafer-sdk-go/gen/wafer/storage"
+	. "github.com/wafer-run/wafer-sdk-go/services"
+	"github.com/wafer-run/wafer-sdk-go/services/fakes"
+)
+
+func TestEncryptedStorageIntegrity(t *testing.T) {
+	plain := bytes.Repeat([]byte("0123456789abcdef"), EncryptedSegmentSize/16*2+100)
+
+	// rewrite replaces the stored ciphertext and metadata of files/a.
+	rewrite := func(st *fakes.Storage, edit func(data []byte, meta map[string]string) []byte) {
+		data, _ := st.Object("files", "a")
+		info, _ := st.Head("files", "a")
+		meta := make(map[string]string)
+		for _, m := range info.Metadata {
+			meta[m.Key] = m.Value
+		}
+		data = edit(append([]byte(nil), data...), meta)
+		var entries []storage.MetadataEntry
+		for k, v := range meta {
+			entries = append(entries, storage.MetadataEntry{Key: k, Value: v})
+		}
+		st.PutWithMetadata("files", "a", data, info.ContentType, entries)
+	}
+
+	tests := []struct {
+		name        string
+		tamper      func(st *fakes.Storage)
+		folder, key string
+		code        string
+	}{
+		{name: "intact", folder: "files", key: "a"},
+		{
+			name:   "moved to another key",
+			tamper: func(st *fakes.Storage) { st.Move("files", "a", "files", "b") },
+			folder: "files", key: "b", code: "data_loss",
+		},
+		{
+			name:   "copied to another folder",
+			tamper: func(st *fakes.Storage) { st.Copy("files", "a", "other", "a") },
+			folder: "other", key: "a", code: "data_loss",
+		},
+		{
+			name: "flipped byte",
+			tamper: func(st *fakes.Storage) {
+				rewrite(st, func(data []byte, _ map[string]string) []byte {
+					data[len(data)/2] ^= 1
+					return data
+				})
+			},
+			folder: "files", key: "a", code: "data_loss",
+		},
+		{
+			name: "dropped final segment",
+			tamper: func(st *fakes.Storage) {
+				rewrite(st, func(data []byte, _ map[string]string) []byte {
+					return data[:2*(EncryptedSegmentSize+16)]
+				})
+			},
+			folder: "files", key: "a", code: "data_loss",
+		},
+		{
+			name: "larger segment size",
+			tamper: func(st *fakes.Storage) {
+				rewrite(st, func(data []byte, meta map[string]string) []byte {
+					meta[MetaEncryptionSegment] = strconv.Itoa(1 << 30)
+					return data
+				})
+			},
+			folder: "files", key: "a", code: "data_loss",
+		},
+		{
+			name: "smaller segment size",
+			tamper: func(st *fakes.Storage) {
+				rewrite(st, func(data []byte, meta map[string]string) []byte {
+					meta[MetaEncryptionSegment] = "1"
+					return data
+				})
+			},
+			folder: "files", key: "a", code: "data_loss",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, st := setup(t)
+			es := NewEncryptedStorage(testKeyring())
+			if err := es.Put("files", "a", plain, "application/octet-stream"); err != nil {
+				t.Fatal(err)
+			}
+			if tt.tamper != nil {
+				tt.tamper(st)
+			}
+			data, info, err := es.Get(tt.folder, tt.key)
+			if tt.code != "" {
+				if !isCode(err, tt.code) {
+					t.Fatalf("Get error = %v, want %s", err, tt.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, plain) || info.Size != int64(len(plain)) {
+				t.Errorf("Get returned %d bytes (size %d), want %d", len(data), info.Size, len(plain))
+			}
+		})
+	}
+}
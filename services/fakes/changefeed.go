@@ -0,0 +1,141 @@
+package fakes
+
+import (
+	"strconv"
+	"time"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+	"github.com/wafer-run/wafer-sdk-go/gen/wafer/database"
+	"github.com/wafer-run/wafer-sdk-go/services"
+)
+
+// subscription is a registered change feed watcher.
+type subscription struct {
+	id         string
+	collection string
+	options    database.WatchOptions
+}
+
+// change is an entry of the change log. Its checkpoint is its 1-based
+// position in the log.
+type change struct {
+	collection string
+	op         database.ChangeOp
+	before     *database.DbRecord
+	after      *database.DbRecord
+	at         string
+}
+
+// Watch implements database.Watch. Every write made through the fake is kept
+// in a change log, so a non-empty ResumeFrom replays the changes after that
+// checkpoint.
+func (db *Database) Watch(collection string, options database.WatchOptions) (string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.nextSub++
+	sub := &subscription{
+		id:         "sub-" + strconv.FormatInt(db.nextSub, 10),
+		collection: collection,
+		options:    options,
+	}
+	if options.ResumeFrom != "" {
+		from, err := strconv.Atoi(options.ResumeFrom)
+		if err != nil || from < 0 || from > len(db.changes) {
+			return "", database.DatabaseErrorNotFound
+		}
+		for i := from; i < len(db.changes); i++ {
+			db.queue(sub, i)
+		}
+	}
+	db.subs = append(db.subs, sub)
+	return sub.id, nil
+}
+
+// Unwatch implements database.Unwatch.
+func (db *Database) Unwatch(subscription string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for i, sub := range db.subs {
+		if sub.id == subscription {
+			db.subs = append(db.subs[:i:i], db.subs[i+1:]...)
+			return nil
+		}
+	}
+	return database.DatabaseErrorNotFound
+}
+
+// Pending returns the change messages that have not been delivered yet.
+func (db *Database) Pending() []*wafer.Message {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]*wafer.Message(nil), db.pending...)
+}
+
+// Deliver hands every pending change message to handler, typically a block's
+// Handle method, and returns how many were delivered. Changes made by the
+// handler itself are delivered by the next call.
+func (db *Database) Deliver(handler func(msg *wafer.Message)) int {
+	db.mu.Lock()
+	pending := db.pending
+	db.pending = nil
+	db.mu.Unlock()
+	for _, msg := range pending {
+		handler(msg)
+	}
+	return len(pending)
+}
+
+// emit appends a change to the log and queues it for matching subscriptions
+// in the order they were created. The caller holds db.mu.
+func (db *Database) emit(collection string, op database.ChangeOp, before, after *database.DbRecord) {
+	db.changes = append(db.changes, change{
+		collection: collection,
+		op:         op,
+		before:     before,
+		after:      after,
+		at:         time.Now().UTC().Format(time.RFC3339Nano),
+	})
+	for _, sub := range db.subs {
+		db.queue(sub, len(db.changes)-1)
+	}
+}
+
+// queue delivers log entry i to sub if it matches the subscription.
+func (db *Database) queue(sub *subscription, i int) {
+	c := db.changes[i]
+	if c.collection != sub.collection || !wantsOp(sub.options.Ops, c.op) {
+		return
+	}
+	subject := c.after
+	if subject == nil {
+		subject = c.before
+	}
+	if ok, err := services.MatchRecord(*subject, sub.options.Filters, nil); err != nil || !ok {
+		return
+	}
+	msg, err := services.NewChangeMessage(services.ChangeEvent{
+		Subscription: sub.id,
+		Collection:   c.collection,
+		Op:           c.op,
+		ID:           subject.ID,
+		Before:       c.before,
+		After:        c.after,
+		Checkpoint:   strconv.Itoa(i + 1),
+		Timestamp:    c.at,
+	})
+	if err == nil {
+		db.pending = append(db.pending, msg)
+	}
+}
+
+func wantsOp(ops []database.ChangeOp, op database.ChangeOp) bool {
+	if len(ops) == 0 {
+		return true
+	}
+	for _, o := range ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
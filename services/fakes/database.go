@@ -0,0 +1,391 @@
+// Package fakes provides in-memory implementations of the WAFER host imports
+// so blocks built on the services package can be exercised in plain Go tests,
+// without a runtime.
+//
+//	db := fakes.NewDatabase()
+//	db.Install()
+//	rec, err := services.DatabaseCreate("tasks", task)
+package fakes
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+	"github.com/wafer-run/wafer-sdk-go/gen/wafer/database"
+	"github.com/wafer-run/wafer-sdk-go/services"
+)
+
+// Database is an in-memory database. Records keep insertion order and get
+// sequential IDs. Filters, filter trees and sorting are evaluated with the
+// same rules as services.MatchRecord.
+type Database struct {
+	// QueryRaw and ExecRaw back raw SQL calls. When nil, raw calls fail with
+	// database.DatabaseErrorUnsupported.
+	QueryRaw func(query string, args string) ([]database.DbRecord, error)
+	ExecRaw  func(query string, args string) (int64, error)
+
+	mu          sync.Mutex
+	nextID      int64
+	collections map[string][]database.DbRecord
+
+	// Change feed state; see changefeed.go.
+	nextSub int64
+	subs    []*subscription
+	changes []change
+	pending []*wafer.Message
+}
+
+// NewDatabase creates an empty in-memory database.
+func NewDatabase() *Database {
+	return &Database{
+		collections: make(map[string][]database.DbRecord),
+	}
+}
+
+// Install points the database host imports at db.
+func (db *Database) Install() {
+	database.Get = db.Get
+	database.GetSelect = db.GetSelect
+	database.List = db.List
+	database.Create = db.Create
+	database.Update = db.Update
+	database.Delete = db.Delete
+	database.Count = db.Count
+	database.CountWhere = db.CountWhere
+	database.QueryRaw = db.queryRaw
+	database.ExecRaw = db.execRaw
+	database.CreateMany = db.CreateMany
+	database.UpdateMany = db.UpdateMany
+	database.DeleteMany = db.DeleteMany
+	database.Upsert = db.Upsert
+	database.UpdateIf = db.UpdateIf
+	database.Patch = db.Patch
+	database.Aggregate = db.Aggregate
+	database.Watch = db.Watch
+	database.Unwatch = db.Unwatch
+}
+
+// Records returns a copy of every record in collection in insertion order.
+func (db *Database) Records(collection string) []database.DbRecord {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]database.DbRecord(nil), db.collections[collection]...)
+}
+
+// Get implements database.Get.
+func (db *Database) Get(collection string, id string) (database.DbRecord, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	i := db.index(collection, id)
+	if i < 0 {
+		return database.DbRecord{}, database.DatabaseErrorNotFound
+	}
+	return db.collections[collection][i], nil
+}
+
+// GetSelect implements database.GetSelect.
+func (db *Database) GetSelect(collection string, id string, fields []string) (database.DbRecord, error) {
+	rec, err := db.Get(collection, id)
+	if err != nil {
+		return rec, err
+	}
+	return services.ProjectRecord(rec, fields)
+}
+
+// List implements database.List.
+func (db *Database) List(collection string, options database.ListOptions) (database.RecordList, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	matched, err := db.match(collection, options.Filters, options.Where)
+	if err != nil {
+		return database.RecordList{}, err
+	}
+	sortRecords(matched, options.Sort)
+
+	out := database.RecordList{TotalCount: int64(len(matched)), Page: 1, PageSize: options.Limit}
+	if options.Offset > 0 {
+		matched = matched[min(int(options.Offset), len(matched)):]
+	}
+	if options.Limit > 0 {
+		matched = matched[:min(int(options.Limit), len(matched))]
+		out.Page = options.Offset/options.Limit + 1
+	}
+	for i, rec := range matched {
+		if matched[i], err = services.ProjectRecord(rec, options.Select); err != nil {
+			return database.RecordList{}, err
+		}
+	}
+	out.Records = matched
+	return out, nil
+}
+
+// Create implements database.Create.
+func (db *Database) Create(collection string, data string) (database.DbRecord, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.create(collection, data)
+}
+
+// Update implements database.Update.
+func (db *Database) Update(collection string, id string, data string) (database.DbRecord, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.update(collection, id, data)
+}
+
+// Delete implements database.Delete.
+func (db *Database) Delete(collection string, id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.delete(collection, id)
+}
+
+// Count implements database.Count.
+func (db *Database) Count(collection string, filters []database.Filter) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	matched, err := db.match(collection, filters, nil)
+	return int64(len(matched)), err
+}
+
+// CountWhere implements database.CountWhere.
+func (db *Database) CountWhere(collection string, filters []database.Filter, where database.FilterTree) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	matched, err := db.match(collection, filters, &where)
+	return int64(len(matched)), err
+}
+
+// CreateMany implements database.CreateMany.
+func (db *Database) CreateMany(collection string, data []string) ([]database.BatchItem, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	out := make([]database.BatchItem, len(data))
+	for i, d := range data {
+		out[i] = batchItem(db.create(collection, d))
+	}
+	return out, nil
+}
+
+// UpdateMany implements database.UpdateMany by merging the top-level fields
+// of data into every matching record.
+func (db *Database) UpdateMany(collection string, filters []database.Filter, data string) ([]database.BatchItem, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(data), &fields); err != nil {
+		return nil, database.DatabaseErrorInternal
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	matched, err := db.match(collection, filters, nil)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]database.BatchItem, len(matched))
+	for i, rec := range matched {
+		doc := make(map[string]json.RawMessage)
+		if err := json.Unmarshal([]byte(rec.Data), &doc); err != nil {
+			out[i] = batchItem(rec, database.DatabaseErrorInternal)
+			continue
+		}
+		for k, v := range fields {
+			doc[k] = v
+		}
+		merged, _ := json.Marshal(doc)
+		out[i] = batchItem(db.update(collection, rec.ID, string(merged)))
+	}
+	return out, nil
+}
+
+// DeleteMany implements database.DeleteMany.
+func (db *Database) DeleteMany(collection string, filters []database.Filter) ([]database.BatchItem, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	matched, err := db.match(collection, filters, nil)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]database.BatchItem, len(matched))
+	for i, rec := range matched {
+		out[i] = batchItem(rec, db.delete(collection, rec.ID))
+	}
+	return out, nil
+}
+
+// Upsert implements database.Upsert.
+func (db *Database) Upsert(collection string, filters []database.Filter, data string) (database.DbRecord, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	matched, err := db.match(collection, filters, nil)
+	if err != nil {
+		return database.DbRecord{}, err
+	}
+	if len(matched) == 0 {
+		return db.create(collection, data)
+	}
+	return db.update(collection, matched[0].ID, data)
+}
+
+// UpdateIf implements database.UpdateIf.
+func (db *Database) UpdateIf(collection string, id string, conditions []database.Filter, data string) (database.DbRecord, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	i := db.index(collection, id)
+	if i < 0 {
+		return database.DbRecord{}, database.DatabaseErrorNotFound
+	}
+	ok, err := services.MatchRecord(db.collections[collection][i], conditions, nil)
+	if err != nil {
+		return database.DbRecord{}, database.DatabaseErrorInternal
+	}
+	if !ok {
+		return database.DbRecord{}, database.DatabaseErrorConflict
+	}
+	return db.update(collection, id, data)
+}
+
+// Patch implements database.Patch.
+func (db *Database) Patch(collection string, id string, format database.PatchFormat, patch string) (database.DbRecord, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	i := db.index(collection, id)
+	if i < 0 {
+		return database.DbRecord{}, database.DatabaseErrorNotFound
+	}
+	patched, err := services.ApplyPatch(format, []byte(db.collections[collection][i].Data), []byte(patch))
+	if err != nil {
+		return database.DbRecord{}, err
+	}
+	return db.update(collection, id, string(patched))
+}
+
+// Aggregate implements database.Aggregate with services.EvaluateAggregate.
+func (db *Database) Aggregate(collection string, options database.AggregateOptions) ([]database.AggregateRow, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return services.EvaluateAggregate(db.collections[collection], options)
+}
+
+func (db *Database) queryRaw(query string, args string) ([]database.DbRecord, error) {
+	if db.QueryRaw == nil {
+		return nil, database.DatabaseErrorUnsupported
+	}
+	return db.QueryRaw(query, args)
+}
+
+func (db *Database) execRaw(query string, args string) (int64, error) {
+	if db.ExecRaw == nil {
+		return 0, database.DatabaseErrorUnsupported
+	}
+	return db.ExecRaw(query, args)
+}
+
+func (db *Database) create(collection, data string) (database.DbRecord, error) {
+	if !json.Valid([]byte(data)) {
+		return database.DbRecord{}, database.DatabaseErrorInternal
+	}
+	db.nextID++
+	rec := database.DbRecord{ID: strconv.FormatInt(db.nextID, 10), Data: data}
+	db.collections[collection] = append(db.collections[collection], rec)
+	db.emit(collection, database.ChangeOpCreate, nil, &rec)
+	return rec, nil
+}
+
+func (db *Database) update(collection, id, data string) (database.DbRecord, error) {
+	i := db.index(collection, id)
+	if i < 0 {
+		return database.DbRecord{}, database.DatabaseErrorNotFound
+	}
+	if !json.Valid([]byte(data)) {
+		return database.DbRecord{}, database.DatabaseErrorInternal
+	}
+	before := db.collections[collection][i]
+	rec := database.DbRecord{ID: id, Data: data}
+	db.collections[collection][i] = rec
+	db.emit(collection, database.ChangeOpUpdate, &before, &rec)
+	return rec, nil
+}
+
+func (db *Database) delete(collection, id string) error {
+	i := db.index(collection, id)
+	if i < 0 {
+		return database.DatabaseErrorNotFound
+	}
+	recs := db.collections[collection]
+	before := recs[i]
+	db.collections[collection] = append(recs[:i:i], recs[i+1:]...)
+	db.emit(collection, database.ChangeOpDelete, &before, nil)
+	return nil
+}
+
+func (db *Database) index(collection, id string) int {
+	for i, rec := range db.collections[collection] {
+		if rec.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (db *Database) match(collection string, filters []database.Filter, where *database.FilterTree) ([]database.DbRecord, error) {
+	var out []database.DbRecord
+	for _, rec := range db.collections[collection] {
+		ok, err := services.MatchRecord(rec, filters, where)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			out = append(out, rec)
+		}
+	}
+	return out, nil
+}
+
+// sortRecords orders records by the sort directives. Missing values sort
+// first in ascending order.
+func sortRecords(recs []database.DbRecord, fields []database.SortField) {
+	if len(fields) == 0 {
+		return
+	}
+	docs := make(map[string]map[string]any, len(recs))
+	for _, rec := range recs {
+		var doc map[string]any
+		_ = json.Unmarshal([]byte(rec.Data), &doc)
+		if doc == nil {
+			doc = make(map[string]any)
+		}
+		if _, ok := doc["id"]; !ok {
+			doc["id"] = rec.ID
+		}
+		docs[rec.ID] = doc
+	}
+	sort.SliceStable(recs, func(i, j int) bool {
+		for _, f := range fields {
+			a, _ := services.LookupField(docs[recs[i].ID], f.Field)
+			b, _ := services.LookupField(docs[recs[j].ID], f.Field)
+			c := services.CompareSortValues(a, b)
+			if c == 0 {
+				continue
+			}
+			if f.Desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+}
+
+func batchItem(rec database.DbRecord, err error) database.BatchItem {
+	item := database.BatchItem{Record: rec}
+	if err != nil {
+		e := database.DatabaseErrorInternal
+		if de, ok := err.(database.DatabaseError); ok {
+			e = de
+		}
+		item.Error = &e
+	}
+	return item
+}
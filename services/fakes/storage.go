@@ -0,0 +1,247 @@
+package fakes
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wafer-run/wafer-sdk-go/gen/wafer/storage"
+)
+
+// Storage is an in-memory object store with folders. Listing is ordered by
+// key, and uploads become visible only when committed.
+type Storage struct {
+	mu         sync.Mutex
+	folders    map[string]map[string]*object
+	nextUpload int64
+	uploads    map[string]*upload
+}
+
+type object struct {
+	data []byte
+	info storage.ObjectInfo
+}
+
+type upload struct {
+	folder, key, contentType string
+	metadata                 []storage.MetadataEntry
+	data                     []byte
+}
+
+// NewStorage creates an empty in-memory object store.
+func NewStorage() *Storage {
+	return &Storage{
+		folders: make(map[string]map[string]*object),
+		uploads: make(map[string]*upload),
+	}
+}
+
+// Install points the storage host imports at s.
+func (s *Storage) Install() {
+	storage.Put = s.Put
+	storage.Get = s.Get
+	storage.Delete = s.Delete
+	storage.List = s.List
+	storage.GetRange = s.GetRange
+	storage.BeginUpload = s.BeginUpload
+	storage.UploadChunk = s.UploadChunk
+	storage.CommitUpload = s.CommitUpload
+	storage.AbortUpload = s.AbortUpload
+	storage.Head = s.Head
+	storage.PutWithMetadata = s.PutWithMetadata
+	storage.Copy = s.Copy
+	storage.Move = s.Move
+}
+
+// Object returns the stored content of an object.
+func (s *Storage) Object(folder, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.folders[folder][key]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), o.data...), true
+}
+
+// Uploads returns the number of uploads that were begun but neither
+// committed nor aborted.
+func (s *Storage) Uploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.uploads)
+}
+
+// Put implements storage.Put.
+func (s *Storage) Put(folder string, key string, data []byte, contentType string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(folder, key, append([]byte(nil), data...), contentType, nil)
+	return nil
+}
+
+// PutWithMetadata implements storage.PutWithMetadata.
+func (s *Storage) PutWithMetadata(folder string, key string, data []byte, contentType string, metadata []storage.MetadataEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(folder, key, append([]byte(nil), data...), contentType, metadata)
+	return nil
+}
+
+// Head implements storage.Head.
+func (s *Storage) Head(folder string, key string) (storage.ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.folders[folder][key]
+	if !ok {
+		return storage.ObjectInfo{}, storage.StorageErrorNotFound
+	}
+	return o.info, nil
+}
+
+// Copy implements storage.Copy.
+func (s *Storage) Copy(srcFolder string, srcKey string, dstFolder string, dstKey string) (storage.ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.folders[srcFolder][srcKey]
+	if !ok {
+		return storage.ObjectInfo{}, storage.StorageErrorNotFound
+	}
+	return s.put(dstFolder, dstKey, append([]byte(nil), o.data...), o.info.ContentType, o.info.Metadata).info, nil
+}
+
+// Move implements storage.Move.
+func (s *Storage) Move(srcFolder string, srcKey string, dstFolder string, dstKey string) (storage.ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.folders[srcFolder][srcKey]
+	if !ok {
+		return storage.ObjectInfo{}, storage.StorageErrorNotFound
+	}
+	delete(s.folders[srcFolder], srcKey)
+	return s.put(dstFolder, dstKey, o.data, o.info.ContentType, o.info.Metadata).info, nil
+}
+
+// Get implements storage.Get.
+func (s *Storage) Get(folder string, key string) ([]byte, storage.ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.folders[folder][key]
+	if !ok {
+		return nil, storage.ObjectInfo{}, storage.StorageErrorNotFound
+	}
+	return append([]byte(nil), o.data...), o.info, nil
+}
+
+// Delete implements storage.Delete.
+func (s *Storage) Delete(folder string, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.folders[folder][key]; !ok {
+		return storage.StorageErrorNotFound
+	}
+	delete(s.folders[folder], key)
+	return nil
+}
+
+// List implements storage.List.
+func (s *Storage) List(folder string, prefix string, limit int64, offset int64) (storage.ObjectList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for k := range s.folders[folder] {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	out := storage.ObjectList{TotalCount: int64(len(keys))}
+	if offset > 0 {
+		keys = keys[min(offset, int64(len(keys))):]
+	}
+	if limit > 0 && int64(len(keys)) > limit {
+		keys = keys[:limit]
+	}
+	for _, k := range keys {
+		out.Objects = append(out.Objects, s.folders[folder][k].info)
+	}
+	return out, nil
+}
+
+// GetRange implements storage.GetRange.
+func (s *Storage) GetRange(folder string, key string, offset int64, length int64) ([]byte, storage.ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.folders[folder][key]
+	if !ok {
+		return nil, storage.ObjectInfo{}, storage.StorageErrorNotFound
+	}
+	start := min(max(offset, 0), int64(len(o.data)))
+	end := min(start+max(length, 0), int64(len(o.data)))
+	return append([]byte(nil), o.data[start:end]...), o.info, nil
+}
+
+// BeginUpload implements storage.BeginUpload.
+func (s *Storage) BeginUpload(folder string, key string, contentType string, metadata []storage.MetadataEntry) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextUpload++
+	id := "upload-" + strconv.FormatInt(s.nextUpload, 10)
+	s.uploads[id] = &upload{folder: folder, key: key, contentType: contentType, metadata: metadata}
+	return id, nil
+}
+
+// UploadChunk implements storage.UploadChunk.
+func (s *Storage) UploadChunk(id string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.uploads[id]
+	if !ok {
+		return storage.StorageErrorNotFound
+	}
+	u.data = append(u.data, data...)
+	return nil
+}
+
+// CommitUpload implements storage.CommitUpload.
+func (s *Storage) CommitUpload(id string) (storage.ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.uploads[id]
+	if !ok {
+		return storage.ObjectInfo{}, storage.StorageErrorNotFound
+	}
+	delete(s.uploads, id)
+	return s.put(u.folder, u.key, u.data, u.contentType, u.metadata).info, nil
+}
+
+// AbortUpload implements storage.AbortUpload.
+func (s *Storage) AbortUpload(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.uploads[id]; !ok {
+		return storage.StorageErrorNotFound
+	}
+	delete(s.uploads, id)
+	return nil
+}
+
+func (s *Storage) put(folder, key string, data []byte, contentType string, metadata []storage.MetadataEntry) *object {
+	if s.folders[folder] == nil {
+		s.folders[folder] = make(map[string]*object)
+	}
+	o := &object{
+		data: data,
+		info: storage.ObjectInfo{
+			Key:          key,
+			Size:         int64(len(data)),
+			ContentType:  contentType,
+			LastModified: time.Now().UTC().Format(time.RFC3339),
+			Metadata:     append([]storage.MetadataEntry(nil), metadata...),
+		},
+	}
+	s.folders[folder][key] = o
+	return o
+}
@@ -0,0 +1,381 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+)
+
+// BlindIndexSuffix is appended to the name of an encrypted field to form the
+// field that holds its blind index.
+const BlindIndexSuffix = "_bidx"
+
+var (
+	sealedMu sync.RWMutex
+	// sealedFields records, per collection, the fields repositories store
+	// encrypted and their blind indexes.
+	sealedFields = map[string]map[string]bool{}
+)
+
+// encryptedField is a top-level field of a repository model tagged with
+// `encrypt:"true"`, or `encrypt:"true,index"` to also keep a blind index so
+// equality filters keep working.
+type encryptedField struct {
+	name  string
+	typ   reflect.Type
+	index bool
+}
+
+// fieldCrypt encrypts the tagged fields of one collection's documents.
+type fieldCrypt struct {
+	collection string
+	fields     map[string]encryptedField
+	keyring    *Keyring
+}
+
+// newFieldCrypt inspects the model type and returns nil when it has no
+// encrypted fields.
+func newFieldCrypt(collection string, t reflect.Type, keyring *Keyring) *fieldCrypt {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	fields := make(map[string]encryptedField)
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup("encrypt")
+		if !ok || !sf.IsExported() {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		if opts[0] != "true" {
+			continue
+		}
+		name := sf.Name
+		if jt := strings.Split(sf.Tag.Get("json"), ",")[0]; jt == "-" {
+			continue
+		} else if jt != "" {
+			name = jt
+		}
+		f := encryptedField{name: name, typ: sf.Type}
+		for _, o := range opts[1:] {
+			if o == "index" {
+				f.index = true
+			}
+		}
+		fields[name] = f
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	sealedMu.Lock()
+	if sealedFields[collection] == nil {
+		sealedFields[collection] = make(map[string]bool)
+	}
+	for name := range fields {
+		sealedFields[collection][name] = true
+		sealedFields[collection][name+BlindIndexSuffix] = true
+	}
+	sealedMu.Unlock()
+	return &fieldCrypt{collection: collection, fields: fields, keyring: keyring}
+}
+
+// isSealedField reports whether a repository of collection stores the
+// top-level field an RFC 6901 pointer falls in encrypted, or as a blind index.
+func isSealedField(collection, pointer string) bool {
+	tokens, err := parsePointer(pointer)
+	if err != nil || len(tokens) == 0 {
+		return false
+	}
+	sealedMu.RLock()
+	defer sealedMu.RUnlock()
+	return sealedFields[collection][tokens[0]]
+}
+
+func (c *fieldCrypt) keys() *Keyring {
+	if c.keyring != nil {
+		return c.keyring
+	}
+	return DefaultKeyring()
+}
+
+// context is the associated data of a field's ciphertexts. It binds them to
+// the collection and field but not to the record, whose ID is only known
+// once the host has created it: a ciphertext copied into the same field of
+// another record of the collection still opens.
+func (c *fieldCrypt) context(f encryptedField) string {
+	return c.collection + "." + f.name
+}
+
+// sealValue encrypts one field value and stores it, with its blind index,
+// into doc. Null values are stored as null. The value is first round-tripped
+// through the field's Go type, so a patch writing it seals and indexes the
+// same bytes as an encoded model.
+func (c *fieldCrypt) sealValue(doc map[string]json.RawMessage, f encryptedField, raw json.RawMessage) error {
+	if string(raw) == "null" {
+		doc[f.name] = raw
+		if f.index {
+			doc[f.name+BlindIndexSuffix] = raw
+		}
+		return nil
+	}
+	raw, err := c.canonical(f, raw)
+	if err != nil {
+		return err
+	}
+	sealed, err := c.keys().Seal(raw, []byte(c.context(f)))
+	if err != nil {
+		return err
+	}
+	doc[f.name] = json.RawMessage(jsonValue(sealed))
+	if f.index {
+		idx, err := c.keys().BlindIndex(c.context(f), raw)
+		if err != nil {
+			return err
+		}
+		doc[f.name+BlindIndexSuffix] = json.RawMessage(jsonValue(idx))
+	}
+	return nil
+}
+
+// seal encrypts the tagged fields of an encoded model in place.
+func (c *fieldCrypt) seal(doc map[string]json.RawMessage) error {
+	for name, f := range c.fields {
+		raw, ok := doc[name]
+		if !ok {
+			continue
+		}
+		if err := c.sealValue(doc, f, raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// open decrypts the tagged fields of a stored record and drops their blind
+// indexes. Values that are not sealed, such as data written before the field
+// was encrypted, are returned unchanged.
+func (c *fieldCrypt) open(data string) (string, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(data), &doc); err != nil || doc == nil {
+		return data, nil
+	}
+	for name, f := range c.fields {
+		if f.index {
+			delete(doc, name+BlindIndexSuffix)
+		}
+		var sealed string
+		if json.Unmarshal(doc[name], &sealed) != nil || !IsSealed(sealed) {
+			continue
+		}
+		plain, err := c.keys().Open(sealed, []byte(c.context(f)))
+		if err != nil {
+			return "", err
+		}
+		doc[name] = plain
+	}
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return "", &wafer.WaferError{
+			Code:    "internal",
+			Message: "failed to marshal record: " + err.Error(),
+		}
+	}
+	return string(out), nil
+}
+
+// filters rewrites equality filters on indexed encrypted fields into filters
+// on their blind index. Any other filter on an encrypted field is rejected,
+// since it could only ever compare ciphertexts.
+func (c *fieldCrypt) filters(filters []Filter) ([]Filter, error) {
+	if len(filters) == 0 {
+		return filters, nil
+	}
+	out := make([]Filter, len(filters))
+	for i, flt := range filters {
+		f, ok := c.fields[flt.Field]
+		if !ok {
+			out[i] = flt
+			continue
+		}
+		if flt.Operator == OpIsNull || flt.Operator == OpIsNotNull {
+			out[i] = flt
+			continue
+		}
+		if !f.index {
+			return nil, encryptedFilterError(f.name, "it has no blind index")
+		}
+		switch flt.Operator {
+		case OpEqual, OpNotEqual:
+			idx, err := c.indexOf(f, flt.Value)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = Filter{Field: f.name + BlindIndexSuffix, Operator: flt.Operator, Value: jsonValue(idx)}
+		case OpIn:
+			var list []json.RawMessage
+			if err := json.Unmarshal([]byte(flt.Value), &list); err != nil {
+				list = []json.RawMessage{json.RawMessage(flt.Value)}
+			}
+			idxs := make([]string, len(list))
+			for j, v := range list {
+				idx, err := c.indexOf(f, string(v))
+				if err != nil {
+					return nil, err
+				}
+				idxs[j] = idx
+			}
+			out[i] = Filter{Field: f.name + BlindIndexSuffix, Operator: OpIn, Value: jsonValue(idxs)}
+		default:
+			return nil, encryptedFilterError(f.name, "only equality filters are supported")
+		}
+	}
+	return out, nil
+}
+
+// tree rewrites the filters of every group of a filter tree.
+func (c *fieldCrypt) tree(where *FilterTree) (*FilterTree, error) {
+	if where == nil {
+		return nil, nil
+	}
+	out := &FilterTree{Groups: make([]FilterGroup, len(where.Groups))}
+	for i, g := range where.Groups {
+		filters, err := c.filters(g.Filters)
+		if err != nil {
+			return nil, err
+		}
+		out.Groups[i] = FilterGroup{Op: g.Op, Filters: filters, Groups: g.Groups}
+	}
+	return out, nil
+}
+
+// indexOf computes the blind index of a JSON-encoded filter value. A value
+// that is not valid JSON is taken as a string.
+func (c *fieldCrypt) indexOf(f encryptedField, value string) (string, error) {
+	raw, err := c.canonical(f, json.RawMessage(value))
+	if err != nil {
+		if raw, err = c.canonical(f, json.RawMessage(jsonValue(value))); err != nil {
+			return "", err
+		}
+	}
+	return c.keys().BlindIndex(c.context(f), raw)
+}
+
+// canonical round-trips a JSON value through the field's Go type so it
+// encodes, and hashes, exactly like the encoded model does.
+func (c *fieldCrypt) canonical(f encryptedField, raw json.RawMessage) (json.RawMessage, error) {
+	v := reflect.New(f.typ)
+	if err := json.Unmarshal(raw, v.Interface()); err != nil {
+		return nil, &wafer.WaferError{
+			Code:    "invalid_argument",
+			Message: "invalid value for encrypted field " + f.name + ": " + err.Error(),
+		}
+	}
+	out, err := json.Marshal(v.Interface())
+	if err != nil {
+		return nil, &wafer.WaferError{
+			Code:    "internal",
+			Message: "failed to marshal value of encrypted field " + f.name + ": " + err.Error(),
+		}
+	}
+	return out, nil
+}
+
+// patch encrypts the values an incoming patch writes to encrypted fields.
+// Merge patches may set or clear whole fields. JSON Patch operations may
+// add, replace or remove whole fields, but not reach inside them or test
+// them, since the stored values are ciphertexts. Neither kind of patch may
+// replace the whole document, which would write its fields unencrypted.
+func (c *fieldCrypt) patch(format PatchFormat, patch []byte) ([]byte, error) {
+	if format != JSONPatch {
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal(patch, &doc); err != nil || doc == nil {
+			return nil, patchError("merge patch must be a JSON object: the collection has encrypted fields")
+		}
+		for name, f := range c.fields {
+			if raw, ok := doc[name]; ok {
+				if err := c.sealValue(doc, f, raw); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return json.Marshal(doc)
+	}
+
+	var ops []map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return patch, nil
+	}
+	var out []map[string]json.RawMessage
+	for _, op := range ops {
+		var kind, path, from string
+		_ = json.Unmarshal(op["op"], &kind)
+		_ = json.Unmarshal(op["path"], &path)
+		_ = json.Unmarshal(op["from"], &from)
+		if path == "" || (from == "" && (kind == "move" || kind == "copy")) {
+			return nil, patchError("cannot " + kind + " the document root: the collection has encrypted fields")
+		}
+		f, whole, ok := c.fieldAt(path)
+		if !ok {
+			if fromField, _, ok := c.fieldAt(from); ok && (kind == "move" || kind == "copy") {
+				return nil, patchError("cannot " + kind + " encrypted field " + fromField.name)
+			}
+			out = append(out, op)
+			continue
+		}
+		if !whole || kind == "test" || kind == "move" || kind == "copy" {
+			return nil, patchError("cannot " + kind + " " + path + ": field " + f.name + " is encrypted")
+		}
+		switch kind {
+		case "add", "replace":
+			doc := make(map[string]json.RawMessage)
+			if err := c.sealValue(doc, f, op["value"]); err != nil {
+				return nil, err
+			}
+			op["value"] = doc[f.name]
+			out = append(out, op)
+			if f.index {
+				out = append(out, map[string]json.RawMessage{
+					"op":    json.RawMessage(`"add"`),
+					"path":  json.RawMessage(jsonValue("/" + escapePointer(f.name+BlindIndexSuffix))),
+					"value": doc[f.name+BlindIndexSuffix],
+				})
+			}
+		case "remove":
+			out = append(out, op)
+			if f.index {
+				out = append(out, map[string]json.RawMessage{
+					"op":    json.RawMessage(`"add"`),
+					"path":  json.RawMessage(jsonValue("/" + escapePointer(f.name+BlindIndexSuffix))),
+					"value": json.RawMessage("null"),
+				})
+			}
+		default:
+			out = append(out, op)
+		}
+	}
+	return json.Marshal(out)
+}
+
+// fieldAt reports the encrypted field a JSON pointer falls in and whether it
+// addresses the whole field.
+func (c *fieldCrypt) fieldAt(pointer string) (encryptedField, bool, bool) {
+	tokens, err := parsePointer(pointer)
+	if err != nil || len(tokens) == 0 {
+		return encryptedField{}, false, false
+	}
+	f, ok := c.fields[tokens[0]]
+	return f, len(tokens) == 1, ok
+}
+
+func encryptedFilterError(field, reason string) error {
+	return &wafer.WaferError{
+		Code:    "invalid_argument",
+		Message: "cannot filter on encrypted field " + field + ": " + reason,
+	}
+}
@@ -0,0 +1,182 @@
+package services_test
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/wafer-run/wafer-sdk-go/services"
+)
+
+type patient struct {
+	Name string `json:"name"`
+	SSN  string `json:"ssn" encrypt:"true,index"`
+}
+
+func testKeyring() *Keyring {
+	return NewKeyring("k1", map[string][]byte{"k1": make([]byte, 32)}, []byte("index-key"))
+}
+
+func TestRepositoryPatchEncryptedFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  PatchFormat
+		patch   string
+		wantErr bool
+		wantSSN string
+	}{
+		{name: "replace field", format: JSONPatch, patch: `[{"op":"replace","path":"/ssn","value":"222"}]`, wantSSN: "222"},
+		{name: "merge field", format: MergePatch, patch: `{"ssn":"333"}`, wantSSN: "333"},
+		{name: "replace root", format: JSONPatch, patch: `[{"op":"replace","path":"","value":{"name":"x","ssn":"999"}}]`, wantErr: true},
+		{name: "add root", format: JSONPatch, patch: `[{"op":"add","path":"","value":{"ssn":"999"}}]`, wantErr: true},
+		{name: "copy root", format: JSONPatch, patch: `[{"op":"copy","from":"","path":"/name"}]`, wantErr: true},
+		{name: "move root", format: JSONPatch, patch: `[{"op":"move","from":"","path":"/name"}]`, wantErr: true},
+		{name: "copy out of field", format: JSONPatch, patch: `[{"op":"copy","from":"/ssn","path":"/name"}]`, wantErr: true},
+		{name: "test field", format: JSONPatch, patch: `[{"op":"test","path":"/ssn","value":"111"}]`, wantErr: true},
+		{name: "merge non-object", format: MergePatch, patch: `"plain"`, wantErr: true},
+		{name: "merge null", format: MergePatch, patch: `null`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := setup(t)
+			repo := NewRepository[patient]("patients", CollectionOptions{Keyring: testKeyring()})
+			created, err := repo.Create(patient{Name: "ada", SSN: "111"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = repo.Patch(created.ID, tt.format, []byte(tt.patch))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Patch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, rec := range db.Records("patients") {
+				if strings.Contains(rec.Data, `"999"`) || (tt.wantSSN != "" && strings.Contains(rec.Data, `"`+tt.wantSSN+`"`)) {
+					t.Errorf("plaintext stored: %s", rec.Data)
+				}
+			}
+			got, err := repo.Get(created.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.wantSSN
+			if want == "" {
+				want = "111"
+			}
+			if got.Data.SSN != want {
+				t.Errorf("ssn = %q, want %q", got.Data.SSN, want)
+			}
+		})
+	}
+}
+
+func TestRepositoryPatchEncryptedLookup(t *testing.T) {
+	tests := []struct {
+		name   string
+		format PatchFormat
+		patch  string
+	}{
+		{name: "merge patch", format: MergePatch, patch: `{"ssn":"a<b"}`},
+		{name: "json patch", format: JSONPatch, patch: `[{"op":"replace","path":"/ssn","value":"a<b"}]`},
+		{name: "escaped value", format: MergePatch, patch: `{"ssn":"\u0061<b"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup(t)
+			repo := NewRepository[patient]("patients", CollectionOptions{Keyring: testKeyring()})
+			created, err := repo.Create(patient{Name: "ada", SSN: "111"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := repo.Patch(created.ID, tt.format, []byte(tt.patch)); err != nil {
+				t.Fatal(err)
+			}
+			list, err := repo.List(ListOptions{Filters: []Filter{{Field: "ssn", Operator: OpEqual, Value: "a<b"}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(list.Entities) != 1 || list.Entities[0].ID != created.ID {
+				t.Errorf("lookup after patch = %+v, want %s", list.Entities, created.ID)
+			}
+		})
+	}
+}
+
+func TestRepositoryEncryptedFieldsWithSchema(t *testing.T) {
+	const schema = `{
+		"type": "object",
+		"required": ["name", "ssn"],
+		"properties": {
+			"name": {"type": "string"},
+			"ssn": {"type": "string", "pattern": "^[0-9]+$"}
+		}
+	}`
+	tests := []struct {
+		name    string
+		write   func(repo *Repository[patient], id string) error
+		wantErr bool
+	}{
+		{
+			name: "valid update",
+			write: func(repo *Repository[patient], id string) error {
+				_, err := repo.Update(id, patient{Name: "ada", SSN: "222"})
+				return err
+			},
+		},
+		{
+			name: "invalid update",
+			write: func(repo *Repository[patient], id string) error {
+				_, err := repo.Update(id, patient{Name: "ada", SSN: "22x"})
+				return err
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid merge patch",
+			write: func(repo *Repository[patient], id string) error {
+				_, err := repo.Patch(id, MergePatch, []byte(`{"ssn":"333"}`))
+				return err
+			},
+		},
+		{
+			name: "invalid merge patch",
+			write: func(repo *Repository[patient], id string) error {
+				_, err := repo.Patch(id, MergePatch, []byte(`{"ssn":"33x"}`))
+				return err
+			},
+			wantErr: true,
+		},
+		{
+			name: "json patch removing the field",
+			write: func(repo *Repository[patient], id string) error {
+				_, err := repo.Patch(id, JSONPatch, []byte(`[{"op":"remove","path":"/ssn"}]`))
+				return err
+			},
+			wantErr: true,
+		},
+		{
+			name: "soft delete leaves the sealed field alone",
+			write: func(repo *Repository[patient], id string) error {
+				return repo.Delete(id)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup(t)
+			registerSchema(t, "patients", schema)
+			repo := NewRepository[patient]("patients", CollectionOptions{Keyring: testKeyring(), SoftDelete: true})
+			if _, err := repo.Create(patient{Name: "bob", SSN: "1x"}); !isCode(err, "invalid_argument") {
+				t.Fatalf("Create with an invalid ssn = %v, want invalid_argument", err)
+			}
+			created, err := repo.Create(patient{Name: "ada", SSN: "111"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = tt.write(repo, created.ID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("write error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !isCode(err, "invalid_argument") {
+				t.Errorf("write error = %v, want invalid_argument", err)
+			}
+		})
+	}
+}
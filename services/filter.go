@@ -0,0 +1,343 @@
+package services
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+	"github.com/wafer-run/wafer-sdk-go/gen/wafer/database"
+)
+
+// GroupOp is a convenience alias for database.GroupOp.
+type GroupOp = database.GroupOp
+
+// FilterGroup is a convenience alias for database.FilterGroup.
+type FilterGroup = database.FilterGroup
+
+// FilterTree is a convenience alias for database.FilterTree.
+type FilterTree = database.FilterTree
+
+// Re-export group operator constants for convenience.
+const (
+	GroupAnd = database.GroupOpAnd
+	GroupOr  = database.GroupOpOr
+	GroupNot = database.GroupOpNot
+)
+
+// Cond is a boolean filter expression built with Where, And, Or and Not. The
+// zero Cond matches every record.
+type Cond struct {
+	op       GroupOp
+	filter   *Filter
+	children []Cond
+}
+
+// Where returns a condition on a single field. The value is JSON-encoded.
+func Where(field string, op FilterOp, value any) Cond {
+	return Cond{filter: &Filter{Field: field, Operator: op, Value: jsonValue(value)}}
+}
+
+// FilterCond wraps an existing Filter as a condition.
+func FilterCond(f Filter) Cond {
+	return Cond{filter: &f}
+}
+
+// And returns a condition that matches when every cond matches.
+func And(conds ...Cond) Cond {
+	return Cond{op: GroupAnd, children: conds}
+}
+
+// Or returns a condition that matches when any cond matches.
+func Or(conds ...Cond) Cond {
+	return Cond{op: GroupOr, children: conds}
+}
+
+// Not returns a condition that matches when cond does not.
+func Not(cond Cond) Cond {
+	return Cond{op: GroupNot, children: []Cond{cond}}
+}
+
+// IsZero reports whether c is the empty condition.
+func (c Cond) IsZero() bool {
+	return c.filter == nil && c.children == nil
+}
+
+// Tree flattens the condition into the FilterTree wire format. It returns nil
+// for the zero Cond.
+func (c Cond) Tree() *FilterTree {
+	if c.IsZero() {
+		return nil
+	}
+	t := &FilterTree{}
+	if c.filter != nil {
+		c = And(c)
+	}
+	c.flatten(t)
+	return t
+}
+
+// flatten appends c as a group to t and returns its index.
+func (c Cond) flatten(t *FilterTree) uint32 {
+	idx := uint32(len(t.Groups))
+	t.Groups = append(t.Groups, FilterGroup{Op: c.op})
+	var filters []Filter
+	var groups []uint32
+	for _, child := range c.children {
+		if child.filter != nil {
+			filters = append(filters, *child.filter)
+			continue
+		}
+		groups = append(groups, child.flatten(t))
+	}
+	t.Groups[idx].Filters = filters
+	t.Groups[idx].Groups = groups
+	return idx
+}
+
+// MatchRecord reports whether rec satisfies every filter and the optional
+// filter tree. It is the guest-side evaluator used when the host cannot apply
+// a filter itself. The field "id" resolves to the record ID unless the data
+// has its own "id" field, and dotted field names address nested objects.
+func MatchRecord(rec Record, filters []Filter, where *FilterTree) (bool, error) {
+	var doc map[string]any
+	if rec.Data != "" {
+		if err := json.Unmarshal([]byte(rec.Data), &doc); err != nil {
+			return false, &wafer.WaferError{
+				Code:    "internal",
+				Message: "failed to decode record: " + err.Error(),
+			}
+		}
+	}
+	if doc == nil {
+		doc = make(map[string]any)
+	}
+	if _, ok := doc["id"]; !ok {
+		doc["id"] = rec.ID
+	}
+	for _, f := range filters {
+		if !matchFilter(doc, f) {
+			return false, nil
+		}
+	}
+	if where == nil || len(where.Groups) == 0 {
+		return true, nil
+	}
+	return matchGroup(doc, where, 0, 0)
+}
+
+func matchGroup(doc map[string]any, t *FilterTree, idx uint32, depth int) (bool, error) {
+	if int(idx) >= len(t.Groups) || depth > len(t.Groups) {
+		return false, &wafer.WaferError{
+			Code:    "invalid_argument",
+			Message: "malformed filter tree",
+		}
+	}
+	g := t.Groups[idx]
+	results := make([]bool, 0, len(g.Filters)+len(g.Groups))
+	for _, f := range g.Filters {
+		results = append(results, matchFilter(doc, f))
+	}
+	for _, child := range g.Groups {
+		ok, err := matchGroup(doc, t, child, depth+1)
+		if err != nil {
+			return false, err
+		}
+		results = append(results, ok)
+	}
+
+	all, some := true, false
+	for _, r := range results {
+		all = all && r
+		some = some || r
+	}
+	switch g.Op {
+	case GroupOr:
+		return some, nil
+	case GroupNot:
+		return !all, nil
+	default:
+		return all, nil
+	}
+}
+
+func matchFilter(doc map[string]any, f Filter) bool {
+	got, present := LookupField(doc, f.Field)
+	switch f.Operator {
+	case OpIsNull:
+		return !present || got == nil
+	case OpIsNotNull:
+		return present && got != nil
+	}
+	if !present {
+		return f.Operator == OpNotEqual
+	}
+	want := filterValue(f.Value)
+	switch f.Operator {
+	case OpEqual:
+		return valuesEqual(got, want)
+	case OpNotEqual:
+		return !valuesEqual(got, want)
+	case OpGreater, OpGreaterEq, OpLess, OpLessEq:
+		c, ok := CompareValues(got, want)
+		if !ok {
+			return false
+		}
+		switch f.Operator {
+		case OpGreater:
+			return c > 0
+		case OpGreaterEq:
+			return c >= 0
+		case OpLess:
+			return c < 0
+		default:
+			return c <= 0
+		}
+	case OpLike:
+		s, ok := got.(string)
+		pattern, ok2 := want.(string)
+		return ok && ok2 && likeRegexp(pattern).MatchString(s)
+	case OpIn:
+		list, ok := want.([]any)
+		if !ok {
+			return false
+		}
+		for _, v := range list {
+			if valuesEqual(got, v) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// LookupField returns the value of a possibly dotted field in a decoded JSON
+// object. An exact key match takes precedence over a nested path.
+func LookupField(doc map[string]any, field string) (any, bool) {
+	if v, ok := doc[field]; ok {
+		return v, true
+	}
+	var cur any = doc
+	for _, part := range strings.Split(field, ".") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// CompareValues orders two decoded JSON values of the same kind. The boolean
+// result is false when the values are not comparable.
+func CompareValues(a, b any) (int, bool) {
+	switch x := a.(type) {
+	case float64:
+		y, ok := b.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(x, y), true
+	case bool:
+		y, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x == y:
+			return 0, true
+		case !x:
+			return -1, true
+		}
+		return 1, true
+	}
+	return 0, false
+}
+
+// CompareSortValues orders two decoded JSON values for sorting. Nulls and
+// missing values sort first; values that are not comparable sort as equal.
+func CompareSortValues(a, b any) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	c, _ := CompareValues(a, b)
+	return c
+}
+
+// filterValue decodes a filter value. Values that are not valid JSON are
+// treated as plain strings, matching DatabaseGetByField.
+func filterValue(raw string) any {
+	var v any
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		return raw
+	}
+	return v
+}
+
+func valuesEqual(a, b any) bool {
+	if c, ok := CompareValues(a, b); ok {
+		return c == 0
+	}
+	return jsonEqual(a, b)
+}
+
+// likeCacheSize bounds the number of compiled LIKE patterns kept by
+// likeRegexp. The cache is cleared when it fills up.
+const likeCacheSize = 256
+
+var (
+	likeCacheMu sync.Mutex
+	likeCache   = make(map[string]*regexp.Regexp)
+)
+
+// likeRegexp converts a SQL LIKE pattern (% and _ wildcards) to a regexp.
+// Compiled patterns are cached, since a filter is matched against every
+// record of a guest-side scan.
+func likeRegexp(pattern string) *regexp.Regexp {
+	likeCacheMu.Lock()
+	defer likeCacheMu.Unlock()
+	if re, ok := likeCache[pattern]; ok {
+		return re
+	}
+	var b strings.Builder
+	b.WriteString("(?s)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	re := regexp.MustCompile(b.String())
+	if len(likeCache) >= likeCacheSize {
+		clear(likeCache)
+	}
+	likeCache[pattern] = re
+	return re
+}
@@ -0,0 +1,30 @@
+package services_test
+
+import (
+	"testing"
+
+	. "github.com/wafer-run/wafer-sdk-go/services"
+)
+
+func TestCompareSortValues(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b any
+		want int
+	}{
+		{name: "both null", a: nil, b: nil, want: 0},
+		{name: "null first", a: nil, b: 1.0, want: -1},
+		{name: "null last", a: "x", b: nil, want: 1},
+		{name: "numbers", a: 2.0, b: 10.0, want: -1},
+		{name: "strings", a: "b", b: "a", want: 1},
+		{name: "bools", a: false, b: true, want: -1},
+		{name: "mixed kinds", a: "1", b: 1.0, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CompareSortValues(tt.a, tt.b); got != tt.want {
+				t.Errorf("CompareSortValues(%v, %v) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
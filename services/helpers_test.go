@@ -0,0 +1,69 @@
+package services_test
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wafer-run/wafer-sdk-go/gen/wafer/config"
+	"github.com/wafer-run/wafer-sdk-go/gen/wafer/crypto"
+	"github.com/wafer-run/wafer-sdk-go/services/fakes"
+)
+
+// setup installs a fresh fake database and storage, random bytes from
+// crypto/rand, an HMAC token signer and an empty config. The host imports are
+// package globals and are not restored afterwards, so every test that reaches
+// the host calls setup first, and overrides a test makes on top of it last
+// until the next call.
+func setup(t *testing.T) (*fakes.Database, *fakes.Storage) {
+	t.Helper()
+	db := fakes.NewDatabase()
+	db.Install()
+	st := fakes.NewStorage()
+	st.Install()
+	crypto.RandomBytes = func(n uint32) ([]byte, error) {
+		b := make([]byte, n)
+		_, err := rand.Read(b)
+		return b, err
+	}
+	crypto.Sign = signToken
+	crypto.Verify = verifyToken
+	config.Get = func(key string) *string { return nil }
+	return db, st
+}
+
+var signingKey = []byte("test signing key")
+
+// signToken is a stand-in for the host signer: the claims, the expiry and an
+// HMAC over both.
+func signToken(claims string, expirySecs uint64) (string, error) {
+	body := base64.RawURLEncoding.EncodeToString([]byte(claims)) + "." +
+		strconv.FormatInt(time.Now().Unix()+int64(expirySecs), 10)
+	return body + "." + tokenMAC(body), nil
+}
+
+func verifyToken(token string) (string, error) {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 || !hmac.Equal([]byte(token[i+1:]), []byte(tokenMAC(token[:i]))) {
+		return "", errors.New("invalid signature")
+	}
+	enc, exp, _ := strings.Cut(token[:i], ".")
+	if e, err := strconv.ParseInt(exp, 10, 64); err != nil || time.Now().Unix() >= e {
+		return "", errors.New("token expired")
+	}
+	claims, err := base64.RawURLEncoding.DecodeString(enc)
+	return string(claims), err
+}
+
+func tokenMAC(body string) string {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
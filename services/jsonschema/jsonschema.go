@@ -0,0 +1,787 @@
+// Package jsonschema validates JSON documents against a subset of JSON Schema
+// draft 2020-12.
+//
+// Supported keywords: type, enum, const, properties, required,
+// additionalProperties, patternProperties, propertyNames, minProperties,
+// maxProperties, items, prefixItems, contains, minItems, maxItems,
+// uniqueItems, minLength, maxLength, pattern, format (date-time, date,
+// email, uuid, uri), minimum, maximum, exclusiveMinimum, exclusiveMaximum,
+// multipleOf, allOf, anyOf, oneOf, not, if/then/else, $defs and local $ref.
+// Other keywords are ignored.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Violation is a single validation failure.
+type Violation struct {
+	// Pointer is the RFC 6901 JSON pointer of the offending value in the
+	// validated document; "" is the document itself.
+	Pointer string
+	// Keyword is the schema keyword that failed.
+	Keyword string
+	Message string
+}
+
+func (v Violation) String() string {
+	ptr := v.Pointer
+	if ptr == "" {
+		ptr = "/"
+	}
+	return ptr + ": " + v.Message
+}
+
+// Schema is a compiled schema. It is safe for concurrent use.
+type Schema struct {
+	always *bool
+
+	types    []string
+	enum     []any
+	constVal any
+	hasConst bool
+
+	properties    map[string]*Schema
+	required      []string
+	additional    *Schema
+	patternProps  []patternSchema
+	propertyNames *Schema
+	minProps      *int
+	maxProps      *int
+
+	items       *Schema
+	prefixItems []*Schema
+	contains    *Schema
+	minItems    *int
+	maxItems    *int
+	unique      bool
+
+	minLength *int
+	maxLength *int
+	pattern   *regexp.Regexp
+	format    string
+
+	minimum    *float64
+	maximum    *float64
+	exMinimum  *float64
+	exMaximum  *float64
+	multipleOf *float64
+
+	allOf []*Schema
+	anyOf []*Schema
+	oneOf []*Schema
+	not   *Schema
+	ifS   *Schema
+	thenS *Schema
+	elseS *Schema
+	ref   *Schema
+}
+
+type patternSchema struct {
+	re     *regexp.Regexp
+	schema *Schema
+}
+
+// Compile parses and compiles a schema document.
+func Compile(schema []byte) (*Schema, error) {
+	var raw any
+	if err := json.Unmarshal(schema, &raw); err != nil {
+		return nil, errors.New("jsonschema: invalid schema JSON: " + err.Error())
+	}
+	c := &compiler{root: raw, cache: make(map[string]*Schema)}
+	s, err := c.compile(raw, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkCycles(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// MustCompile is like Compile but panics on error. It is meant for schemas
+// embedded in the program.
+func MustCompile(schema []byte) *Schema {
+	s, err := Compile(schema)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Validate checks a decoded JSON value, as produced by json.Unmarshal into
+// an any, and returns every violation found.
+func (s *Schema) Validate(v any) []Violation {
+	var out []Violation
+	s.validate(normalize(v), "", &out)
+	return out
+}
+
+// ValidateJSON decodes a JSON document and validates it.
+func (s *Schema) ValidateJSON(data []byte) ([]Violation, error) {
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, errors.New("jsonschema: invalid JSON document: " + err.Error())
+	}
+	return s.Validate(v), nil
+}
+
+// compiler compiles a schema document, resolving local references through a
+// cache keyed by JSON pointer so that recursive schemas terminate.
+type compiler struct {
+	root  any
+	cache map[string]*Schema
+}
+
+func (c *compiler) compile(raw any, ptr string) (*Schema, error) {
+	if s, ok := c.cache[ptr]; ok {
+		return s, nil
+	}
+	s := &Schema{}
+	c.cache[ptr] = s
+
+	if b, ok := raw.(bool); ok {
+		s.always = &b
+		return s, nil
+	}
+	m, ok := raw.(map[string]any)
+	if !ok {
+		return nil, schemaError(ptr, "schema must be an object or a boolean")
+	}
+
+	var err error
+	sub := func(key string) (*Schema, error) {
+		v, ok := m[key]
+		if !ok {
+			return nil, nil
+		}
+		return c.compile(v, ptr+"/"+escape(key))
+	}
+	subList := func(key string) ([]*Schema, error) {
+		v, ok := m[key]
+		if !ok {
+			return nil, nil
+		}
+		list, ok := v.([]any)
+		if !ok {
+			return nil, schemaError(ptr+"/"+key, "must be an array of schemas")
+		}
+		out := make([]*Schema, len(list))
+		for i, item := range list {
+			if out[i], err = c.compile(item, ptr+"/"+key+"/"+strconv.Itoa(i)); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	intKw := func(key string) (*int, error) {
+		v, ok := m[key]
+		if !ok {
+			return nil, nil
+		}
+		f, ok := v.(float64)
+		if !ok || f < 0 || f != math.Trunc(f) {
+			return nil, schemaError(ptr+"/"+key, "must be a non-negative integer")
+		}
+		n := int(f)
+		return &n, nil
+	}
+	numKw := func(key string) (*float64, error) {
+		v, ok := m[key]
+		if !ok {
+			return nil, nil
+		}
+		f, ok := v.(float64)
+		if !ok {
+			return nil, schemaError(ptr+"/"+key, "must be a number")
+		}
+		return &f, nil
+	}
+
+	switch t := m["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{t}
+	case []any:
+		for _, v := range t {
+			name, ok := v.(string)
+			if !ok {
+				return nil, schemaError(ptr+"/type", "must be a string or an array of strings")
+			}
+			s.types = append(s.types, name)
+		}
+	default:
+		return nil, schemaError(ptr+"/type", "must be a string or an array of strings")
+	}
+	if v, ok := m["enum"]; ok {
+		list, ok := v.([]any)
+		if !ok {
+			return nil, schemaError(ptr+"/enum", "must be an array")
+		}
+		s.enum = list
+	}
+	if v, ok := m["const"]; ok {
+		s.hasConst, s.constVal = true, v
+	}
+
+	if v, ok := m["properties"]; ok {
+		props, ok := v.(map[string]any)
+		if !ok {
+			return nil, schemaError(ptr+"/properties", "must be an object")
+		}
+		s.properties = make(map[string]*Schema, len(props))
+		for name, p := range props {
+			if s.properties[name], err = c.compile(p, ptr+"/properties/"+escape(name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if v, ok := m["required"]; ok {
+		list, ok := v.([]any)
+		if !ok {
+			return nil, schemaError(ptr+"/required", "must be an array of strings")
+		}
+		for _, item := range list {
+			name, ok := item.(string)
+			if !ok {
+				return nil, schemaError(ptr+"/required", "must be an array of strings")
+			}
+			s.required = append(s.required, name)
+		}
+	}
+	if v, ok := m["patternProperties"]; ok {
+		props, ok := v.(map[string]any)
+		if !ok {
+			return nil, schemaError(ptr+"/patternProperties", "must be an object")
+		}
+		keys := make([]string, 0, len(props))
+		for k := range props {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			re, err := regexp.Compile(k)
+			if err != nil {
+				return nil, schemaError(ptr+"/patternProperties", "invalid pattern "+strconv.Quote(k))
+			}
+			ps, err := c.compile(props[k], ptr+"/patternProperties/"+escape(k))
+			if err != nil {
+				return nil, err
+			}
+			s.patternProps = append(s.patternProps, patternSchema{re, ps})
+		}
+	}
+	if s.additional, err = sub("additionalProperties"); err != nil {
+		return nil, err
+	}
+	if s.propertyNames, err = sub("propertyNames"); err != nil {
+		return nil, err
+	}
+	if s.minProps, err = intKw("minProperties"); err != nil {
+		return nil, err
+	}
+	if s.maxProps, err = intKw("maxProperties"); err != nil {
+		return nil, err
+	}
+
+	if s.items, err = sub("items"); err != nil {
+		return nil, err
+	}
+	if s.prefixItems, err = subList("prefixItems"); err != nil {
+		return nil, err
+	}
+	if s.contains, err = sub("contains"); err != nil {
+		return nil, err
+	}
+	if s.minItems, err = intKw("minItems"); err != nil {
+		return nil, err
+	}
+	if s.maxItems, err = intKw("maxItems"); err != nil {
+		return nil, err
+	}
+	s.unique, _ = m["uniqueItems"].(bool)
+
+	if s.minLength, err = intKw("minLength"); err != nil {
+		return nil, err
+	}
+	if s.maxLength, err = intKw("maxLength"); err != nil {
+		return nil, err
+	}
+	if v, ok := m["pattern"]; ok {
+		p, ok := v.(string)
+		if !ok {
+			return nil, schemaError(ptr+"/pattern", "must be a string")
+		}
+		if s.pattern, err = regexp.Compile(p); err != nil {
+			return nil, schemaError(ptr+"/pattern", "invalid pattern: "+err.Error())
+		}
+	}
+	s.format, _ = m["format"].(string)
+
+	for key, dst := range map[string]**float64{
+		"minimum":          &s.minimum,
+		"maximum":          &s.maximum,
+		"exclusiveMinimum": &s.exMinimum,
+		"exclusiveMaximum": &s.exMaximum,
+		"multipleOf":       &s.multipleOf,
+	} {
+		if *dst, err = numKw(key); err != nil {
+			return nil, err
+		}
+	}
+	if s.multipleOf != nil && *s.multipleOf <= 0 {
+		return nil, schemaError(ptr+"/multipleOf", "must be greater than zero")
+	}
+
+	if s.allOf, err = subList("allOf"); err != nil {
+		return nil, err
+	}
+	if s.anyOf, err = subList("anyOf"); err != nil {
+		return nil, err
+	}
+	if s.oneOf, err = subList("oneOf"); err != nil {
+		return nil, err
+	}
+	for key, dst := range map[string]**Schema{
+		"not":  &s.not,
+		"if":   &s.ifS,
+		"then": &s.thenS,
+		"else": &s.elseS,
+	} {
+		if *dst, err = sub(key); err != nil {
+			return nil, err
+		}
+	}
+
+	if v, ok := m["$ref"]; ok {
+		ref, ok := v.(string)
+		if !ok || !strings.HasPrefix(ref, "#") {
+			return nil, schemaError(ptr+"/$ref", "only local references starting with # are supported")
+		}
+		target, err := c.resolve(ref[1:])
+		if err != nil {
+			return nil, schemaError(ptr+"/$ref", err.Error())
+		}
+		if s.ref, err = c.compile(target, ref[1:]); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// checkCycles rejects schemas that reach themselves through keywords applied
+// to the same instance ($ref, allOf, anyOf, oneOf, not, if/then/else).
+// Validating against such a schema never descends into the document, so it
+// would recurse without end.
+func (c *compiler) checkCycles() error {
+	ptrs := make([]string, 0, len(c.cache))
+	for ptr := range c.cache {
+		ptrs = append(ptrs, ptr)
+	}
+	sort.Strings(ptrs)
+	at := make(map[*Schema]string, len(ptrs))
+	for i := len(ptrs) - 1; i >= 0; i-- {
+		at[c.cache[ptrs[i]]] = ptrs[i]
+	}
+
+	const visiting, done = 1, 2
+	state := make(map[*Schema]int, len(ptrs))
+	// visit returns the schema that closes a cycle reachable from s, or nil.
+	var visit func(s *Schema) *Schema
+	visit = func(s *Schema) *Schema {
+		switch state[s] {
+		case visiting:
+			return s
+		case done:
+			return nil
+		}
+		state[s] = visiting
+		for _, next := range s.inPlace() {
+			if loop := visit(next); loop != nil {
+				return loop
+			}
+		}
+		state[s] = done
+		return nil
+	}
+	for _, ptr := range ptrs {
+		if loop := visit(c.cache[ptr]); loop != nil {
+			return schemaError(at[loop], "reference cycle that never descends into the instance")
+		}
+	}
+	return nil
+}
+
+// inPlace returns the subschemas applied to the same instance as s.
+func (s *Schema) inPlace() []*Schema {
+	out := make([]*Schema, 0, len(s.allOf)+len(s.anyOf)+len(s.oneOf)+5)
+	out = append(out, s.allOf...)
+	out = append(out, s.anyOf...)
+	out = append(out, s.oneOf...)
+	for _, sub := range []*Schema{s.ref, s.not, s.ifS, s.thenS, s.elseS} {
+		if sub != nil {
+			out = append(out, sub)
+		}
+	}
+	return out
+}
+
+// resolve looks up a JSON pointer in the root schema document.
+func (c *compiler) resolve(ptr string) (any, error) {
+	cur := c.root
+	if ptr == "" {
+		return cur, nil
+	}
+	if ptr[0] != '/' {
+		return nil, errors.New("unresolvable reference #" + ptr)
+	}
+	for _, tok := range strings.Split(ptr[1:], "/") {
+		tok = strings.ReplaceAll(strings.ReplaceAll(tok, "~1", "/"), "~0", "~")
+		switch n := cur.(type) {
+		case map[string]any:
+			v, ok := n[tok]
+			if !ok {
+				return nil, errors.New("unresolvable reference #" + ptr)
+			}
+			cur = v
+		case []any:
+			i, err := strconv.Atoi(tok)
+			if err != nil || i < 0 || i >= len(n) {
+				return nil, errors.New("unresolvable reference #" + ptr)
+			}
+			cur = n[i]
+		default:
+			return nil, errors.New("unresolvable reference #" + ptr)
+		}
+	}
+	return cur, nil
+}
+
+func (s *Schema) validate(v any, ptr string, out *[]Violation) {
+	if s.always != nil {
+		if !*s.always {
+			*out = append(*out, Violation{ptr, "false", "no value is allowed here"})
+		}
+		return
+	}
+	add := func(keyword, message string) {
+		*out = append(*out, Violation{ptr, keyword, message})
+	}
+
+	if s.ref != nil {
+		s.ref.validate(v, ptr, out)
+	}
+	if len(s.types) > 0 && !matchesType(v, s.types) {
+		add("type", "must be of type "+strings.Join(s.types, " or "))
+		return
+	}
+	if s.enum != nil {
+		found := false
+		for _, e := range s.enum {
+			if equal(v, e) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			add("enum", "must be one of the allowed values")
+		}
+	}
+	if s.hasConst && !equal(v, s.constVal) {
+		add("const", "must be equal to the constant value")
+	}
+
+	switch x := v.(type) {
+	case map[string]any:
+		s.validateObject(x, ptr, out)
+	case []any:
+		s.validateArray(x, ptr, out)
+	case string:
+		s.validateString(x, add)
+	case float64:
+		s.validateNumber(x, add)
+	}
+
+	for _, sub := range s.allOf {
+		sub.validate(v, ptr, out)
+	}
+	if len(s.anyOf) > 0 {
+		ok := false
+		for _, sub := range s.anyOf {
+			if sub.valid(v) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			add("anyOf", "must match at least one schema in anyOf")
+		}
+	}
+	if len(s.oneOf) > 0 {
+		n := 0
+		for _, sub := range s.oneOf {
+			if sub.valid(v) {
+				n++
+			}
+		}
+		if n != 1 {
+			add("oneOf", "must match exactly one schema in oneOf, matched "+strconv.Itoa(n))
+		}
+	}
+	if s.not != nil && s.not.valid(v) {
+		add("not", "must not match the schema in not")
+	}
+	if s.ifS != nil {
+		if s.ifS.valid(v) {
+			if s.thenS != nil {
+				s.thenS.validate(v, ptr, out)
+			}
+		} else if s.elseS != nil {
+			s.elseS.validate(v, ptr, out)
+		}
+	}
+}
+
+func (s *Schema) valid(v any) bool {
+	var out []Violation
+	s.validate(v, "", &out)
+	return len(out) == 0
+}
+
+func (s *Schema) validateObject(obj map[string]any, ptr string, out *[]Violation) {
+	for _, name := range s.required {
+		if _, ok := obj[name]; !ok {
+			*out = append(*out, Violation{ptr + "/" + escape(name), "required", "is required"})
+		}
+	}
+	if s.minProps != nil && len(obj) < *s.minProps {
+		*out = append(*out, Violation{ptr, "minProperties", "must have at least " + strconv.Itoa(*s.minProps) + " properties"})
+	}
+	if s.maxProps != nil && len(obj) > *s.maxProps {
+		*out = append(*out, Violation{ptr, "maxProperties", "must have at most " + strconv.Itoa(*s.maxProps) + " properties"})
+	}
+
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		child := ptr + "/" + escape(k)
+		if s.propertyNames != nil {
+			var names []Violation
+			s.propertyNames.validate(k, child, &names)
+			if len(names) > 0 {
+				*out = append(*out, Violation{child, "propertyNames", "property name " + strconv.Quote(k) + " is not allowed"})
+			}
+		}
+		matched := false
+		if p, ok := s.properties[k]; ok {
+			matched = true
+			p.validate(obj[k], child, out)
+		}
+		for _, pp := range s.patternProps {
+			if pp.re.MatchString(k) {
+				matched = true
+				pp.schema.validate(obj[k], child, out)
+			}
+		}
+		if !matched && s.additional != nil {
+			if s.additional.always != nil && !*s.additional.always {
+				*out = append(*out, Violation{child, "additionalProperties", "is not an allowed property"})
+				continue
+			}
+			s.additional.validate(obj[k], child, out)
+		}
+	}
+}
+
+func (s *Schema) validateArray(arr []any, ptr string, out *[]Violation) {
+	if s.minItems != nil && len(arr) < *s.minItems {
+		*out = append(*out, Violation{ptr, "minItems", "must have at least " + strconv.Itoa(*s.minItems) + " items"})
+	}
+	if s.maxItems != nil && len(arr) > *s.maxItems {
+		*out = append(*out, Violation{ptr, "maxItems", "must have at most " + strconv.Itoa(*s.maxItems) + " items"})
+	}
+	for i, item := range arr {
+		child := ptr + "/" + strconv.Itoa(i)
+		switch {
+		case i < len(s.prefixItems):
+			s.prefixItems[i].validate(item, child, out)
+		case s.items != nil:
+			s.items.validate(item, child, out)
+		}
+	}
+	if s.contains != nil {
+		found := false
+		for _, item := range arr {
+			if s.contains.valid(item) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			*out = append(*out, Violation{ptr, "contains", "must contain a matching item"})
+		}
+	}
+	if s.unique {
+		for i := 0; i < len(arr); i++ {
+			for j := i + 1; j < len(arr); j++ {
+				if equal(arr[i], arr[j]) {
+					*out = append(*out, Violation{ptr + "/" + strconv.Itoa(j), "uniqueItems", "duplicates item " + strconv.Itoa(i)})
+				}
+			}
+		}
+	}
+}
+
+func (s *Schema) validateString(str string, add func(keyword, message string)) {
+	n := utf8.RuneCountInString(str)
+	if s.minLength != nil && n < *s.minLength {
+		add("minLength", "must be at least "+strconv.Itoa(*s.minLength)+" characters long")
+	}
+	if s.maxLength != nil && n > *s.maxLength {
+		add("maxLength", "must be at most "+strconv.Itoa(*s.maxLength)+" characters long")
+	}
+	if s.pattern != nil && !s.pattern.MatchString(str) {
+		add("pattern", "must match pattern "+strconv.Quote(s.pattern.String()))
+	}
+	if s.format != "" && !validFormat(s.format, str) {
+		add("format", "must be a valid "+s.format)
+	}
+}
+
+func (s *Schema) validateNumber(f float64, add func(keyword, message string)) {
+	num := strconv.FormatFloat
+	if s.minimum != nil && f < *s.minimum {
+		add("minimum", "must be >= "+num(*s.minimum, 'g', -1, 64))
+	}
+	if s.maximum != nil && f > *s.maximum {
+		add("maximum", "must be <= "+num(*s.maximum, 'g', -1, 64))
+	}
+	if s.exMinimum != nil && f <= *s.exMinimum {
+		add("exclusiveMinimum", "must be > "+num(*s.exMinimum, 'g', -1, 64))
+	}
+	if s.exMaximum != nil && f >= *s.exMaximum {
+		add("exclusiveMaximum", "must be < "+num(*s.exMaximum, 'g', -1, 64))
+	}
+	if s.multipleOf != nil {
+		q := f / *s.multipleOf
+		if math.Abs(q-math.Round(q)) > 1e-9 {
+			add("multipleOf", "must be a multiple of "+num(*s.multipleOf, 'g', -1, 64))
+		}
+	}
+}
+
+func matchesType(v any, types []string) bool {
+	for _, t := range types {
+		switch t {
+		case "null":
+			if v == nil {
+				return true
+			}
+		case "boolean":
+			if _, ok := v.(bool); ok {
+				return true
+			}
+		case "object":
+			if _, ok := v.(map[string]any); ok {
+				return true
+			}
+		case "array":
+			if _, ok := v.([]any); ok {
+				return true
+			}
+		case "string":
+			if _, ok := v.(string); ok {
+				return true
+			}
+		case "number":
+			if _, ok := v.(float64); ok {
+				return true
+			}
+		case "integer":
+			if f, ok := v.(float64); ok && f == math.Trunc(f) && !math.IsInf(f, 0) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var (
+	emailRe = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	uuidRe  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	uriRe   = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*:[^\s]*$`)
+)
+
+// validFormat checks the formats listed in the package documentation;
+// unknown formats are treated as annotations and always pass.
+func validFormat(format, s string) bool {
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339Nano, s)
+		return err == nil
+	case "date":
+		_, err := time.Parse("2006-01-02", s)
+		return err == nil
+	case "email":
+		return emailRe.MatchString(s)
+	case "uuid":
+		return uuidRe.MatchString(s)
+	case "uri":
+		return uriRe.MatchString(s)
+	default:
+		return true
+	}
+}
+
+// normalize converts json.Number values to float64 so documents decoded with
+// UseNumber validate the same as plain ones.
+func normalize(v any) any {
+	switch x := v.(type) {
+	case json.Number:
+		f, _ := x.Float64()
+		return f
+	case map[string]any:
+		out := make(map[string]any, len(x))
+		for k, e := range x {
+			out[k] = normalize(e)
+		}
+		return out
+	case []any:
+		out := make([]any, len(x))
+		for i, e := range x {
+			out[i] = normalize(e)
+		}
+		return out
+	}
+	return v
+}
+
+func equal(a, b any) bool {
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+func escape(tok string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(tok, "~", "~0"), "/", "~1")
+}
+
+func schemaError(ptr, message string) error {
+	if ptr == "" {
+		ptr = "/"
+	}
+	return errors.New("jsonschema: " + ptr + ": " + message)
+}
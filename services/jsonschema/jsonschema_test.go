@@ -0,0 +1,118 @@
+package jsonschema_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/wafer-run/wafer-sdk-go/services/jsonschema"
+)
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		wantErr string
+	}{
+		{name: "empty", schema: `{}`},
+		{name: "boolean", schema: `false`},
+		{name: "recursive through properties", schema: `{"$defs":{"node":{"type":"object","properties":{"next":{"$ref":"#/$defs/node"}}}},"$ref":"#/$defs/node"}`},
+		{name: "recursive through items", schema: `{"type":"array","items":{"$ref":"#"}}`},
+		{name: "shared definition", schema: `{"$defs":{"a":{"type":"string"}},"allOf":[{"$ref":"#/$defs/a"},{"$ref":"#/$defs/a"}]}`},
+		{name: "invalid JSON", schema: `{`, wantErr: "invalid schema JSON"},
+		{name: "not an object", schema: `1`, wantErr: "must be an object or a boolean"},
+		{name: "bad type", schema: `{"type":1}`, wantErr: "/type"},
+		{name: "negative minLength", schema: `{"minLength":-1}`, wantErr: "/minLength"},
+		{name: "bad pattern", schema: `{"pattern":"("}`, wantErr: "/pattern"},
+		{name: "zero multipleOf", schema: `{"multipleOf":0}`, wantErr: "/multipleOf"},
+		{name: "remote reference", schema: `{"$ref":"http://example.com/s.json"}`, wantErr: "/$ref"},
+		{name: "unresolvable reference", schema: `{"$ref":"#/$defs/missing"}`, wantErr: "/$ref"},
+		{name: "self reference", schema: `{"$ref":"#"}`, wantErr: "reference cycle"},
+		{name: "reference cycle", schema: `{"$defs":{"a":{"$ref":"#/$defs/a"}},"$ref":"#/$defs/a"}`, wantErr: "reference cycle"},
+		{name: "two step cycle", schema: `{"$defs":{"a":{"$ref":"#/$defs/b"},"b":{"$ref":"#/$defs/a"}},"$ref":"#/$defs/a"}`, wantErr: "reference cycle"},
+		{name: "cycle through allOf", schema: `{"allOf":[{"$ref":"#"}]}`, wantErr: "reference cycle"},
+		{name: "cycle through not", schema: `{"$defs":{"a":{"not":{"$ref":"#/$defs/a"}}},"properties":{"x":{"$ref":"#/$defs/a"}}}`, wantErr: "reference cycle"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := jsonschema.Compile([]byte(tt.schema))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Compile error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Compile error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		doc    string
+		want   []string // "pointer keyword" of each violation, in order
+	}{
+		{name: "false schema", schema: `false`, doc: `1`, want: []string{" false"}},
+		{name: "type", schema: `{"type":"string"}`, doc: `1`, want: []string{" type"}},
+		{name: "type list", schema: `{"type":["string","null"]}`, doc: `null`},
+		{name: "integer", schema: `{"type":"integer"}`, doc: `1.0`},
+		{name: "not an integer", schema: `{"type":"integer"}`, doc: `1.5`, want: []string{" type"}},
+		{name: "enum", schema: `{"enum":["a",1]}`, doc: `"b"`, want: []string{" enum"}},
+		{name: "const", schema: `{"const":{"a":[1]}}`, doc: `{"a":[1]}`},
+		{
+			name:   "object",
+			schema: `{"required":["a"],"properties":{"b":{"type":"string"}},"additionalProperties":false}`,
+			doc:    `{"b":1,"c":true}`,
+			want:   []string{"/a required", "/b type", "/c additionalProperties"},
+		},
+		{
+			name:   "pattern properties",
+			schema: `{"patternProperties":{"^x_":{"type":"integer"}},"additionalProperties":{"type":"string"}}`,
+			doc:    `{"x_a":1,"x_b":"no","y":"ok"}`,
+			want:   []string{"/x_b type"},
+		},
+		{name: "property names", schema: `{"propertyNames":{"maxLength":2}}`, doc: `{"abc":1}`, want: []string{"/abc propertyNames"}},
+		{name: "escaped pointer", schema: `{"properties":{"a/b":{"type":"string"}}}`, doc: `{"a/b":1}`, want: []string{"/a~1b type"}},
+		{name: "items", schema: `{"prefixItems":[{"type":"string"}],"items":{"type":"integer"}}`, doc: `["a",1,"b"]`, want: []string{"/2 type"}},
+		{name: "unique items", schema: `{"uniqueItems":true}`, doc: `[1,{"a":1},{"a":1}]`, want: []string{"/2 uniqueItems"}},
+		{name: "contains", schema: `{"contains":{"const":2}}`, doc: `[1,3]`, want: []string{" contains"}},
+		{name: "string length counts runes", schema: `{"maxLength":2}`, doc: `"éé"`},
+		{name: "pattern", schema: `{"pattern":"^[0-9]+$"}`, doc: `"12a"`, want: []string{" pattern"}},
+		{name: "format", schema: `{"format":"date-time"}`, doc: `"yesterday"`, want: []string{" format"}},
+		{name: "range", schema: `{"minimum":1,"exclusiveMaximum":3}`, doc: `3`, want: []string{" exclusiveMaximum"}},
+		{name: "multipleOf", schema: `{"multipleOf":0.1}`, doc: `0.3`},
+		{name: "anyOf", schema: `{"anyOf":[{"type":"string"},{"type":"integer"}]}`, doc: `true`, want: []string{" anyOf"}},
+		{name: "oneOf", schema: `{"oneOf":[{"minimum":1},{"minimum":2}]}`, doc: `3`, want: []string{" oneOf"}},
+		{name: "not", schema: `{"not":{"type":"null"}}`, doc: `null`, want: []string{" not"}},
+		{name: "if then", schema: `{"if":{"minimum":10},"then":{"multipleOf":10},"else":{"maximum":5}}`, doc: `15`, want: []string{" multipleOf"}},
+		{name: "if else", schema: `{"if":{"minimum":10},"then":{"multipleOf":10},"else":{"maximum":5}}`, doc: `7`, want: []string{" maximum"}},
+		{
+			name:   "recursive reference",
+			schema: `{"$defs":{"node":{"type":"object","required":["v"],"properties":{"next":{"$ref":"#/$defs/node"}}}},"$ref":"#/$defs/node"}`,
+			doc:    `{"v":1,"next":{"v":2,"next":{}}}`,
+			want:   []string{"/next/next/v required"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := jsonschema.Compile([]byte(tt.schema))
+			if err != nil {
+				t.Fatal(err)
+			}
+			violations, err := s.ValidateJSON([]byte(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, v := range violations {
+				got = append(got, v.Pointer+" "+v.Keyword)
+			}
+			if strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+				t.Errorf("violations = %v, want %v", violations, tt.want)
+			}
+		})
+	}
+}
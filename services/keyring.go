@@ -0,0 +1,325 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"sync"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+)
+
+// DefaultKeyringPrefix is the config prefix read by DefaultKeyring.
+const DefaultKeyringPrefix = "encryption"
+
+// sealedPrefix marks values produced by Keyring.Seal.
+const sealedPrefix = "enc:v1:"
+
+// Keyring holds the key-encryption keys used for envelope encryption. Every
+// value is encrypted with a fresh random data key, and the data key is
+// wrapped with the keyring's current key. The wrapping key's ID is stored
+// next to the ciphertext, so rotating the current key only affects new
+// writes while older values stay readable as long as their key is.
+type Keyring struct {
+	current func() (string, []byte, error)
+	lookup  func(id string) ([]byte, error)
+	index   func() ([]byte, error)
+
+	mu    sync.Mutex
+	aeads map[string]cipher.AEAD
+}
+
+// NewKeyring creates a keyring from in-memory keys. current names the key
+// used for new values; indexKey is used for blind indexes and may be nil if
+// none are needed.
+func NewKeyring(current string, keys map[string][]byte, indexKey []byte) *Keyring {
+	return &Keyring{
+		current: func() (string, []byte, error) {
+			k, ok := keys[current]
+			if !ok {
+				return "", nil, keyNotFound(current)
+			}
+			return current, k, nil
+		},
+		lookup: func(id string) ([]byte, error) {
+			k, ok := keys[id]
+			if !ok {
+				return nil, keyNotFound(id)
+			}
+			return k, nil
+		},
+		index: func() ([]byte, error) {
+			if indexKey == nil {
+				return nil, keyNotFound("index")
+			}
+			return indexKey, nil
+		},
+	}
+}
+
+// ConfigKeyring reads keys from config. "<prefix>.current" names the current
+// key, each key is stored base64-encoded under "<prefix>.key.<id>" and the
+// blind-index key under "<prefix>.index_key". Keys are looked up on demand,
+// so retired keys only need to remain in config.
+func ConfigKeyring(prefix string) *Keyring {
+	read := func(key string) ([]byte, error) {
+		v, ok := ConfigGet(key)
+		if !ok {
+			return nil, &wafer.WaferError{
+				Code:    "failed_precondition",
+				Message: "config key " + key + " is not set",
+			}
+		}
+		b, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, &wafer.WaferError{
+				Code:    "failed_precondition",
+				Message: "config key " + key + " is not valid base64",
+			}
+		}
+		return b, nil
+	}
+	return &Keyring{
+		current: func() (string, []byte, error) {
+			id, ok := ConfigGet(prefix + ".current")
+			if !ok || id == "" {
+				return "", nil, &wafer.WaferError{
+					Code:    "failed_precondition",
+					Message: "config key " + prefix + ".current is not set",
+				}
+			}
+			k, err := read(prefix + ".key." + id)
+			return id, k, err
+		},
+		lookup: func(id string) ([]byte, error) { return read(prefix + ".key." + id) },
+		index:  func() ([]byte, error) { return read(prefix + ".index_key") },
+	}
+}
+
+// HostKeyring uses the keys held by the host crypto service.
+func HostKeyring() *Keyring {
+	return &Keyring{
+		current: func() (string, []byte, error) {
+			k, err := CryptoCurrentKey()
+			return k.ID, k.Material, err
+		},
+		lookup: CryptoGetKey,
+		index:  CryptoIndexKey,
+	}
+}
+
+var (
+	defaultKeyringOnce sync.Once
+	defaultKeyring     *Keyring
+)
+
+// DefaultKeyring returns ConfigKeyring(DefaultKeyringPrefix) when
+// "encryption.current" is set in config, and HostKeyring otherwise.
+func DefaultKeyring() *Keyring {
+	defaultKeyringOnce.Do(func() {
+		if _, ok := ConfigGet(DefaultKeyringPrefix + ".current"); ok {
+			defaultKeyring = ConfigKeyring(DefaultKeyringPrefix)
+		} else {
+			defaultKeyring = HostKeyring()
+		}
+	})
+	return defaultKeyring
+}
+
+// WrapKey encrypts a data key with the current key and returns the ID of the
+// key that was used.
+func (k *Keyring) WrapKey(dataKey []byte) (keyID string, wrapped []byte, err error) {
+	id, kek, err := k.current()
+	if err != nil {
+		return "", nil, keyError(err)
+	}
+	if strings.Contains(id, ":") {
+		return "", nil, &wafer.WaferError{
+			Code:    "failed_precondition",
+			Message: "key ID " + id + " must not contain ':'",
+		}
+	}
+	aead, err := k.aead(id, kek)
+	if err != nil {
+		return "", nil, err
+	}
+	wrapped, err = aeadSeal(aead, dataKey, []byte(id))
+	return id, wrapped, err
+}
+
+// UnwrapKey decrypts a data key wrapped by WrapKey.
+func (k *Keyring) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	kek, err := k.lookup(keyID)
+	if err != nil {
+		return nil, keyError(err)
+	}
+	aead, err := k.aead(keyID, kek)
+	if err != nil {
+		return nil, err
+	}
+	return aeadOpen(aead, wrapped, []byte(keyID))
+}
+
+// Seal envelope-encrypts plaintext. aad is authenticated but not stored, and
+// the same aad must be passed to Open. A ciphertext only opens with the aad
+// it was sealed with, so encoding where the value is stored in aad prevents
+// it from being moved to a place with a different aad; places sharing an aad
+// are interchangeable.
+func (k *Keyring) Seal(plaintext, aad []byte) (string, error) {
+	dataKey, err := CryptoRandomBytes(32)
+	if err != nil {
+		return "", &wafer.WaferError{
+			Code:    "internal",
+			Message: "failed to generate data key: " + err.Error(),
+		}
+	}
+	id, wrapped, err := k.WrapKey(dataKey)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ct, err := aeadSeal(aead, plaintext, aad)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawStdEncoding
+	return sealedPrefix + id + ":" + enc.EncodeToString(wrapped) + ":" + enc.EncodeToString(ct), nil
+}
+
+// Open decrypts a value produced by Seal.
+func (k *Keyring) Open(sealed string, aad []byte) ([]byte, error) {
+	parts := strings.Split(strings.TrimPrefix(sealed, sealedPrefix), ":")
+	if !IsSealed(sealed) || len(parts) != 3 {
+		return nil, &wafer.WaferError{
+			Code:    "data_loss",
+			Message: "value is not a sealed ciphertext",
+		}
+	}
+	enc := base64.RawStdEncoding
+	wrapped, err1 := enc.DecodeString(parts[1])
+	ct, err2 := enc.DecodeString(parts[2])
+	if err1 != nil || err2 != nil {
+		return nil, &wafer.WaferError{
+			Code:    "data_loss",
+			Message: "sealed value is corrupt",
+		}
+	}
+	dataKey, err := k.UnwrapKey(parts[0], wrapped)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return aeadOpen(aead, ct, aad)
+}
+
+// BlindIndex returns a keyed hash of value for equality lookups on encrypted
+// data. The context, typically "<collection>.<field>", keeps equal values in
+// different fields from producing the same index.
+func (k *Keyring) BlindIndex(context string, value []byte) (string, error) {
+	key, err := k.index()
+	if err != nil {
+		return "", keyError(err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(context))
+	mac.Write([]byte{0})
+	mac.Write(value)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// IsSealed reports whether s looks like a value produced by Keyring.Seal.
+func IsSealed(s string) bool {
+	return strings.HasPrefix(s, sealedPrefix)
+}
+
+func (k *Keyring) aead(id string, key []byte) (cipher.AEAD, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if a, ok := k.aeads[id]; ok {
+		return a, nil
+	}
+	a, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if k.aeads == nil {
+		k.aeads = make(map[string]cipher.AEAD)
+	}
+	k.aeads[id] = a
+	return a, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, &wafer.WaferError{
+			Code:    "failed_precondition",
+			Message: "invalid encryption key: " + err.Error(),
+		}
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, &wafer.WaferError{
+			Code:    "internal",
+			Message: "failed to create AES-GCM cipher: " + err.Error(),
+		}
+	}
+	return aead, nil
+}
+
+// aeadSeal encrypts with a random nonce and returns nonce || ciphertext.
+func aeadSeal(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce, err := CryptoRandomBytes(uint32(aead.NonceSize()))
+	if err != nil {
+		return nil, &wafer.WaferError{
+			Code:    "internal",
+			Message: "failed to generate nonce: " + err.Error(),
+		}
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func aeadOpen(aead cipher.AEAD, data, aad []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, &wafer.WaferError{
+			Code:    "data_loss",
+			Message: "ciphertext is truncated",
+		}
+	}
+	n := aead.NonceSize()
+	plaintext, err := aead.Open(nil, data[:n], data[n:], aad)
+	if err != nil {
+		return nil, &wafer.WaferError{
+			Code:    "data_loss",
+			Message: "failed to decrypt: ciphertext is corrupt or was sealed with a different key",
+		}
+	}
+	return plaintext, nil
+}
+
+func keyNotFound(id string) error {
+	return &wafer.WaferError{
+		Code:    "failed_precondition",
+		Message: "encryption key " + id + " not found",
+	}
+}
+
+// keyError turns host key errors into WaferErrors.
+func keyError(err error) error {
+	if _, ok := err.(*wafer.WaferError); ok {
+		return err
+	}
+	return &wafer.WaferError{
+		Code:    "failed_precondition",
+		Message: "encryption key unavailable: " + err.Error(),
+	}
+}
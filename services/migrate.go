@@ -0,0 +1,394 @@
+package services
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+)
+
+// DefaultMigrationsCollection is the bookkeeping collection used by a
+// Migrator when none is given.
+const DefaultMigrationsCollection = "_wafer_migrations"
+
+// DefaultMigrationLockTTL is how long a migration lock is honoured before
+// another instance may take it over.
+const DefaultMigrationLockTTL = 5 * time.Minute
+
+// timestampLayout formats stored UTC times with a fixed width, so that they
+// compare and sort correctly as strings. time.RFC3339Nano drops trailing
+// zeros from the fraction and does not.
+const timestampLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// Migration is a single versioned schema change. A step runs either embedded
+// SQL (UpSQL/DownSQL, executed with DatabaseExecRaw) or Go functions
+// (Up/Down). Down steps are optional and only needed for rollbacks.
+type Migration struct {
+	Version int64
+	Name    string
+	UpSQL   string
+	DownSQL string
+	Up      func() error
+	Down    func() error
+}
+
+// AppliedMigration is a bookkeeping entry for a migration that has run.
+type AppliedMigration struct {
+	Version   int64  `json:"version"`
+	Name      string `json:"name"`
+	AppliedAt string `json:"applied_at"`
+}
+
+// Migrator applies ordered, versioned migrations and records the applied
+// versions in a bookkeeping collection. A lock record in the same collection
+// guards against two instances migrating at the same time.
+type Migrator struct {
+	collection string
+	lockTTL    time.Duration
+	migrations []Migration
+}
+
+// NewMigrator creates a Migrator that tracks applied versions in collection.
+// An empty collection uses DefaultMigrationsCollection.
+func NewMigrator(collection string) *Migrator {
+	if collection == "" {
+		collection = DefaultMigrationsCollection
+	}
+	return &Migrator{collection: collection, lockTTL: DefaultMigrationLockTTL}
+}
+
+// LockTTL sets how long the migration lock is held before it is considered
+// abandoned.
+func (m *Migrator) LockTTL(ttl time.Duration) *Migrator {
+	m.lockTTL = ttl
+	return m
+}
+
+// Add registers migrations. Order of registration does not matter; steps are
+// always applied in ascending version order.
+func (m *Migrator) Add(migrations ...Migration) *Migrator {
+	m.migrations = append(m.migrations, migrations...)
+	return m
+}
+
+// AddSQL registers a migration backed by embedded SQL statements.
+func (m *Migrator) AddSQL(version int64, name, up, down string) *Migrator {
+	return m.Add(Migration{Version: version, Name: name, UpSQL: up, DownSQL: down})
+}
+
+// AddFunc registers a migration backed by Go functions.
+func (m *Migrator) AddFunc(version int64, name string, up, down func() error) *Migrator {
+	return m.Add(Migration{Version: version, Name: name, Up: up, Down: down})
+}
+
+// AddFS registers SQL migrations from dir in fsys, typically an embed.FS.
+// Files must be named <version>_<name>.up.sql and, optionally,
+// <version>_<name>.down.sql.
+func (m *Migrator) AddFS(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return &wafer.WaferError{
+			Code:    "internal",
+			Message: "failed to read migrations: " + err.Error(),
+		}
+	}
+	byVersion := make(map[int64]*Migration)
+	var versions []int64
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		base := e.Name()
+		var down bool
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			base = strings.TrimSuffix(base, ".up.sql")
+		case strings.HasSuffix(base, ".down.sql"):
+			base = strings.TrimSuffix(base, ".down.sql")
+			down = true
+		default:
+			continue
+		}
+		num, name, _ := strings.Cut(base, "_")
+		version, err := strconv.ParseInt(num, 10, 64)
+		if err != nil {
+			return &wafer.WaferError{
+				Code:    "invalid_argument",
+				Message: "invalid migration file name: " + e.Name(),
+			}
+		}
+		body, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return &wafer.WaferError{
+				Code:    "internal",
+				Message: "failed to read migration " + e.Name() + ": " + err.Error(),
+			}
+		}
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: name}
+			byVersion[version] = mig
+			versions = append(versions, version)
+		}
+		if down {
+			mig.DownSQL = string(body)
+		} else {
+			mig.UpSQL = string(body)
+		}
+	}
+	for _, v := range versions {
+		if byVersion[v].UpSQL == "" {
+			return &wafer.WaferError{
+				Code:    "invalid_argument",
+				Message: "migration " + strconv.FormatInt(v, 10) + " has no .up.sql file",
+			}
+		}
+		m.Add(*byVersion[v])
+	}
+	return nil
+}
+
+// Lifecycle applies pending migrations on the Init event and ignores all other
+// events, so a block can delegate to it from its own Lifecycle method.
+func (m *Migrator) Lifecycle(event wafer.LifecycleEvent) error {
+	if event.Type != wafer.Init {
+		return nil
+	}
+	return m.Up()
+}
+
+// Up applies every registered migration that has not been applied yet.
+func (m *Migrator) Up() error {
+	steps, err := m.sorted()
+	if err != nil {
+		return err
+	}
+	release, err := m.lock()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	applied, err := m.appliedVersions()
+	if err != nil {
+		return err
+	}
+	for _, step := range steps {
+		if _, ok := applied[step.Version]; ok {
+			continue
+		}
+		if err := runStep(step.Up, step.UpSQL); err != nil {
+			return migrationError(step, "up", err)
+		}
+		_, err := DatabaseCreate(m.collection, map[string]any{
+			"kind":       "migration",
+			"version":    step.Version,
+			"name":       step.Name,
+			"applied_at": time.Now().UTC().Format(timestampLayout),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Down rolls back applied migrations with a version greater than target, in
+// descending order. Use a target of 0 to roll back everything.
+func (m *Migrator) Down(target int64) error {
+	steps, err := m.sorted()
+	if err != nil {
+		return err
+	}
+	release, err := m.lock()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	applied, err := m.appliedVersions()
+	if err != nil {
+		return err
+	}
+	for i := len(steps) - 1; i >= 0; i-- {
+		step := steps[i]
+		id, ok := applied[step.Version]
+		if !ok || step.Version <= target {
+			continue
+		}
+		if step.Down == nil && step.DownSQL == "" {
+			return &wafer.WaferError{
+				Code:    "failed_precondition",
+				Message: "migration " + strconv.FormatInt(step.Version, 10) + " has no down step",
+			}
+		}
+		if err := runStep(step.Down, step.DownSQL); err != nil {
+			return migrationError(step, "down", err)
+		}
+		if err := DatabaseDelete(m.collection, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Applied returns the bookkeeping entries for applied migrations in ascending
+// version order.
+func (m *Migrator) Applied() ([]AppliedMigration, error) {
+	rl, err := DatabaseList(m.collection, ListOptions{
+		Filters: []Filter{{Field: "kind", Operator: OpEqual, Value: jsonValue("migration")}},
+		Sort:    []SortField{{Field: "version"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]AppliedMigration, 0, len(rl.Records))
+	for _, rec := range rl.Records {
+		var a AppliedMigration
+		if err := json.Unmarshal([]byte(rec.Data), &a); err != nil {
+			return nil, &wafer.WaferError{
+				Code:    "internal",
+				Message: "failed to decode migration record: " + err.Error(),
+			}
+		}
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// sorted validates the registered migrations and returns them in ascending
+// version order.
+func (m *Migrator) sorted() ([]Migration, error) {
+	steps := append([]Migration(nil), m.migrations...)
+	sort.Slice(steps, func(i, j int) bool { return steps[i].Version < steps[j].Version })
+	for i, step := range steps {
+		if step.Version <= 0 {
+			return nil, &wafer.WaferError{
+				Code:    "invalid_argument",
+				Message: "migration versions must be positive",
+			}
+		}
+		if i > 0 && steps[i-1].Version == step.Version {
+			return nil, &wafer.WaferError{
+				Code:    "invalid_argument",
+				Message: "duplicate migration version " + strconv.FormatInt(step.Version, 10),
+			}
+		}
+		if step.Up == nil && step.UpSQL == "" {
+			return nil, &wafer.WaferError{
+				Code:    "invalid_argument",
+				Message: "migration " + strconv.FormatInt(step.Version, 10) + " has no up step",
+			}
+		}
+	}
+	return steps, nil
+}
+
+// appliedVersions maps applied versions to their bookkeeping record IDs.
+func (m *Migrator) appliedVersions() (map[int64]string, error) {
+	rl, err := DatabaseList(m.collection, ListOptions{
+		Filters: []Filter{{Field: "kind", Operator: OpEqual, Value: jsonValue("migration")}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]string, len(rl.Records))
+	for _, rec := range rl.Records {
+		var a AppliedMigration
+		if err := json.Unmarshal([]byte(rec.Data), &a); err != nil {
+			return nil, &wafer.WaferError{
+				Code:    "internal",
+				Message: "failed to decode migration record: " + err.Error(),
+			}
+		}
+		applied[a.Version] = rec.ID
+	}
+	return applied, nil
+}
+
+// lock takes the migration lock. Expired locks are removed first; then a lock
+// record is created and the instance holding the oldest live lock wins. The
+// losing instance removes its own record and fails with "aborted".
+func (m *Migrator) lock() (func(), error) {
+	now := time.Now().UTC()
+	lockFilter := Filter{Field: "kind", Operator: OpEqual, Value: jsonValue("lock")}
+
+	expired, err := DatabaseList(m.collection, ListOptions{
+		Filters: []Filter{lockFilter, {
+			Field:    "expires_at",
+			Operator: OpLess,
+			Value:    jsonValue(now.Format(timestampLayout)),
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range expired.Records {
+		_ = DatabaseDelete(m.collection, rec.ID)
+	}
+
+	raw, err := CryptoRandomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	owner := hex.EncodeToString(raw)
+	rec, err := DatabaseCreate(m.collection, map[string]any{
+		"kind":        "lock",
+		"owner":       owner,
+		"acquired_at": now.Format(timestampLayout),
+		"expires_at":  now.Add(m.lockTTL).Format(timestampLayout),
+	})
+	if err != nil {
+		return nil, err
+	}
+	release := func() { _ = DatabaseDelete(m.collection, rec.ID) }
+
+	held, err := DatabaseList(m.collection, ListOptions{
+		Filters: []Filter{lockFilter},
+		Sort:    []SortField{{Field: "acquired_at"}, {Field: "owner"}},
+		Limit:   1,
+	})
+	if err != nil {
+		release()
+		return nil, err
+	}
+	if len(held.Records) == 0 || held.Records[0].ID != rec.ID {
+		release()
+		return nil, &wafer.WaferError{
+			Code:    "aborted",
+			Message: "migrations in " + m.collection + " are locked by another instance",
+		}
+	}
+	return release, nil
+}
+
+func runStep(fn func() error, sql string) error {
+	if fn != nil {
+		return fn()
+	}
+	_, err := DatabaseExecRaw(sql)
+	return err
+}
+
+func migrationError(step Migration, dir string, err error) error {
+	return &wafer.WaferError{
+		Code:    "internal",
+		Message: "migration " + strconv.FormatInt(step.Version, 10) + " (" + step.Name + ") " + dir + " failed: " + err.Error(),
+	}
+}
+
+// jsonValue JSON-encodes a filter value.
+func jsonValue(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "null"
+	}
+	return string(b)
+}
@@ -0,0 +1,63 @@
+package services_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/wafer-run/wafer-sdk-go/services"
+)
+
+func TestMigratorLock(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name    string
+		lock    map[string]any // existing lock record, if any
+		wantErr bool
+	}{
+		{name: "no lock"},
+		{
+			name: "expired lock",
+			lock: map[string]any{
+				"kind":        "lock",
+				"owner":       "other",
+				"acquired_at": now.Add(-time.Hour).Format("2006-01-02T15:04:05.000000000Z07:00"),
+				"expires_at":  now.Add(-time.Second).Format("2006-01-02T15:04:05.000000000Z07:00"),
+			},
+		},
+		{
+			name: "live lock",
+			lock: map[string]any{
+				"kind":        "lock",
+				"owner":       "other",
+				"acquired_at": now.Add(-time.Second).Format("2006-01-02T15:04:05.000000000Z07:00"),
+				"expires_at":  now.Add(time.Minute).Format("2006-01-02T15:04:05.000000000Z07:00"),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := setup(t)
+			if tt.lock != nil {
+				if _, err := DatabaseCreate(DefaultMigrationsCollection, tt.lock); err != nil {
+					t.Fatal(err)
+				}
+			}
+			ran := false
+			m := NewMigrator("").AddFunc(1, "init", func() error { ran = true; return nil }, nil)
+			err := m.Up()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Up() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if ran == tt.wantErr {
+				t.Errorf("migration ran = %v", ran)
+			}
+			for _, rec := range db.Records(DefaultMigrationsCollection) {
+				if !tt.wantErr && strings.Contains(rec.Data, `"kind":"lock"`) {
+					t.Errorf("lock record %s left behind", rec.ID)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,459 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+	"github.com/wafer-run/wafer-sdk-go/gen/wafer/database"
+)
+
+// PatchFormat is a convenience alias for database.PatchFormat.
+type PatchFormat = database.PatchFormat
+
+// Re-export patch format constants for convenience.
+const (
+	MergePatch = database.PatchFormatMergePatch
+	JSONPatch  = database.PatchFormatJSONPatch
+)
+
+// Content types that select the patch format in DatabasePatchMessage.
+const (
+	ContentTypeMergePatch = "application/merge-patch+json"
+	ContentTypeJSONPatch  = "application/json-patch+json"
+)
+
+// DatabasePatch applies a partial update to a record and returns the updated
+// record. The patch is an RFC 7396 merge patch or an RFC 6902 JSON Patch
+// document depending on format. When the host cannot apply patches itself the
+// record is read, patched in the guest and written back, which is not atomic.
+// Collections with a registered schema always take that path, so the
+// patched document is validated before anything is written.
+func DatabasePatch(collection, id string, format PatchFormat, patch []byte) (Record, error) {
+	if database.Patch != nil && !hasSchema(collection) {
+		rec, err := database.Patch(collection, id, format, string(patch))
+		if !isUnsupported(err) {
+			return rec, err
+		}
+	}
+	patched, err := patchRecord(collection, id, format, patch)
+	if err != nil {
+		return Record{}, err
+	}
+	return database.Update(collection, id, string(patched))
+}
+
+// patchRecord applies a patch to the stored record guest-side and validates
+// the result.
+func patchRecord(collection, id string, format PatchFormat, patch []byte) ([]byte, error) {
+	current, err := database.Get(collection, id)
+	if err != nil {
+		return nil, err
+	}
+	patched, err := ApplyPatch(format, []byte(current.Data), patch)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateRecord(collection, string(patched)); err != nil {
+		return nil, err
+	}
+	return patched, nil
+}
+
+// DatabaseMergePatch applies an RFC 7396 merge patch to a record.
+func DatabaseMergePatch(collection, id string, patch []byte) (Record, error) {
+	return DatabasePatch(collection, id, MergePatch, patch)
+}
+
+// DatabaseJSONPatch applies an RFC 6902 JSON Patch document to a record.
+func DatabaseJSONPatch(collection, id string, patch []byte) (Record, error) {
+	return DatabasePatch(collection, id, JSONPatch, patch)
+}
+
+// DatabasePatchMessage applies the patch carried in msg.Data to a record. The
+// format is taken from the request content type, falling back to JSON Patch
+// for arrays and merge patch for everything else.
+func DatabasePatchMessage(collection, id string, msg *wafer.Message) (Record, error) {
+	return DatabasePatch(collection, id, PatchFormatOf(msg), msg.Data)
+}
+
+// PatchFormatOf reports the patch format of a message's payload.
+func PatchFormatOf(msg *wafer.Message) PatchFormat {
+	ct, _, _ := strings.Cut(msg.ContentType(), ";")
+	switch strings.ToLower(strings.TrimSpace(ct)) {
+	case ContentTypeJSONPatch:
+		return JSONPatch
+	case ContentTypeMergePatch:
+		return MergePatch
+	}
+	if trimmed := bytes.TrimSpace(msg.Data); len(trimmed) > 0 && trimmed[0] == '[' {
+		return JSONPatch
+	}
+	return MergePatch
+}
+
+// ApplyPatch applies a patch of the given format to a JSON document.
+func ApplyPatch(format PatchFormat, doc, patch []byte) ([]byte, error) {
+	switch format {
+	case MergePatch:
+		return ApplyMergePatch(doc, patch)
+	case JSONPatch:
+		return ApplyJSONPatch(doc, patch)
+	default:
+		return nil, patchError("unknown patch format")
+	}
+}
+
+// ApplyMergePatch applies an RFC 7396 merge patch to a JSON document.
+func ApplyMergePatch(doc, patch []byte) ([]byte, error) {
+	var target any
+	if len(bytes.TrimSpace(doc)) > 0 {
+		var err error
+		if target, err = decodeJSON(doc); err != nil {
+			return nil, err
+		}
+	}
+	p, err := decodeJSON(patch)
+	if err != nil {
+		return nil, err
+	}
+	return encodeJSON(mergePatch(target, p))
+}
+
+func mergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = make(map[string]any)
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergePatch(t[k], v)
+		}
+	}
+	return t
+}
+
+// jsonPatchOp is one operation of an RFC 6902 JSON Patch document.
+type jsonPatchOp struct {
+	Op    string           `json:"op"`
+	Path  *string          `json:"path"`
+	From  *string          `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// ApplyJSONPatch applies an RFC 6902 JSON Patch document to a JSON document.
+// Operations are applied in order and the whole patch fails if any of them
+// fails; a failed "test" operation returns a "failed_precondition" error.
+func ApplyJSONPatch(doc, patch []byte) ([]byte, error) {
+	var ops []jsonPatchOp
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, patchError("invalid JSON patch: " + err.Error())
+	}
+	var target any
+	if len(bytes.TrimSpace(doc)) > 0 {
+		var err error
+		if target, err = decodeJSON(doc); err != nil {
+			return nil, err
+		}
+	}
+	for i, op := range ops {
+		var err error
+		if target, err = applyOp(target, op); err != nil {
+			if we, ok := err.(*wafer.WaferError); ok {
+				we.Message = "patch operation " + strconv.Itoa(i) + " (" + op.Op + "): " + we.Message
+			}
+			return nil, err
+		}
+	}
+	return encodeJSON(target)
+}
+
+func applyOp(doc any, op jsonPatchOp) (any, error) {
+	if op.Path == nil {
+		return nil, patchError("missing path")
+	}
+	path, err := parsePointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+	value := func() (any, error) {
+		if op.Value == nil {
+			return nil, patchError("missing value")
+		}
+		return decodeJSON(*op.Value)
+	}
+	from := func() ([]string, error) {
+		if op.From == nil {
+			return nil, patchError("missing from")
+		}
+		return parsePointer(*op.From)
+	}
+
+	switch op.Op {
+	case "add":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, v)
+	case "remove":
+		return pointerRemove(doc, path)
+	case "replace":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		if doc, err = pointerRemove(doc, path); err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, v)
+	case "move":
+		src, err := from()
+		if err != nil {
+			return nil, err
+		}
+		if len(path) > len(src) && reflect.DeepEqual(src, path[:len(src)]) {
+			return nil, patchError("cannot move a value into one of its children")
+		}
+		v, err := pointerGet(doc, src)
+		if err != nil {
+			return nil, err
+		}
+		if doc, err = pointerRemove(doc, src); err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, v)
+	case "copy":
+		src, err := from()
+		if err != nil {
+			return nil, err
+		}
+		v, err := pointerGet(doc, src)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return nil, patchError(err.Error())
+		}
+		if v, err = decodeJSON(raw); err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, v)
+	case "test":
+		want, err := value()
+		if err != nil {
+			return nil, err
+		}
+		got, err := pointerGet(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(got, want) {
+			return nil, &wafer.WaferError{
+				Code:    "failed_precondition",
+				Message: "value at " + *op.Path + " does not match",
+			}
+		}
+		return doc, nil
+	default:
+		return nil, patchError("unknown operation")
+	}
+}
+
+// parsePointer splits an RFC 6901 JSON pointer into unescaped tokens.
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if p[0] != '/' {
+		return nil, patchError("invalid JSON pointer " + strconv.Quote(p))
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func pointerGet(doc any, path []string) (any, error) {
+	for _, tok := range path {
+		switch n := doc.(type) {
+		case map[string]any:
+			v, ok := n[tok]
+			if !ok {
+				return nil, pathError(path)
+			}
+			doc = v
+		case []any:
+			i, err := arrayIndex(tok, len(n)-1)
+			if err != nil {
+				return nil, pathError(path)
+			}
+			doc = n[i]
+		default:
+			return nil, pathError(path)
+		}
+	}
+	return doc, nil
+}
+
+func pointerAdd(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return mutateAt(doc, path, func(container any, key string) (any, error) {
+		switch n := container.(type) {
+		case map[string]any:
+			n[key] = value
+			return n, nil
+		case []any:
+			if key == "-" {
+				return append(n, value), nil
+			}
+			i, err := arrayIndex(key, len(n))
+			if err != nil {
+				return nil, pathError(path)
+			}
+			n = append(n, nil)
+			copy(n[i+1:], n[i:])
+			n[i] = value
+			return n, nil
+		default:
+			return nil, pathError(path)
+		}
+	})
+}
+
+func pointerRemove(doc any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+	return mutateAt(doc, path, func(container any, key string) (any, error) {
+		switch n := container.(type) {
+		case map[string]any:
+			if _, ok := n[key]; !ok {
+				return nil, pathError(path)
+			}
+			delete(n, key)
+			return n, nil
+		case []any:
+			i, err := arrayIndex(key, len(n)-1)
+			if err != nil {
+				return nil, pathError(path)
+			}
+			return append(n[:i], n[i+1:]...), nil
+		default:
+			return nil, pathError(path)
+		}
+	})
+}
+
+// mutateAt walks to the parent of the last path token, applies leaf to it and
+// stores the possibly reallocated containers back along the way.
+func mutateAt(node any, path []string, leaf func(container any, key string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return leaf(node, path[0])
+	}
+	switch n := node.(type) {
+	case map[string]any:
+		child, ok := n[path[0]]
+		if !ok {
+			return nil, pathError(path)
+		}
+		updated, err := mutateAt(child, path[1:], leaf)
+		if err != nil {
+			return nil, err
+		}
+		n[path[0]] = updated
+		return n, nil
+	case []any:
+		i, err := arrayIndex(path[0], len(n)-1)
+		if err != nil {
+			return nil, pathError(path)
+		}
+		updated, err := mutateAt(n[i], path[1:], leaf)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = updated
+		return n, nil
+	default:
+		return nil, pathError(path)
+	}
+}
+
+// arrayIndex parses an array index token and checks it is within [0, max].
+func arrayIndex(tok string, max int) (int, error) {
+	if tok == "" || (len(tok) > 1 && tok[0] == '0') {
+		return 0, patchError("invalid array index " + strconv.Quote(tok))
+	}
+	i, err := strconv.Atoi(tok)
+	if err != nil || i < 0 || i > max {
+		return 0, patchError("array index " + strconv.Quote(tok) + " out of range")
+	}
+	return i, nil
+}
+
+// jsonEqual compares two decoded JSON values, treating numbers by value.
+func jsonEqual(a, b any) bool {
+	ra, errA := json.Marshal(a)
+	rb, errB := json.Marshal(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	var va, vb any
+	_ = json.Unmarshal(ra, &va)
+	_ = json.Unmarshal(rb, &vb)
+	return reflect.DeepEqual(va, vb)
+}
+
+// decodeJSON decodes a JSON document keeping numbers as json.Number so that
+// large integers survive a round trip unchanged.
+func decodeJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, patchError("invalid JSON document: " + err.Error())
+	}
+	return v, nil
+}
+
+func encodeJSON(v any) ([]byte, error) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		return nil, &wafer.WaferError{
+			Code:    "internal",
+			Message: "failed to marshal record: " + err.Error(),
+		}
+	}
+	return out, nil
+}
+
+func pathError(path []string) error {
+	var b strings.Builder
+	for _, tok := range path {
+		b.WriteByte('/')
+		b.WriteString(escapePointer(tok))
+	}
+	return patchError("path " + strconv.Quote(b.String()) + " does not exist")
+}
+
+// escapePointer escapes a token for use in an RFC 6901 JSON pointer.
+func escapePointer(tok string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(tok, "~", "~0"), "/", "~1")
+}
+
+func patchError(message string) error {
+	return &wafer.WaferError{Code: "invalid_argument", Message: message}
+}
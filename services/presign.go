@@ -0,0 +1,209 @@
+package services
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+)
+
+// DefaultPresignBaseURL is the URL prefix of presigned links when the
+// "storage.presign_base_url" config key is not set. It must route to a
+// block that calls ServePresigned.
+const DefaultPresignBaseURL = "/storage/presigned"
+
+// PresignTokenParam is the query parameter that carries the signature of a
+// presigned URL.
+const PresignTokenParam = "token"
+
+// PresignTokenType is the "typ" claim of presign tokens. It keeps them
+// apart from other tokens signed with the same key: ServePresigned only
+// accepts tokens of this type, and TenantFromClaim rejects them.
+const PresignTokenType = "wafer-presign"
+
+// presignClaims are the signed contents of a presigned URL.
+type presignClaims struct {
+	Type    string `json:"typ"`
+	Folder  string `json:"folder"`
+	Key     string `json:"key"`
+	Method  string `json:"method"`
+	Expires int64  `json:"expires"`
+}
+
+// StoragePresign returns a URL that grants method ("GET", "PUT" or
+// "DELETE") on one object until ttl elapses, without further
+// authentication. The grant is a token signed with CryptoSign, so only the
+// host's signing key can mint it. The URL's path names the object for
+// readability; the signed claims are what ServePresigned acts on.
+func StoragePresign(folder, key, method string, ttl time.Duration) (string, error) {
+	method = strings.ToUpper(method)
+	switch method {
+	case "GET", "PUT", "DELETE":
+	default:
+		return "", &wafer.WaferError{
+			Code:    "invalid_argument",
+			Message: "presigned URLs support GET, PUT and DELETE, not " + method,
+		}
+	}
+	if ttl <= 0 {
+		return "", &wafer.WaferError{
+			Code:    "invalid_argument",
+			Message: "presigned URL ttl must be positive",
+		}
+	}
+	secs := int64((ttl + time.Second - 1) / time.Second)
+	claims, err := json.Marshal(presignClaims{
+		Type:    PresignTokenType,
+		Folder:  folder,
+		Key:     key,
+		Method:  method,
+		Expires: time.Now().Unix() + secs,
+	})
+	if err != nil {
+		return "", &wafer.WaferError{
+			Code:    "internal",
+			Message: "failed to marshal presign claims: " + err.Error(),
+		}
+	}
+	token, err := CryptoSign(string(claims), uint64(secs))
+	if err != nil {
+		return "", &wafer.WaferError{
+			Code:    "internal",
+			Message: "failed to sign presigned URL: " + err.Error(),
+		}
+	}
+	base := strings.TrimSuffix(ConfigGetDefault("storage.presign_base_url", DefaultPresignBaseURL), "/")
+	return base + "/" + escapePath(folder) + "/" + escapePath(key) +
+		"?" + PresignTokenParam + "=" + url.QueryEscape(token), nil
+}
+
+// VerifyPresigned checks the presigned token carried by msg and returns the
+// folder and key it grants access to. The token must be a validly signed,
+// unexpired presign token issued for the request's method, where a GET grant
+// also covers HEAD; otherwise a "permission_denied" WaferError is returned.
+func VerifyPresigned(msg *wafer.Message) (folder, key string, err error) {
+	c, err := verifyPresigned(msg)
+	if err != nil {
+		return "", "", err
+	}
+	return c.Folder, c.Key, nil
+}
+
+// ServePresigned serves a request made with a URL from StoragePresign: GET
+// returns the object through ServeObject and HEAD its headers, PUT stores
+// the request body as the object using the request content type, and DELETE
+// removes it. Blocks mounted at the presign base URL can return its result
+// directly from Handle.
+func ServePresigned(msg *wafer.Message) *wafer.BlockResult {
+	c, err := verifyPresigned(msg)
+	if err != nil {
+		return presignErrorResult(err)
+	}
+	switch c.Method {
+	case "GET":
+		r := ServeObject(msg, c.Folder, c.Key)
+		if requestMethod(msg) == "HEAD" && r.Response != nil {
+			r.Response.Data = nil
+		}
+		return r
+	case "PUT":
+		if err := StoragePut(c.Folder, c.Key, msg.Data, msg.ContentType()); err != nil {
+			return storageErrorResult(err)
+		}
+		return wafer.JsonRespondStatus(201, map[string]any{"key": c.Key, "size": len(msg.Data)})
+	default:
+		if err := StorageDelete(c.Folder, c.Key); err != nil {
+			return storageErrorResult(err)
+		}
+		return wafer.RespondWithStatus(204, nil, "")
+	}
+}
+
+// PresignedStorageBlock is a block that serves presigned storage URLs. Mount
+// it on the route configured as the presign base URL.
+type PresignedStorageBlock struct{}
+
+// Info implements wafer.Block.
+func (PresignedStorageBlock) Info() wafer.BlockInfo {
+	return wafer.BlockInfo{
+		Name:      "@wafer/presigned-storage",
+		Version:   "1.0.0",
+		Interface: "http-handler@v1",
+		Summary:   "Serves and accepts storage objects through presigned URLs.",
+	}
+}
+
+// Handle implements wafer.Block.
+func (PresignedStorageBlock) Handle(msg *wafer.Message) *wafer.BlockResult {
+	return ServePresigned(msg)
+}
+
+// Lifecycle implements wafer.Block.
+func (PresignedStorageBlock) Lifecycle(wafer.LifecycleEvent) error {
+	return nil
+}
+
+func verifyPresigned(msg *wafer.Message) (presignClaims, error) {
+	var c presignClaims
+	token := msg.Query(PresignTokenParam)
+	if token == "" {
+		return c, presignDenied("missing presign token")
+	}
+	claims, err := CryptoVerify(token)
+	if err != nil {
+		return c, presignDenied("invalid presign token")
+	}
+	if err := json.Unmarshal([]byte(claims), &c); err != nil || c.Type != PresignTokenType || c.Method == "" {
+		return c, presignDenied("invalid presign token")
+	}
+	if time.Now().Unix() >= c.Expires {
+		return c, presignDenied("presigned URL has expired")
+	}
+	if m := requestMethod(msg); m != c.Method && (m != "HEAD" || c.Method != "GET") {
+		return c, presignDenied("presigned URL does not allow this method")
+	}
+	return c, nil
+}
+
+// requestMethod derives the HTTP method from the request action. Hosts
+// report HEAD requests as "head" or fold them into "retrieve", so callers
+// that serve HEAD accept GET as well.
+func requestMethod(msg *wafer.Message) string {
+	switch msg.Action() {
+	case "retrieve":
+		return "GET"
+	case "head":
+		return "HEAD"
+	case "create", "update":
+		return "PUT"
+	case "delete":
+		return "DELETE"
+	}
+	return ""
+}
+
+// escapePath escapes each segment of a slash-separated path.
+func escapePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+func presignDenied(message string) error {
+	return &wafer.WaferError{
+		Code:    "permission_denied",
+		Message: message,
+	}
+}
+
+func presignErrorResult(err error) *wafer.BlockResult {
+	we, ok := err.(*wafer.WaferError)
+	if !ok {
+		return wafer.ErrInternal(err.Error())
+	}
+	return wafer.ErrorStatus(403, we.Code, we.Message)
+}
@@ -0,0 +1,209 @@
+package services_test
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+	"time"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+	. "github.com/wafer-run/wafer-sdk-go/services"
+)
+
+// status returns the HTTP status of a block result.
+func status(r *wafer.BlockResult) string {
+	if r.Response != nil {
+		return r.Response.Meta["resp.status"]
+	}
+	if r.Error != nil {
+		return r.Error.Meta["resp.status"]
+	}
+	return ""
+}
+
+// presignedRequest turns a presigned URL into the message a block would see.
+func presignedRequest(t *testing.T, rawURL, action string) *wafer.Message {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &wafer.Message{}
+	msg.SetMeta("req.action", action)
+	msg.SetMeta("req.resource", u.Path)
+	msg.SetMeta("req.query."+PresignTokenParam, u.Query().Get(PresignTokenParam))
+	return msg
+}
+
+func signClaims(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	b, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := CryptoSign(string(b), 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return DefaultPresignBaseURL + "/docs/a.txt?" + PresignTokenParam + "=" + url.QueryEscape(token)
+}
+
+func TestServePresigned(t *testing.T) {
+	future := time.Now().Add(time.Minute).Unix()
+	tests := []struct {
+		name     string
+		url      func(t *testing.T) string
+		action   string
+		body     string
+		want     string
+		wantBody string
+	}{
+		{
+			name:     "get",
+			url:      presignURL("GET"),
+			action:   "retrieve",
+			want:     "200",
+			wantBody: "hello",
+		},
+		{
+			name:   "head with a get grant",
+			url:    presignURL("GET"),
+			action: "head",
+			want:   "200",
+		},
+		{
+			name:   "put",
+			url:    presignURL("PUT"),
+			action: "update",
+			body:   "replaced",
+			want:   "201",
+		},
+		{
+			name:   "delete",
+			url:    presignURL("DELETE"),
+			action: "delete",
+			want:   "204",
+		},
+		{
+			name:   "put with a get grant",
+			url:    presignURL("GET"),
+			action: "update",
+			body:   "replaced",
+			want:   "403",
+		},
+		{
+			name:   "head with a put grant",
+			url:    presignURL("PUT"),
+			action: "head",
+			want:   "403",
+		},
+		{
+			name:   "get with a put grant",
+			url:    presignURL("PUT"),
+			action: "retrieve",
+			want:   "403",
+		},
+		{
+			name:   "delete with a get grant",
+			url:    presignURL("GET"),
+			action: "delete",
+			want:   "403",
+		},
+		{
+			name:   "missing token",
+			url:    func(*testing.T) string { return DefaultPresignBaseURL + "/docs/a.txt" },
+			action: "retrieve",
+			want:   "403",
+		},
+		{
+			name: "forged token",
+			url: func(t *testing.T) string {
+				return DefaultPresignBaseURL + "/docs/a.txt?token=e30.9999999999.00"
+			},
+			action: "retrieve",
+			want:   "403",
+		},
+		{
+			name: "expired claims",
+			url: func(t *testing.T) string {
+				return signClaims(t, map[string]any{
+					"typ": PresignTokenType, "folder": "docs", "key": "a.txt", "method": "GET",
+					"expires": time.Now().Add(-time.Second).Unix(),
+				})
+			},
+			action: "retrieve",
+			want:   "403",
+		},
+		{
+			name: "token without presign type",
+			url: func(t *testing.T) string {
+				return signClaims(t, map[string]any{
+					"sub": "user-1", "folder": "docs", "key": "a.txt", "method": "GET", "expires": future,
+				})
+			},
+			action: "retrieve",
+			want:   "403",
+		},
+		{
+			name: "token of another type",
+			url: func(t *testing.T) string {
+				return signClaims(t, map[string]any{
+					"typ": "access", "folder": "docs", "key": "a.txt", "method": "GET", "expires": future,
+				})
+			},
+			action: "retrieve",
+			want:   "403",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, st := setup(t)
+			if err := StoragePut("docs", "a.txt", []byte("hello"), "text/plain"); err != nil {
+				t.Fatal(err)
+			}
+			msg := presignedRequest(t, tt.url(t), tt.action)
+			msg.Data = []byte(tt.body)
+			r := ServePresigned(msg)
+			if got := status(r); got != tt.want {
+				t.Fatalf("status = %s, want %s (%+v)", got, tt.want, r.Error)
+			}
+			if tt.want == "200" && string(r.Response.Data) != tt.wantBody {
+				t.Errorf("body = %q, want %q", r.Response.Data, tt.wantBody)
+			}
+			data, ok := st.Object("docs", "a.txt")
+			switch {
+			case tt.want == "201" && string(data) != tt.body:
+				t.Errorf("stored %q, want %q", data, tt.body)
+			case tt.want == "204" && ok:
+				t.Error("object not deleted")
+			case tt.want == "403" && string(data) != "hello":
+				t.Errorf("object changed to %q", data)
+			}
+		})
+	}
+}
+
+func presignURL(method string) func(t *testing.T) string {
+	return func(t *testing.T) string {
+		u, err := StoragePresign("docs", "a.txt", method, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+}
+
+func TestPresignTokenIsNotABearerToken(t *testing.T) {
+	setup(t)
+	u, err := StoragePresign("docs", "a.txt", "GET", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _ := url.Parse(u)
+	msg := &wafer.Message{}
+	msg.SetMeta("http.header.authorization", "Bearer "+parsed.Query().Get(PresignTokenParam))
+	tenant, err := TenantFromClaim("folder")(msg)
+	if !isCode(err, "unauthenticated") {
+		t.Fatalf("TenantFromClaim() = %q, %v; want unauthenticated", tenant, err)
+	}
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+)
+
+// ProjectRecord returns rec with its data reduced to the given fields. Dotted
+// field names keep nested values under their parent objects; fields missing
+// from the document are omitted. An empty field list returns rec unchanged.
+func ProjectRecord(rec Record, fields []string) (Record, error) {
+	if len(fields) == 0 || rec.Data == "" {
+		return rec, nil
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(rec.Data), &doc); err != nil {
+		return Record{}, &wafer.WaferError{
+			Code:    "internal",
+			Message: "failed to decode record: " + err.Error(),
+		}
+	}
+	out := make(map[string]any)
+	for _, f := range fields {
+		if v, ok := doc[f]; ok {
+			out[f] = v
+			continue
+		}
+		projectPath(doc, out, strings.Split(f, "."))
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		return Record{}, &wafer.WaferError{
+			Code:    "internal",
+			Message: "failed to marshal record: " + err.Error(),
+		}
+	}
+	return Record{ID: rec.ID, Data: string(data)}, nil
+}
+
+// projectPath copies the value at path from src into dst, creating the
+// intermediate objects in dst.
+func projectPath(src map[string]json.RawMessage, dst map[string]any, path []string) {
+	raw, ok := src[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		dst[path[0]] = raw
+		return
+	}
+	var child map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &child); err != nil || child == nil {
+		return
+	}
+	next, ok := dst[path[0]].(map[string]any)
+	if !ok {
+		if _, taken := dst[path[0]]; taken {
+			return
+		}
+		next = make(map[string]any)
+	}
+	projectPath(child, next, path[1:])
+	if len(next) > 0 {
+		dst[path[0]] = next
+	}
+}
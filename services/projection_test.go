@@ -0,0 +1,74 @@
+package services_test
+
+import (
+	"testing"
+
+	"github.com/wafer-run/wafer-sdk-go/gen/wafer/database"
+	. "github.com/wafer-run/wafer-sdk-go/services"
+)
+
+func TestDatabaseListSelect(t *testing.T) {
+	tests := []struct {
+		name     string
+		noSelect bool // host rejects Select
+		noWhere  bool // host rejects Where
+		opts     ListOptions
+		want     []string
+	}{
+		{
+			name: "host projects",
+			opts: ListOptions{Select: []string{"name"}, Sort: []SortField{{Field: "name"}}},
+			want: []string{`{"name":"ada"}`, `{"name":"bob"}`},
+		},
+		{
+			name:     "guest projects",
+			noSelect: true,
+			opts:     ListOptions{Select: []string{"name"}, Sort: []SortField{{Field: "name"}}},
+			want:     []string{`{"name":"ada"}`, `{"name":"bob"}`},
+		},
+		{
+			name:     "guest projects and filters",
+			noSelect: true,
+			noWhere:  true,
+			opts: ListOptions{
+				Select: []string{"name"},
+				Where:  Or(Where("age", OpLess, 40), Where("name", OpEqual, "nobody")).Tree(),
+			},
+			want: []string{`{"name":"ada"}`},
+		},
+		{
+			name:    "no select leaves records whole",
+			noWhere: true,
+			opts:    ListOptions{Where: Not(Where("age", OpLess, 40)).Tree()},
+			want:    []string{`{"age":41,"name":"bob"}`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := setup(t)
+			database.List = func(collection string, options database.ListOptions) (database.RecordList, error) {
+				if (tt.noSelect && len(options.Select) > 0) || (tt.noWhere && options.Where != nil) {
+					return database.RecordList{}, database.DatabaseErrorUnsupported
+				}
+				return db.List(collection, options)
+			}
+			for _, p := range []map[string]any{{"name": "ada", "age": 36}, {"name": "bob", "age": 41}} {
+				if _, err := DatabaseCreate("people", p); err != nil {
+					t.Fatal(err)
+				}
+			}
+			rl, err := DatabaseList("people", tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rl.Records) != len(tt.want) {
+				t.Fatalf("got %d records, want %d", len(rl.Records), len(tt.want))
+			}
+			for i, rec := range rl.Records {
+				if rec.Data != tt.want[i] {
+					t.Errorf("record %d = %s, want %s", i, rec.Data, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"github.com/wafer-run/wafer-sdk-go/gen/wafer/database"
+)
+
+// Query is a fluent builder for ListOptions. Conditions added with Where and
+// Match are combined with AND; use Or and Not to build alternatives.
+//
+//	rl, err := services.NewQuery().
+//	    Match(services.Or(
+//	        services.Where("status", services.OpEqual, "active"),
+//	        services.Where("owner", services.OpEqual, msg.UserID()),
+//	    )).
+//	    OrderByDesc("created_at").
+//	    Limit(20).
+//	    List("tasks")
+type Query struct {
+	conds  []Cond
+	sort   []SortField
+	limit  int64
+	offset int64
+	fields []string
+}
+
+// NewQuery creates an empty Query that matches every record.
+func NewQuery() *Query {
+	return &Query{}
+}
+
+// Where adds a single field condition. The value is JSON-encoded.
+func (q *Query) Where(field string, op FilterOp, value any) *Query {
+	return q.Match(Where(field, op, value))
+}
+
+// Match adds a boolean condition.
+func (q *Query) Match(c Cond) *Query {
+	if !c.IsZero() {
+		q.conds = append(q.conds, c)
+	}
+	return q
+}
+
+// OrderBy adds an ascending sort on field.
+func (q *Query) OrderBy(field string) *Query {
+	q.sort = append(q.sort, SortField{Field: field})
+	return q
+}
+
+// OrderByDesc adds a descending sort on field.
+func (q *Query) OrderByDesc(field string) *Query {
+	q.sort = append(q.sort, SortField{Field: field, Desc: true})
+	return q
+}
+
+// Limit sets the maximum number of records returned.
+func (q *Query) Limit(n int64) *Query {
+	q.limit = n
+	return q
+}
+
+// Offset sets the number of records to skip.
+func (q *Query) Offset(n int64) *Query {
+	q.offset = n
+	return q
+}
+
+// Select restricts the returned records to the given fields.
+func (q *Query) Select(fields ...string) *Query {
+	q.fields = append(q.fields, fields...)
+	return q
+}
+
+// Cond returns the AND of every condition added to the query.
+func (q *Query) Cond() Cond {
+	switch len(q.conds) {
+	case 0:
+		return Cond{}
+	case 1:
+		return q.conds[0]
+	default:
+		return And(q.conds...)
+	}
+}
+
+// Options builds the ListOptions for the query. Plain field conditions are
+// sent as Filters so that hosts without filter tree support still apply them;
+// everything else is sent as the Where tree.
+func (q *Query) Options() ListOptions {
+	opts := ListOptions{Sort: q.sort, Limit: q.limit, Offset: q.offset, Select: q.fields}
+	var rest []Cond
+	for _, c := range q.conds {
+		if c.filter != nil {
+			opts.Filters = append(opts.Filters, *c.filter)
+		} else {
+			rest = append(rest, c)
+		}
+	}
+	switch len(rest) {
+	case 0:
+	case 1:
+		opts.Where = rest[0].Tree()
+	default:
+		opts.Where = And(rest...).Tree()
+	}
+	return opts
+}
+
+// Matches evaluates the query's conditions against a record guest-side.
+func (q *Query) Matches(rec Record) (bool, error) {
+	opts := q.Options()
+	return MatchRecord(rec, opts.Filters, opts.Where)
+}
+
+// List runs the query against collection.
+func (q *Query) List(collection string) (RecordList, error) {
+	return DatabaseList(collection, q.Options())
+}
+
+// Count counts the records in collection matching the query.
+func (q *Query) Count(collection string) (int64, error) {
+	opts := q.Options()
+	if opts.Where == nil {
+		return DatabaseCount(collection, opts.Filters)
+	}
+	return DatabaseCountWhere(collection, opts.Filters, *opts.Where)
+}
+
+// DatabaseCountWhere returns the number of records matching filters and the
+// filter tree. When the host does not support filter trees, the records
+// matching filters are listed and the tree is evaluated guest-side.
+func DatabaseCountWhere(collection string, filters []Filter, where FilterTree) (int64, error) {
+	if database.CountWhere != nil {
+		n, err := database.CountWhere(collection, filters, where)
+		if !isUnsupported(err) {
+			return n, err
+		}
+	}
+	matched, err := listMatching(collection, filters)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, rec := range matched {
+		ok, err := MatchRecord(rec, nil, &where)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// listWhere lists the records matching opts.Filters page by page, in sort
+// order with ties broken by ID, keeps those matching opts.Where and then
+// applies opts.Offset and opts.Limit.
+func listWhere(collection string, opts ListOptions) (RecordList, error) {
+	sort := append(append([]SortField(nil), opts.Sort...), SortField{Field: "id"})
+	var matched []Record
+	for offset := int64(0); ; offset += BatchChunkSize {
+		rl, err := database.List(collection, ListOptions{
+			Filters: opts.Filters,
+			Sort:    sort,
+			Limit:   BatchChunkSize,
+			Offset:  offset,
+		})
+		if err != nil {
+			return RecordList{}, err
+		}
+		for _, rec := range rl.Records {
+			ok, err := MatchRecord(rec, nil, opts.Where)
+			if err != nil {
+				return RecordList{}, err
+			}
+			if ok {
+				matched = append(matched, rec)
+			}
+		}
+		if len(rl.Records) < BatchChunkSize {
+			break
+		}
+	}
+
+	out := RecordList{TotalCount: int64(len(matched)), Page: 1, PageSize: opts.Limit}
+	if opts.Offset > 0 {
+		matched = matched[min(opts.Offset, int64(len(matched))):]
+	}
+	if opts.Limit > 0 {
+		matched = matched[:min(opts.Limit, int64(len(matched)))]
+		out.Page = opts.Offset/opts.Limit + 1
+	}
+	out.Records = matched
+	return out, nil
+}
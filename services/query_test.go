@@ -0,0 +1,90 @@
+package services_test
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/wafer-run/wafer-sdk-go/gen/wafer/database"
+	. "github.com/wafer-run/wafer-sdk-go/services"
+)
+
+func TestQueryListWithoutHostFilterTrees(t *testing.T) {
+	tests := []struct {
+		name  string
+		query *Query
+		want  []string // names, in order
+		total int64
+	}{
+		{
+			name:  "or",
+			query: NewQuery().Match(Or(Where("name", OpEqual, "ada"), Where("name", OpEqual, "bob"))).OrderBy("name"),
+			want:  []string{"ada", "bob"},
+			total: 2,
+		},
+		{
+			name:  "not with plain filter",
+			query: NewQuery().Where("active", OpEqual, true).Match(Not(Where("name", OpLike, "c%"))).OrderBy("name"),
+			want:  []string{"ada", "eve"},
+			total: 2,
+		},
+		{
+			name:  "like with offset and limit",
+			query: NewQuery().Match(Or(Where("name", OpLike, "%e%"), Where("age", OpGreater, 40))).OrderByDesc("age").Offset(1).Limit(1),
+			want:  []string{"bob"},
+			total: 2,
+		},
+		{
+			name:  "no match",
+			query: NewQuery().Match(Not(Where("age", OpGreaterEq, 0))),
+			total: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := setup(t)
+			database.List = func(collection string, options database.ListOptions) (database.RecordList, error) {
+				if options.Where != nil {
+					return database.RecordList{}, database.DatabaseErrorUnsupported
+				}
+				return db.List(collection, options)
+			}
+			people := []map[string]any{
+				{"name": "ada", "age": 36, "active": true},
+				{"name": "bob", "age": 41, "active": false},
+				{"name": "cyd", "age": 29, "active": true},
+				{"name": "eve", "age": 52, "active": true},
+			}
+			for _, p := range people {
+				if _, err := DatabaseCreate("people", p); err != nil {
+					t.Fatal(err)
+				}
+			}
+			rl, err := tt.query.List("people")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, rec := range rl.Records {
+				var p struct{ Name string }
+				if err := json.Unmarshal([]byte(rec.Data), &p); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, p.Name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("List() = %v, want %v", got, tt.want)
+			}
+			if rl.TotalCount != tt.total {
+				t.Errorf("TotalCount = %d, want %d", rl.TotalCount, tt.total)
+			}
+			n, err := tt.query.Count("people")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.total {
+				t.Errorf("Count() = %d, want %d", n, tt.total)
+			}
+		})
+	}
+}
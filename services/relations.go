@@ -0,0 +1,273 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+)
+
+// relation is a field of a repository model that holds related records,
+// declared with the struct tags described on Repository.Include.
+type relation struct {
+	name       string
+	hasMany    bool
+	collection string
+	foreignKey string
+	index      []int
+	jsonName   string
+	elem       reflect.Type
+	ptr        bool
+	crypt      *fieldCrypt
+}
+
+// relationsOf collects the relation fields of a model type. Related fields
+// tagged as encrypted are opened with keyring. A malformed declaration is
+// reported as an error the first time relations are loaded.
+func relationsOf(t reflect.Type, keyring *Keyring) (map[string]relation, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, nil
+	}
+	var rels map[string]relation
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, ok := sf.Tag.Lookup("relation")
+		if !ok {
+			continue
+		}
+		rel := relation{name: name, index: sf.Index, foreignKey: sf.Tag.Get("foreign_key")}
+		if jt := strings.Split(sf.Tag.Get("json"), ",")[0]; jt != "-" {
+			rel.jsonName = jt
+			if jt == "" {
+				rel.jsonName = sf.Name
+			}
+		}
+		if c, ok := sf.Tag.Lookup("has_many"); ok {
+			rel.hasMany, rel.collection = true, c
+		} else {
+			rel.collection = sf.Tag.Get("belongs_to")
+		}
+		if name == "" || rel.collection == "" || rel.foreignKey == "" {
+			return nil, relationError(sf.Name, "needs a relation name, a belongs_to or has_many collection and a foreign_key")
+		}
+
+		ft := sf.Type
+		if rel.hasMany {
+			if ft.Kind() != reflect.Slice {
+				return nil, relationError(sf.Name, "has_many fields must be slices")
+			}
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Pointer {
+			rel.ptr, ft = true, ft.Elem()
+		}
+		if ft.Kind() != reflect.Struct {
+			return nil, relationError(sf.Name, "related records must decode into a struct")
+		}
+		rel.elem = ft
+		rel.crypt = newFieldCrypt(rel.collection, ft, keyring)
+		if !sf.IsExported() {
+			return nil, relationError(sf.Name, "relation fields must be exported")
+		}
+		if rels == nil {
+			rels = make(map[string]relation)
+		}
+		rels[name] = rel
+	}
+	return rels, nil
+}
+
+// loadRelations loads the named relations of recs and stores them in the
+// decoded entities. Each relation costs one OpIn list query per
+// BatchChunkSize related records, regardless of the number of entities,
+// plus one get per belongs-to record the query misses. Related records must
+// also match the scope filters.
+func loadRelations[T any](rels map[string]relation, names []string, scope []Filter, recs []Record, out []Entity[T]) error {
+	if len(recs) == 0 {
+		return nil
+	}
+	docs := make([]map[string]any, len(recs))
+	for i, rec := range recs {
+		_ = json.Unmarshal([]byte(rec.Data), &docs[i])
+	}
+	for _, name := range names {
+		rel, ok := rels[name]
+		if !ok {
+			return &wafer.WaferError{
+				Code:    "invalid_argument",
+				Message: "unknown relation " + strconv.Quote(name),
+			}
+		}
+		if rel.hasMany {
+			if err := loadHasMany(rel, scope, recs, out); err != nil {
+				return err
+			}
+		} else if err := loadBelongsTo(rel, scope, docs, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadBelongsTo[T any](rel relation, scope []Filter, docs []map[string]any, out []Entity[T]) error {
+	keys := make([]string, len(docs))
+	var ids []string
+	seen := make(map[string]bool)
+	for i, doc := range docs {
+		v, _ := LookupField(doc, rel.foreignKey)
+		keys[i] = relationKey(v)
+		if keys[i] != "" && !seen[keys[i]] {
+			seen[keys[i]] = true
+			ids = append(ids, keys[i])
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	filters := append([]Filter{{Field: "id", Operator: OpIn, Value: jsonValue(ids)}}, scope...)
+	related, err := listMatching(rel.collection, filters)
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]Record, len(related))
+	for _, rec := range related {
+		byID[rec.ID] = rec
+	}
+	// "id" filters match a data field of that name when the record has one,
+	// so records the query missed are fetched by their record ID.
+	for _, id := range ids {
+		if _, ok := byID[id]; ok {
+			continue
+		}
+		rec, err := DatabaseGet(rel.collection, id)
+		if isNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if ok, err := MatchRecord(rec, scope, nil); err != nil {
+			return err
+		} else if ok {
+			byID[id] = rec
+		}
+	}
+	for i, key := range keys {
+		rec, ok := byID[key]
+		if !ok {
+			continue
+		}
+		v, err := rel.decode(rec)
+		if err != nil {
+			return err
+		}
+		relationField(&out[i].Data, rel).Set(v)
+	}
+	return nil
+}
+
+func loadHasMany[T any](rel relation, scope []Filter, recs []Record, out []Entity[T]) error {
+	var keys []any
+	for _, rec := range recs {
+		keys = append(keys, rec.ID)
+		// Foreign keys may hold numeric IDs as JSON numbers.
+		if f, err := strconv.ParseFloat(rec.ID, 64); err == nil && relationKey(f) == rec.ID {
+			keys = append(keys, f)
+		}
+	}
+	filters := append([]Filter{{Field: rel.foreignKey, Operator: OpIn, Value: jsonValue(keys)}}, scope...)
+	related, err := listMatching(rel.collection, filters)
+	if err != nil {
+		return err
+	}
+	byParent := make(map[string][]Record)
+	for _, rec := range related {
+		var doc map[string]any
+		_ = json.Unmarshal([]byte(rec.Data), &doc)
+		v, _ := LookupField(doc, rel.foreignKey)
+		key := relationKey(v)
+		byParent[key] = append(byParent[key], rec)
+	}
+	for i, rec := range recs {
+		field := relationField(&out[i].Data, rel)
+		children := byParent[rec.ID]
+		list := reflect.MakeSlice(field.Type(), 0, len(children))
+		for _, child := range children {
+			v, err := rel.decode(child)
+			if err != nil {
+				return err
+			}
+			list = reflect.Append(list, v)
+		}
+		field.Set(list)
+	}
+	return nil
+}
+
+// decode turns a related record into a value of the relation's element type,
+// decrypting the fields the element type tags as encrypted. The record ID is
+// made available to the struct as an "id" field.
+func (rel relation) decode(rec Record) (reflect.Value, error) {
+	if rel.crypt != nil {
+		var err error
+		if rec.Data, err = rel.crypt.open(rec.Data); err != nil {
+			return reflect.Value{}, err
+		}
+	}
+	v := reflect.New(rel.elem)
+	if err := decodeRecord(rec, v.Interface()); err != nil {
+		return reflect.Value{}, &wafer.WaferError{
+			Code:    "internal",
+			Message: "failed to decode " + rel.name + " record " + rec.ID + ": " + err.Error(),
+		}
+	}
+	if rel.ptr {
+		return v, nil
+	}
+	return v.Elem(), nil
+}
+
+// decodeRecord decodes a record's data into v, then sets its "id" field, if
+// it has a string one, to the record ID, which the data does not carry.
+func decodeRecord(rec Record, v any) error {
+	if err := json.Unmarshal([]byte(rec.Data), v); err != nil {
+		return err
+	}
+	_ = json.Unmarshal([]byte(jsonValue(map[string]string{"id": rec.ID})), v)
+	return nil
+}
+
+// relationField returns the settable relation field of a decoded model,
+// allocating the model if T is a nil pointer.
+func relationField[T any](data *T, rel relation) reflect.Value {
+	v := reflect.ValueOf(data).Elem()
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	return v.FieldByIndex(rel.index)
+}
+
+// relationKey renders a foreign key value the way record IDs are written.
+func relationKey(v any) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	}
+	return ""
+}
+
+func relationError(field, message string) error {
+	return &wafer.WaferError{
+		Code:    "invalid_argument",
+		Message: "relation field " + field + ": " + message,
+	}
+}
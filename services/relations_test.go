@@ -0,0 +1,196 @@
+package services_test
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	. "github.com/wafer-run/wafer-sdk-go/services"
+)
+
+type relAuthor struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	SSN  string `json:"ssn" encrypt:"true"`
+}
+
+type relComment struct {
+	ID     string `json:"id"`
+	PostID any    `json:"post_id"`
+	Body   string `json:"body"`
+	SSN    string `json:"ssn" encrypt:"true"`
+}
+
+type relPost struct {
+	ID       string       `json:"id"`
+	Title    string       `json:"title"`
+	AuthorID any          `json:"author_id"`
+	Author   *relAuthor   `json:"-" relation:"author" belongs_to:"authors" foreign_key:"author_id"`
+	Comments []relComment `json:"-" relation:"comments" has_many:"comments" foreign_key:"post_id"`
+}
+
+func TestRepositoryInclude(t *testing.T) {
+	opts := CollectionOptions{Keyring: testKeyring(), SoftDelete: true}
+	authors := func() *Repository[relAuthor] { return NewRepository[relAuthor]("authors", opts) }
+	comments := func() *Repository[relComment] { return NewRepository[relComment]("comments", opts) }
+	create := func(t *testing.T, collection string, data map[string]any) string {
+		t.Helper()
+		rec, err := DatabaseCreate(collection, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rec.ID
+	}
+
+	tests := []struct {
+		name string
+		// seed stores the post's author and comments, with postKey as
+		// their foreign key, and returns the author's record ID.
+		seed        func(t *testing.T, postKey any) string
+		numeric     bool // foreign keys are stored as JSON numbers
+		withDeleted bool
+		wantAuthor  *relAuthor // without ID
+		wantBodies  []string
+		wantSSN     string
+	}{
+		{
+			name: "repository records",
+			seed: func(t *testing.T, postKey any) string {
+				a, err := authors().Create(relAuthor{Name: "ada", SSN: "111"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, body := range []string{"first", "second"} {
+					if _, err := comments().Create(relComment{PostID: postKey, Body: body, SSN: "222"}); err != nil {
+						t.Fatal(err)
+					}
+				}
+				return a.ID
+			},
+			wantAuthor: &relAuthor{Name: "ada", SSN: "111"},
+			wantBodies: []string{"first", "second"},
+			wantSSN:    "222",
+		},
+		{
+			name: "stored id fields",
+			seed: func(t *testing.T, postKey any) string {
+				create(t, "comments", map[string]any{"id": "", "post_id": postKey, "body": "old"})
+				return create(t, "authors", map[string]any{"id": "", "name": "old"})
+			},
+			wantAuthor: &relAuthor{Name: "old"},
+			wantBodies: []string{"old"},
+		},
+		{
+			name: "numeric foreign keys",
+			seed: func(t *testing.T, postKey any) string {
+				create(t, "comments", map[string]any{"post_id": postKey, "body": "num"})
+				return create(t, "authors", map[string]any{"name": "num"})
+			},
+			numeric:    true,
+			wantAuthor: &relAuthor{Name: "num"},
+			wantBodies: []string{"num"},
+		},
+		{
+			name: "soft-deleted records",
+			seed: func(t *testing.T, postKey any) string {
+				a, err := authors().Create(relAuthor{Name: "gone"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := authors().Delete(a.ID); err != nil {
+					t.Fatal(err)
+				}
+				for _, body := range []string{"kept", "deleted"} {
+					c, err := comments().Create(relComment{PostID: postKey, Body: body})
+					if err != nil {
+						t.Fatal(err)
+					}
+					if body == "deleted" {
+						if err := comments().Delete(c.ID); err != nil {
+							t.Fatal(err)
+						}
+					}
+				}
+				return a.ID
+			},
+			wantBodies: []string{"kept"},
+		},
+		{
+			name: "soft-deleted records included",
+			seed: func(t *testing.T, postKey any) string {
+				c, err := comments().Create(relComment{PostID: postKey, Body: "deleted"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := comments().Delete(c.ID); err != nil {
+					t.Fatal(err)
+				}
+				return ""
+			},
+			withDeleted: true,
+			wantBodies:  []string{"deleted"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := setup(t)
+			posts := NewRepository[relPost]("posts", opts)
+			post, err := posts.Create(relPost{Title: "hello"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, rec := range db.Records("posts") {
+				var doc map[string]any
+				_ = json.Unmarshal([]byte(rec.Data), &doc)
+				if _, ok := doc["id"]; ok {
+					t.Errorf("record ID stored in data: %s", rec.Data)
+				}
+			}
+
+			var postKey any = post.ID
+			if tt.numeric {
+				n, _ := strconv.Atoi(post.ID)
+				postKey = n
+			}
+			authorID := tt.seed(t, postKey)
+			authorKey := jsonString(authorID)
+			if tt.numeric {
+				authorKey = authorID
+			}
+			if _, err := DatabaseMergePatch("posts", post.ID, []byte(`{"author_id":`+authorKey+`}`)); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.withDeleted {
+				posts = posts.WithDeleted()
+			}
+			got, err := posts.Include("author", "comments").Get(post.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Data.ID != post.ID {
+				t.Errorf("post ID = %q, want %q", got.Data.ID, post.ID)
+			}
+			if tt.wantAuthor == nil {
+				if got.Data.Author != nil {
+					t.Errorf("author = %+v, want none", got.Data.Author)
+				}
+			} else if want := (relAuthor{ID: authorID, Name: tt.wantAuthor.Name, SSN: tt.wantAuthor.SSN}); got.Data.Author == nil || *got.Data.Author != want {
+				t.Errorf("author = %+v, want %+v", got.Data.Author, want)
+			}
+			if len(got.Data.Comments) != len(tt.wantBodies) {
+				t.Fatalf("comments = %+v, want %v", got.Data.Comments, tt.wantBodies)
+			}
+			for i, c := range got.Data.Comments {
+				if c.Body != tt.wantBodies[i] || c.ID == "" || c.SSN != tt.wantSSN {
+					t.Errorf("comment %d = %+v, want %q with an ID and ssn %q", i, c, tt.wantBodies[i], tt.wantSSN)
+				}
+			}
+		})
+	}
+}
+
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
@@ -0,0 +1,532 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+)
+
+// Default field names used by CollectionOptions.
+const (
+	DefaultCreatedAtField = "created_at"
+	DefaultUpdatedAtField = "updated_at"
+	DefaultDeletedAtField = "deleted_at"
+)
+
+// CollectionOptions configures how a Repository stores its records.
+type CollectionOptions struct {
+	// Timestamps stamps CreatedAtField on create and UpdatedAtField on every
+	// write, as RFC 3339 UTC strings with a fixed nine-digit fraction, so
+	// that they sort in time order.
+	Timestamps bool
+	// SoftDelete turns Delete into setting DeletedAtField. Soft-deleted
+	// records are hidden from Get, List and Count unless WithDeleted is used.
+	SoftDelete bool
+
+	CreatedAtField string
+	UpdatedAtField string
+	DeletedAtField string
+
+	// Keyring encrypts model fields tagged `encrypt:"true"`. Fields tagged
+	// `encrypt:"true,index"` also store a blind index in a sibling field
+	// named with BlindIndexSuffix, so Get, List and Count can filter them
+	// for equality. Ciphertexts are bound to the collection and field, not
+	// to the record. Defaults to DefaultKeyring.
+	Keyring *Keyring
+}
+
+func (o CollectionOptions) withDefaults() CollectionOptions {
+	if o.CreatedAtField == "" {
+		o.CreatedAtField = DefaultCreatedAtField
+	}
+	if o.UpdatedAtField == "" {
+		o.UpdatedAtField = DefaultUpdatedAtField
+	}
+	if o.DeletedAtField == "" {
+		o.DeletedAtField = DefaultDeletedAtField
+	}
+	return o
+}
+
+// Entity is a record whose data has been decoded into a T.
+type Entity[T any] struct {
+	ID   string
+	Data T
+}
+
+// EntityList is a page of decoded records.
+type EntityList[T any] struct {
+	Entities   []Entity[T]
+	TotalCount int64
+	Page       int64
+	PageSize   int64
+}
+
+// Repository is a typed view of a collection. Values are JSON-encoded on
+// write and decoded into T on read, and the collection's options are applied
+// to every call. An "id" field of T is not stored: reads set it to the
+// record ID.
+type Repository[T any] struct {
+	collection  string
+	opts        CollectionOptions
+	withDeleted bool
+	crypt       *fieldCrypt
+	relations   map[string]relation
+	relErr      error
+	includes    []string
+	tenant      *TenantScope
+}
+
+// NewRepository creates a Repository for collection.
+func NewRepository[T any](collection string, opts CollectionOptions) *Repository[T] {
+	r := &Repository[T]{
+		collection: collection,
+		opts:       opts.withDefaults(),
+		crypt:      newFieldCrypt(collection, reflect.TypeFor[T](), opts.Keyring),
+	}
+	r.relations, r.relErr = relationsOf(reflect.TypeFor[T](), opts.Keyring)
+	return r
+}
+
+// Collection returns the name of the underlying collection.
+func (r *Repository[T]) Collection() string {
+	return r.collection
+}
+
+// WithDeleted returns a copy of the repository whose reads include
+// soft-deleted records.
+func (r *Repository[T]) WithDeleted() *Repository[T] {
+	c := *r
+	c.withDeleted = true
+	return &c
+}
+
+// ForTenant returns a copy of the repository confined to a tenant: records
+// are stamped with the tenant on write, reads and included relations are
+// filtered by it, and records of other tenants fail with
+// "permission_denied".
+func (r *Repository[T]) ForTenant(t *TenantScope) *Repository[T] {
+	c := *r
+	c.tenant = t
+	return &c
+}
+
+// Include returns a copy of the repository whose Get and List also load the
+// named relations into the returned models. Relations are declared on the
+// model with struct tags:
+//
+//	type Post struct {
+//		AuthorID string    `json:"author_id"`
+//		Author   *User     `json:"-" relation:"author" belongs_to:"users" foreign_key:"author_id"`
+//		Comments []Comment `json:"-" relation:"comments" has_many:"comments" foreign_key:"post_id"`
+//	}
+//
+// A belongs-to relation follows the foreign key stored on the record to the
+// record with that ID in the related collection; its field is a struct or a
+// pointer to one. A has-many relation loads the records of the related
+// collection whose foreign key holds this record's ID; its field is a slice.
+// Related records get their ID in an "id" field, their encrypted fields are
+// opened with the repository's Keyring, and those whose DeletedAtField is
+// set are left out unless the repository is WithDeleted. Each relation is
+// loaded with a single OpIn query for all results, and relation fields are
+// never written to the database.
+func (r *Repository[T]) Include(relations ...string) *Repository[T] {
+	c := *r
+	c.includes = append(append([]string(nil), r.includes...), relations...)
+	return &c
+}
+
+// Get retrieves a record by ID.
+func (r *Repository[T]) Get(id string) (Entity[T], error) {
+	rec, err := r.getRecord(id)
+	if err != nil {
+		return Entity[T]{}, err
+	}
+	e, err := r.decode(rec)
+	if err != nil {
+		return Entity[T]{}, err
+	}
+	out := []Entity[T]{e}
+	if err := r.include([]Record{rec}, out); err != nil {
+		return Entity[T]{}, err
+	}
+	return out[0], nil
+}
+
+// List retrieves the records matching opts.
+func (r *Repository[T]) List(opts ListOptions) (EntityList[T], error) {
+	var err error
+	if r.crypt != nil {
+		if opts.Filters, err = r.crypt.filters(opts.Filters); err != nil {
+			return EntityList[T]{}, err
+		}
+		if opts.Where, err = r.crypt.tree(opts.Where); err != nil {
+			return EntityList[T]{}, err
+		}
+	}
+	opts.Filters = r.scope(opts.Filters)
+	rl, err := DatabaseList(r.collection, opts)
+	if err != nil {
+		return EntityList[T]{}, err
+	}
+	out := EntityList[T]{
+		Entities:   make([]Entity[T], len(rl.Records)),
+		TotalCount: rl.TotalCount,
+		Page:       rl.Page,
+		PageSize:   rl.PageSize,
+	}
+	for i, rec := range rl.Records {
+		if out.Entities[i], err = r.decode(rec); err != nil {
+			return EntityList[T]{}, err
+		}
+	}
+	if err := r.include(rl.Records, out.Entities); err != nil {
+		return EntityList[T]{}, err
+	}
+	return out, nil
+}
+
+// Count returns the number of records matching filters.
+func (r *Repository[T]) Count(filters []Filter) (int64, error) {
+	if r.crypt != nil {
+		var err error
+		if filters, err = r.crypt.filters(filters); err != nil {
+			return 0, err
+		}
+	}
+	return DatabaseCount(r.collection, r.scope(filters))
+}
+
+// Create inserts v as a new record.
+func (r *Repository[T]) Create(v T) (Entity[T], error) {
+	doc, err := r.encode(v)
+	if err != nil {
+		return Entity[T]{}, err
+	}
+	if r.opts.Timestamps {
+		now := jsonValue(timestamp())
+		doc[r.opts.CreatedAtField] = json.RawMessage(now)
+		doc[r.opts.UpdatedAtField] = json.RawMessage(now)
+	}
+	if err := r.seal(doc); err != nil {
+		return Entity[T]{}, err
+	}
+	rec, err := DatabaseCreate(r.collection, doc)
+	if err != nil {
+		return Entity[T]{}, err
+	}
+	return r.decode(rec)
+}
+
+// Update replaces the record's data with v. The stored creation time is
+// always kept, and a deletion time that v does not carry is kept from the
+// stored record.
+func (r *Repository[T]) Update(id string, v T) (Entity[T], error) {
+	doc, err := r.encode(v)
+	if err != nil {
+		return Entity[T]{}, err
+	}
+	if r.opts.Timestamps || r.opts.SoftDelete || r.tenant != nil {
+		current, err := r.getRecord(id)
+		if err != nil {
+			return Entity[T]{}, err
+		}
+		var stored map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(current.Data), &stored); err != nil {
+			return Entity[T]{}, &wafer.WaferError{
+				Code:    "internal",
+				Message: "failed to decode record: " + err.Error(),
+			}
+		}
+		for _, f := range []string{r.opts.CreatedAtField, r.opts.DeletedAtField} {
+			if (r.opts.Timestamps && f == r.opts.CreatedAtField) || isUnsetTime(doc[f]) {
+				if v, ok := stored[f]; ok {
+					doc[f] = v
+				} else {
+					delete(doc, f)
+				}
+			}
+		}
+	}
+	if r.opts.Timestamps {
+		doc[r.opts.UpdatedAtField] = json.RawMessage(jsonValue(timestamp()))
+	}
+	if err := r.seal(doc); err != nil {
+		return Entity[T]{}, err
+	}
+	rec, err := DatabaseUpdate(r.collection, id, doc)
+	if err != nil {
+		return Entity[T]{}, err
+	}
+	return r.decode(rec)
+}
+
+// Patch applies a merge patch or JSON Patch to the record, stamping the
+// update time in the same write.
+func (r *Repository[T]) Patch(id string, format PatchFormat, patch []byte) (Entity[T], error) {
+	if r.tenant != nil {
+		if err := r.tenant.CheckPatch(format, patch); err != nil {
+			return Entity[T]{}, err
+		}
+	}
+	checkPlain := r.crypt != nil && hasSchema(r.collection)
+	var current Record
+	var err error
+	if r.hidesDeleted() || r.tenant != nil || checkPlain {
+		if current, err = r.getRecord(id); err != nil {
+			return Entity[T]{}, err
+		}
+	}
+	if r.opts.Timestamps {
+		if patch, err = stampPatch(format, patch, r.opts.UpdatedAtField, timestamp()); err != nil {
+			return Entity[T]{}, err
+		}
+	}
+	if r.crypt != nil {
+		sealed, err := r.crypt.patch(format, patch)
+		if err != nil {
+			return Entity[T]{}, err
+		}
+		if checkPlain {
+			if err := r.checkPatch(current, format, patch); err != nil {
+				return Entity[T]{}, err
+			}
+		}
+		patch = sealed
+	}
+	rec, err := DatabasePatch(r.collection, id, format, patch)
+	if err != nil {
+		return Entity[T]{}, err
+	}
+	return r.decode(rec)
+}
+
+// checkPatch validates the plaintext a patch would produce against the
+// collection's schema, which only sees ciphertexts once the patch is sealed.
+func (r *Repository[T]) checkPatch(current Record, format PatchFormat, patch []byte) error {
+	plain, err := r.crypt.open(current.Data)
+	if err != nil {
+		return err
+	}
+	patched, err := ApplyPatch(format, []byte(plain), patch)
+	if err != nil {
+		return err
+	}
+	return ValidateJSON(r.collection, patched)
+}
+
+// Delete removes the record, or marks it deleted when the collection uses
+// soft delete.
+func (r *Repository[T]) Delete(id string) error {
+	if r.opts.SoftDelete || r.tenant != nil {
+		if _, err := r.getRecord(id); err != nil {
+			return err
+		}
+	}
+	if !r.opts.SoftDelete {
+		return DatabaseDelete(r.collection, id)
+	}
+	now := timestamp()
+	fields := map[string]string{r.opts.DeletedAtField: now}
+	if r.opts.Timestamps {
+		fields[r.opts.UpdatedAtField] = now
+	}
+	_, err := DatabaseMergePatch(r.collection, id, []byte(jsonValue(fields)))
+	return err
+}
+
+// Restore clears the deletion mark of a soft-deleted record.
+func (r *Repository[T]) Restore(id string) (Entity[T], error) {
+	if r.tenant != nil {
+		if _, err := r.WithDeleted().getRecord(id); err != nil {
+			return Entity[T]{}, err
+		}
+	}
+	fields := map[string]any{r.opts.DeletedAtField: nil}
+	if r.opts.Timestamps {
+		fields[r.opts.UpdatedAtField] = timestamp()
+	}
+	rec, err := DatabaseMergePatch(r.collection, id, []byte(jsonValue(fields)))
+	if err != nil {
+		return Entity[T]{}, err
+	}
+	return r.decode(rec)
+}
+
+// Purge removes the record permanently, whether or not it was soft-deleted.
+func (r *Repository[T]) Purge(id string) error {
+	if r.tenant != nil {
+		if _, err := r.WithDeleted().getRecord(id); err != nil {
+			return err
+		}
+	}
+	return DatabaseDelete(r.collection, id)
+}
+
+// getRecord fetches a record, treating soft-deleted records as missing unless
+// the repository includes them.
+func (r *Repository[T]) getRecord(id string) (Record, error) {
+	rec, err := DatabaseGet(r.collection, id)
+	if err != nil {
+		return Record{}, err
+	}
+	if r.tenant != nil {
+		if err := r.tenant.Check(rec); err != nil {
+			return Record{}, err
+		}
+	}
+	if r.hidesDeleted() {
+		var doc map[string]any
+		_ = json.Unmarshal([]byte(rec.Data), &doc)
+		if doc[r.opts.DeletedAtField] != nil {
+			return Record{}, &wafer.WaferError{
+				Code:    "not_found",
+				Message: "record " + id + " not found in " + r.collection,
+			}
+		}
+	}
+	return rec, nil
+}
+
+// scope adds the filters implied by the collection options and tenant.
+func (r *Repository[T]) scope(filters []Filter) []Filter {
+	if !r.hidesDeleted() && r.tenant == nil {
+		return filters
+	}
+	out := append([]Filter(nil), filters...)
+	if r.hidesDeleted() {
+		out = append(out, Filter{Field: r.opts.DeletedAtField, Operator: OpIsNull})
+	}
+	if r.tenant != nil {
+		out = append(out, r.tenant.Filter())
+	}
+	return out
+}
+
+func (r *Repository[T]) hidesDeleted() bool {
+	return r.opts.SoftDelete && !r.withDeleted
+}
+
+// encode turns v into a JSON object so fields can be stamped onto it.
+func (r *Repository[T]) encode(v T) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, &wafer.WaferError{
+			Code:    "internal",
+			Message: "failed to marshal record: " + err.Error(),
+		}
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil || doc == nil {
+		return nil, &wafer.WaferError{
+			Code:    "invalid_argument",
+			Message: "repository values must encode to a JSON object",
+		}
+	}
+	// The record ID is not part of the data; decode fills it back in.
+	delete(doc, "id")
+	for _, rel := range r.relations {
+		delete(doc, rel.jsonName)
+	}
+	if r.tenant != nil {
+		if err := r.tenant.checkValue(doc[r.tenant.field]); err != nil {
+			return nil, err
+		}
+		doc[r.tenant.field] = json.RawMessage(jsonValue(r.tenant.id))
+	}
+	return doc, nil
+}
+
+// seal encrypts the tagged fields of an encoded document, validating its
+// plaintext against the collection's schema first: the write helpers only
+// see the ciphertexts and leave those fields out.
+func (r *Repository[T]) seal(doc map[string]json.RawMessage) error {
+	if r.crypt == nil {
+		return nil
+	}
+	if hasSchema(r.collection) {
+		data, err := json.Marshal(doc)
+		if err != nil {
+			return &wafer.WaferError{
+				Code:    "internal",
+				Message: "failed to marshal record: " + err.Error(),
+			}
+		}
+		if err := ValidateJSON(r.collection, data); err != nil {
+			return err
+		}
+	}
+	return r.crypt.seal(doc)
+}
+
+// include loads the relations requested with Include into decoded entities.
+func (r *Repository[T]) include(recs []Record, out []Entity[T]) error {
+	if len(r.includes) == 0 {
+		return nil
+	}
+	if r.relErr != nil {
+		return r.relErr
+	}
+	var scope []Filter
+	if !r.withDeleted {
+		scope = append(scope, Filter{Field: r.opts.DeletedAtField, Operator: OpIsNull})
+	}
+	if r.tenant != nil {
+		scope = append(scope, r.tenant.Filter())
+	}
+	return loadRelations(r.relations, r.includes, scope, recs, out)
+}
+
+func (r *Repository[T]) decode(rec Record) (Entity[T], error) {
+	e := Entity[T]{ID: rec.ID}
+	if r.crypt != nil {
+		var err error
+		if rec.Data, err = r.crypt.open(rec.Data); err != nil {
+			return Entity[T]{}, err
+		}
+	}
+	if err := decodeRecord(rec, &e.Data); err != nil {
+		return Entity[T]{}, &wafer.WaferError{
+			Code:    "internal",
+			Message: "failed to decode record: " + err.Error(),
+		}
+	}
+	return e, nil
+}
+
+// stampPatch adds field = value to a patch document.
+func stampPatch(format PatchFormat, patch []byte, field, value string) ([]byte, error) {
+	if format == JSONPatch {
+		var ops []json.RawMessage
+		if err := json.Unmarshal(patch, &ops); err != nil {
+			return nil, patchError("invalid JSON patch: " + err.Error())
+		}
+		op := map[string]string{"op": "add", "path": "/" + escapePointer(field), "value": value}
+		ops = append(ops, json.RawMessage(jsonValue(op)))
+		return json.Marshal(ops)
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &doc); err != nil || doc == nil {
+		return nil, patchError("merge patch must be a JSON object")
+	}
+	doc[field] = json.RawMessage(jsonValue(value))
+	return json.Marshal(doc)
+}
+
+// isUnsetTime reports whether a timestamp field is missing, null, empty or
+// the zero time.Time, as produced by structs that do not set it.
+func isUnsetTime(raw json.RawMessage) bool {
+	switch string(raw) {
+	case "", "null", `""`, `"0001-01-01T00:00:00Z"`:
+		return true
+	}
+	return false
+}
+
+// timestamp returns the current time in timestampLayout, so that stored
+// timestamps sort in time order.
+func timestamp() string {
+	return time.Now().UTC().Format(timestampLayout)
+}
@@ -0,0 +1,161 @@
+package services_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/wafer-run/wafer-sdk-go/services"
+)
+
+type note struct {
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func TestRepositoryUpdateKeepsCreatedAt(t *testing.T) {
+	tests := []struct {
+		name      string
+		createdAt time.Time
+	}{
+		{name: "unset"},
+		{name: "forged", createdAt: time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "later", createdAt: time.Now().Add(time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup(t)
+			repo := NewRepository[note]("notes", CollectionOptions{Timestamps: true})
+			created, err := repo.Create(note{Text: "a"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			updated, err := repo.Update(created.ID, note{Text: "b", CreatedAt: tt.createdAt})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !updated.Data.CreatedAt.Equal(created.Data.CreatedAt) {
+				t.Errorf("created_at = %v, want %v", updated.Data.CreatedAt, created.Data.CreatedAt)
+			}
+			if updated.Data.UpdatedAt.Before(created.Data.UpdatedAt) {
+				t.Errorf("updated_at went back from %v to %v", created.Data.UpdatedAt, updated.Data.UpdatedAt)
+			}
+		})
+	}
+}
+
+func TestRepositoryTimestampsSort(t *testing.T) {
+	db, _ := setup(t)
+	repo := NewRepository[note]("notes", CollectionOptions{Timestamps: true})
+	for i := 0; i < 20; i++ {
+		if _, err := repo.Create(note{Text: "n"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var prev string
+	for _, rec := range db.Records("notes") {
+		var doc struct {
+			CreatedAt string `json:"created_at"`
+		}
+		if err := json.Unmarshal([]byte(rec.Data), &doc); err != nil {
+			t.Fatal(err)
+		}
+		if len(doc.CreatedAt) != len("2006-01-02T15:04:05.000000000Z") {
+			t.Errorf("created_at %q is not fixed-width", doc.CreatedAt)
+		}
+		if doc.CreatedAt < prev {
+			t.Errorf("created_at %q sorts before %q", doc.CreatedAt, prev)
+		}
+		prev = doc.CreatedAt
+	}
+}
+
+func TestRepositorySoftDelete(t *testing.T) {
+	type repo = *Repository[note]
+	tests := []struct {
+		name    string
+		op      func(r repo, deleted, live string) error
+		code    string
+		visible int // records List returns afterwards
+		stored  int // records left in the collection
+	}{
+		{name: "get hides deleted", op: func(r repo, d, _ string) error { _, err := r.Get(d); return err }, code: "not_found", visible: 1, stored: 2},
+		{name: "get with deleted", op: func(r repo, d, _ string) error { _, err := r.WithDeleted().Get(d); return err }, visible: 1, stored: 2},
+		{
+			name: "list with deleted",
+			op: func(r repo, _, _ string) error {
+				l, err := r.WithDeleted().List(ListOptions{})
+				if err == nil && len(l.Entities) != 2 {
+					t.Errorf("WithDeleted().List returned %d entities, want 2", len(l.Entities))
+				}
+				return err
+			},
+			visible: 1, stored: 2,
+		},
+		{
+			name: "count",
+			op: func(r repo, _, _ string) error {
+				n, err := r.Count(nil)
+				all, _ := r.WithDeleted().Count(nil)
+				if n != 1 || all != 2 {
+					t.Errorf("Count = %d, WithDeleted().Count = %d; want 1 and 2", n, all)
+				}
+				return err
+			},
+			visible: 1, stored: 2,
+		},
+		{name: "delete again", op: func(r repo, d, _ string) error { return r.Delete(d) }, code: "not_found", visible: 1, stored: 2},
+		{name: "update deleted", op: func(r repo, d, _ string) error { _, err := r.Update(d, note{Text: "x"}); return err }, code: "not_found", visible: 1, stored: 2},
+		{
+			name:    "patch deleted",
+			op:      func(r repo, d, _ string) error { _, err := r.Patch(d, MergePatch, []byte(`{"text":"x"}`)); return err },
+			code:    "not_found",
+			visible: 1, stored: 2,
+		},
+		{
+			name: "update with deleted keeps the mark",
+			op: func(r repo, d, _ string) error {
+				_, err := r.WithDeleted().Update(d, note{Text: "x"})
+				return err
+			},
+			visible: 1, stored: 2,
+		},
+		{name: "delete live", op: func(r repo, _, l string) error { return r.Delete(l) }, visible: 0, stored: 2},
+		{name: "restore", op: func(r repo, d, _ string) error { _, err := r.Restore(d); return err }, visible: 2, stored: 2},
+		{name: "purge deleted", op: func(r repo, d, _ string) error { return r.Purge(d) }, visible: 1, stored: 1},
+		{name: "purge live", op: func(r repo, _, l string) error { return r.Purge(l) }, visible: 0, stored: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := setup(t)
+			r := NewRepository[note]("notes", CollectionOptions{SoftDelete: true, Timestamps: true})
+			deleted, err := r.Create(note{Text: "deleted"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			live, err := r.Create(note{Text: "live"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Delete(deleted.ID); err != nil {
+				t.Fatal(err)
+			}
+
+			err = tt.op(r, deleted.ID, live.ID)
+			if tt.code == "" && err != nil || tt.code != "" && !isCode(err, tt.code) {
+				t.Fatalf("error = %v, want %q", err, tt.code)
+			}
+			l, err := r.List(ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(l.Entities) != tt.visible {
+				t.Errorf("List returned %d entities, want %d", len(l.Entities), tt.visible)
+			}
+			if n := len(db.Records("notes")); n != tt.stored {
+				t.Errorf("%d records stored, want %d", n, tt.stored)
+			}
+		})
+	}
+}
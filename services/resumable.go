@@ -0,0 +1,554 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+	"github.com/wafer-run/wafer-sdk-go/gen/wafer/database"
+)
+
+// Defaults used by ResumableOptions.
+const (
+	DefaultUploadsCollection = "_wafer_uploads"
+	DefaultUploadsFolder     = "_wafer_uploads"
+	DefaultUploadTTL         = 24 * time.Hour
+)
+
+// uploadTimeLayout formats expiry times with a fixed width, so the stored
+// strings sort chronologically.
+const uploadTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// TusVersion is the tus protocol version spoken by ResumableUploads.Handle.
+const TusVersion = "1.0.0"
+
+// ChunkContentType is the request content type of upload chunks.
+const ChunkContentType = "application/offset+octet-stream"
+
+// ResumableOptions configures a ResumableUploads.
+type ResumableOptions struct {
+	// Collection holds one record per upload. Defaults to
+	// DefaultUploadsCollection.
+	Collection string
+	// ChunkFolder holds received chunks until the upload is finalized.
+	// Defaults to DefaultUploadsFolder.
+	ChunkFolder string
+	// TTL is how long an upload may sit idle before ExpireUploads removes
+	// it. Every chunk extends it. Defaults to DefaultUploadTTL.
+	TTL time.Duration
+	// MaxSize is the largest upload length accepted, or 0 for no limit.
+	MaxSize int64
+	// Key chooses the object key for uploads created through Handle, from
+	// the request and the client's Upload-Metadata. It defaults to the
+	// upload ID.
+	Key func(msg *wafer.Message, metadata map[string]string) (string, error)
+}
+
+func (o ResumableOptions) withDefaults() ResumableOptions {
+	if o.Collection == "" {
+		o.Collection = DefaultUploadsCollection
+	}
+	if o.ChunkFolder == "" {
+		o.ChunkFolder = DefaultUploadsFolder
+	}
+	if o.TTL <= 0 {
+		o.TTL = DefaultUploadTTL
+	}
+	return o
+}
+
+// Upload describes the state of a resumable upload. ID is random and is
+// the only thing a client needs to write to the upload, so treat it as a
+// credential.
+type Upload struct {
+	ID          string
+	Folder      string
+	Key         string
+	ContentType string
+	Length      int64
+	Offset      int64
+	Metadata    map[string]string
+	ExpiresAt   time.Time
+	Completed   bool
+	// Owner is the user that created the upload through Handle, or empty.
+	Owner string
+}
+
+// uploadRecord is the stored form of an Upload.
+type uploadRecord struct {
+	Token       string            `json:"token"`
+	Owner       string            `json:"owner,omitempty"`
+	Folder      string            `json:"folder"`
+	Key         string            `json:"key"`
+	ContentType string            `json:"content_type"`
+	Length      int64             `json:"length"`
+	Offset      int64             `json:"offset"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Chunks      []string          `json:"chunks"`
+	ExpiresAt   string            `json:"expires_at"`
+	Completed   bool              `json:"completed"`
+}
+
+// ResumableUploads stores objects in a folder through resumable uploads.
+// Clients create an upload with its total length, send chunks at increasing
+// offsets, and may query the offset reached to resume after a failure. Each
+// chunk is kept as its own object in the chunk folder; once the last byte
+// arrives the chunks are streamed into the target object and removed.
+// Progress is kept in a database collection.
+//
+// Upload IDs are random tokens rather than record IDs, so they cannot be
+// guessed. Handle also binds each upload to the user that created it: other
+// users get 404 Not Found for it.
+type ResumableUploads struct {
+	folder string
+	opts   ResumableOptions
+}
+
+// NewResumableUploads creates a ResumableUploads that stores finished
+// objects in folder.
+func NewResumableUploads(folder string, opts ResumableOptions) *ResumableUploads {
+	return &ResumableUploads{folder: folder, opts: opts.withDefaults()}
+}
+
+// Create starts an upload of length bytes to key. An empty key stores the
+// object under the upload ID. Zero-length uploads are finalized at once.
+func (u *ResumableUploads) Create(key, contentType string, length int64, metadata map[string]string) (Upload, error) {
+	return u.create("", key, contentType, length, metadata)
+}
+
+func (u *ResumableUploads) create(owner, key, contentType string, length int64, metadata map[string]string) (Upload, error) {
+	if length < 0 {
+		return Upload{}, &wafer.WaferError{
+			Code:    "invalid_argument",
+			Message: "upload length must not be negative",
+		}
+	}
+	if p, ok := LookupUploadPolicy(u.folder); ok && p.MaxSize > 0 && length > p.MaxSize {
+		return Upload{}, (&uploadCheck{policy: p, contentType: contentType, size: length}).tooLarge()
+	}
+	if u.opts.MaxSize > 0 && length > u.opts.MaxSize {
+		return Upload{}, &wafer.WaferError{
+			Code:    "resource_exhausted",
+			Message: "upload length exceeds the maximum of " + strconv.FormatInt(u.opts.MaxSize, 10) + " bytes",
+		}
+	}
+	token, err := CryptoRandomBytes(16)
+	if err != nil {
+		return Upload{}, err
+	}
+	doc := uploadRecord{
+		Token:       hex.EncodeToString(token),
+		Owner:       owner,
+		Folder:      u.folder,
+		Key:         key,
+		ContentType: contentType,
+		Length:      length,
+		Metadata:    metadata,
+		Chunks:      []string{},
+		ExpiresAt:   u.expiry(),
+	}
+	rec, err := DatabaseCreate(u.opts.Collection, doc)
+	if err != nil {
+		return Upload{}, err
+	}
+	if length == 0 {
+		return u.finalize(rec.ID, doc)
+	}
+	return doc.upload(), nil
+}
+
+// Status returns the state of an upload.
+func (u *ResumableUploads) Status(id string) (Upload, error) {
+	_, doc, err := u.load(id)
+	if err != nil {
+		return Upload{}, err
+	}
+	return doc.upload(), nil
+}
+
+// WriteChunk appends data at offset, which must equal the upload's current
+// offset; otherwise a "failed_precondition" WaferError is returned and the
+// client should query Status and resume from there. The upload is finalized
+// when its last byte is written.
+func (u *ResumableUploads) WriteChunk(id string, offset int64, data []byte) (Upload, error) {
+	recID, doc, err := u.load(id)
+	if err != nil {
+		return Upload{}, err
+	}
+	if doc.Completed {
+		return Upload{}, &wafer.WaferError{
+			Code:    "failed_precondition",
+			Message: "upload " + id + " is already complete",
+		}
+	}
+	if offset != doc.Offset {
+		return Upload{}, &wafer.WaferError{
+			Code:    "failed_precondition",
+			Message: "upload " + id + " is at offset " + strconv.FormatInt(doc.Offset, 10) + ", not " + strconv.FormatInt(offset, 10),
+			Meta:    map[string]string{"offset": strconv.FormatInt(doc.Offset, 10)},
+		}
+	}
+	if offset+int64(len(data)) > doc.Length {
+		return Upload{}, &wafer.WaferError{
+			Code:    "out_of_range",
+			Message: "chunk extends past the upload length of " + strconv.FormatInt(doc.Length, 10) + " bytes",
+		}
+	}
+	if len(data) > 0 {
+		suffix, err := CryptoRandomBytes(6)
+		if err != nil {
+			return Upload{}, err
+		}
+		chunk := id + "/" + chunkName(offset) + "-" + hex.EncodeToString(suffix)
+		if err := StoragePut(u.opts.ChunkFolder, chunk, data, "application/octet-stream"); err != nil {
+			return Upload{}, err
+		}
+		next := doc
+		next.Chunks = append(append([]string(nil), doc.Chunks...), chunk)
+		next.Offset = offset + int64(len(data))
+		next.ExpiresAt = u.expiry()
+		if _, err := DatabaseUpdateIf(u.opts.Collection, recID, "offset", offset, next); err != nil {
+			StorageDelete(u.opts.ChunkFolder, chunk)
+			return Upload{}, err
+		}
+		doc = next
+	}
+	if doc.Offset == doc.Length {
+		return u.finalize(recID, doc)
+	}
+	return doc.upload(), nil
+}
+
+// Finalize assembles a fully received upload into its target object. It is
+// called by WriteChunk and only needs to be called directly to retry a
+// failed assembly.
+func (u *ResumableUploads) Finalize(id string) (Upload, error) {
+	recID, doc, err := u.load(id)
+	if err != nil {
+		return Upload{}, err
+	}
+	if doc.Completed {
+		return doc.upload(), nil
+	}
+	if doc.Offset != doc.Length {
+		return Upload{}, &wafer.WaferError{
+			Code:    "failed_precondition",
+			Message: "upload " + id + " has " + strconv.FormatInt(doc.Length-doc.Offset, 10) + " bytes outstanding",
+		}
+	}
+	return u.finalize(recID, doc)
+}
+
+// Abort discards an upload and its chunks.
+func (u *ResumableUploads) Abort(id string) error {
+	recID, doc, err := u.load(id)
+	if err != nil {
+		return err
+	}
+	return u.remove(recID, doc)
+}
+
+// ExpireUploads removes uploads whose TTL has elapsed, together with their
+// chunks, and returns how many were removed. Run it periodically, for
+// example from a scheduled block.
+func (u *ResumableUploads) ExpireUploads() (int, error) {
+	filters := []Filter{{Field: "expires_at", Operator: OpLess, Value: jsonValue(uploadNow())}}
+	removed := 0
+	for {
+		rl, err := DatabaseList(u.opts.Collection, ListOptions{Filters: filters, Limit: BatchChunkSize})
+		if err != nil {
+			return removed, err
+		}
+		for _, rec := range rl.Records {
+			var doc uploadRecord
+			if err := json.Unmarshal([]byte(rec.Data), &doc); err != nil {
+				return removed, uploadDecodeError(err)
+			}
+			if err := u.remove(rec.ID, doc); err != nil {
+				return removed, err
+			}
+			removed++
+		}
+		if len(rl.Records) < BatchChunkSize {
+			return removed, nil
+		}
+	}
+}
+
+// Handle implements the tus 1.0 core protocol with the creation and
+// termination extensions, for a block mounted at the uploads URL:
+//
+//   - create (POST) starts an upload from the Upload-Length and
+//     Upload-Metadata headers and returns its URL in Location. A body sent
+//     with content type application/offset+octet-stream is the first chunk.
+//   - head or retrieve (HEAD) reports Upload-Offset and Upload-Length.
+//     Actions map to methods as for presigned URLs, so hosts may report
+//     HEAD requests either way.
+//   - update (PATCH) writes the body at Upload-Offset.
+//   - delete removes the upload.
+//
+// The upload ID is taken from the "id" path variable, or else the last
+// segment of the request path. The "filetype" metadata entry becomes the
+// object's content type. Uploads created by a signed-in user can only be
+// used by that user.
+func (u *ResumableUploads) Handle(msg *wafer.Message) *wafer.BlockResult {
+	if msg.Action() == "create" {
+		return u.handleCreate(msg)
+	}
+	id := msg.Var("id")
+	if id == "" {
+		id = path.Base(msg.Path())
+	}
+	if up, err := u.Status(id); err != nil {
+		return uploadErrorResult(err)
+	} else if up.Owner != msg.UserID() {
+		return uploadErrorResult(uploadNotFound(id))
+	}
+	// PATCH requests arrive as the "update" action, which maps to PUT.
+	switch requestMethod(msg) {
+	case "GET", "HEAD":
+		up, err := u.Status(id)
+		if err != nil {
+			return uploadErrorResult(err)
+		}
+		return u.uploadHeaders(up).
+			Header("Upload-Length", strconv.FormatInt(up.Length, 10)).
+			Header("Cache-Control", "no-store").
+			Status(200).
+			Respond()
+	case "PUT":
+		if ct, _, _ := strings.Cut(msg.ContentType(), ";"); strings.TrimSpace(ct) != ChunkContentType {
+			return wafer.ErrorStatus(415, wafer.ErrorCodeInvalidArgument, "chunks must be sent as "+ChunkContentType)
+		}
+		offset, err := strconv.ParseInt(msg.Header("Upload-Offset"), 10, 64)
+		if err != nil {
+			return wafer.ErrorStatus(400, wafer.ErrorCodeInvalidArgument, "missing or invalid Upload-Offset header")
+		}
+		up, err := u.WriteChunk(id, offset, msg.Data)
+		if err != nil {
+			return uploadErrorResult(err)
+		}
+		return u.uploadHeaders(up).Status(204).Respond()
+	case "DELETE":
+		if err := u.Abort(id); err != nil {
+			return uploadErrorResult(err)
+		}
+		return wafer.NewResponseBuilder().Header("Tus-Resumable", TusVersion).Status(204).Respond()
+	}
+	return wafer.ErrorStatus(405, wafer.ErrorCodeUnimplemented, "unsupported upload action")
+}
+
+func (u *ResumableUploads) handleCreate(msg *wafer.Message) *wafer.BlockResult {
+	length, err := strconv.ParseInt(msg.Header("Upload-Length"), 10, 64)
+	if err != nil {
+		return wafer.ErrorStatus(400, wafer.ErrorCodeInvalidArgument, "missing or invalid Upload-Length header")
+	}
+	metadata, err := ParseUploadMetadata(msg.Header("Upload-Metadata"))
+	if err != nil {
+		return wafer.ErrorStatus(400, wafer.ErrorCodeInvalidArgument, err.Error())
+	}
+	key := ""
+	if u.opts.Key != nil {
+		if key, err = u.opts.Key(msg, metadata); err != nil {
+			return uploadErrorResult(err)
+		}
+	}
+	up, err := u.create(msg.UserID(), key, metadata["filetype"], length, metadata)
+	if err != nil {
+		return uploadErrorResult(err)
+	}
+	if len(msg.Data) > 0 && strings.HasPrefix(msg.ContentType(), ChunkContentType) {
+		if up, err = u.WriteChunk(up.ID, 0, msg.Data); err != nil {
+			return uploadErrorResult(err)
+		}
+	}
+	return u.uploadHeaders(up).
+		Header("Location", strings.TrimSuffix(msg.Path(), "/")+"/"+up.ID).
+		Status(201).
+		Respond()
+}
+
+func (u *ResumableUploads) uploadHeaders(up Upload) *wafer.ResponseBuilder {
+	return wafer.NewResponseBuilder().
+		Header("Tus-Resumable", TusVersion).
+		Header("Upload-Offset", strconv.FormatInt(up.Offset, 10)).
+		Header("Upload-Expires", up.ExpiresAt.UTC().Format(HTTPTimeFormat))
+}
+
+// ParseUploadMetadata decodes a tus Upload-Metadata header: comma-separated
+// pairs of a key and an optional base64-encoded value.
+func ParseUploadMetadata(header string) (map[string]string, error) {
+	out := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, " ")
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			return nil, errors.New("invalid Upload-Metadata value for " + key)
+		}
+		out[key] = string(decoded)
+	}
+	return out, nil
+}
+
+// load looks up an upload by its ID and returns the record ID and stored
+// state, treating expired uploads as gone.
+func (u *ResumableUploads) load(id string) (string, uploadRecord, error) {
+	var doc uploadRecord
+	if id == "" {
+		return "", doc, uploadNotFound(id)
+	}
+	rl, err := DatabaseList(u.opts.Collection, ListOptions{
+		Filters: []Filter{{Field: "token", Operator: OpEqual, Value: jsonValue(id)}},
+		Limit:   1,
+	})
+	if err != nil {
+		return "", doc, err
+	}
+	if len(rl.Records) == 0 {
+		return "", doc, uploadNotFound(id)
+	}
+	rec := rl.Records[0]
+	if err := json.Unmarshal([]byte(rec.Data), &doc); err != nil {
+		return "", doc, uploadDecodeError(err)
+	}
+	if doc.Token != id || doc.ExpiresAt < uploadNow() {
+		return "", doc, uploadNotFound(id)
+	}
+	return rec.ID, doc, nil
+}
+
+// finalize streams the chunks into the target object, then marks the upload
+// complete and removes the chunks.
+func (u *ResumableUploads) finalize(recID string, doc uploadRecord) (Upload, error) {
+	if doc.Key == "" {
+		doc.Key = doc.Token
+	}
+	w := &StorageWriter{
+		folder:      doc.Folder,
+		key:         doc.Key,
+		contentType: doc.ContentType,
+		check:       newUploadCheck(doc.Folder, doc.ContentType),
+	}
+	for _, chunk := range doc.Chunks {
+		r, err := openStorageReader(u.opts.ChunkFolder, chunk)
+		if err != nil {
+			w.Abort()
+			return Upload{}, err
+		}
+		_, err = io.Copy(w, r)
+		r.Close()
+		if err != nil {
+			w.Abort()
+			return Upload{}, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return Upload{}, err
+	}
+	chunks := doc.Chunks
+	doc.Chunks, doc.Completed = []string{}, true
+	if _, err := DatabaseUpdate(u.opts.Collection, recID, doc); err != nil {
+		return Upload{}, err
+	}
+	for _, chunk := range chunks {
+		StorageDelete(u.opts.ChunkFolder, chunk)
+	}
+	return doc.upload(), nil
+}
+
+func (u *ResumableUploads) remove(recID string, doc uploadRecord) error {
+	for _, chunk := range doc.Chunks {
+		StorageDelete(u.opts.ChunkFolder, chunk)
+	}
+	err := DatabaseDelete(u.opts.Collection, recID)
+	if errors.Is(err, database.DatabaseErrorNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (u *ResumableUploads) expiry() string {
+	return time.Now().Add(u.opts.TTL).UTC().Format(uploadTimeLayout)
+}
+
+func uploadNow() string {
+	return time.Now().UTC().Format(uploadTimeLayout)
+}
+
+func (doc uploadRecord) upload() Upload {
+	expires, _ := time.Parse(uploadTimeLayout, doc.ExpiresAt)
+	return Upload{
+		ID:          doc.Token,
+		Folder:      doc.Folder,
+		Key:         doc.Key,
+		ContentType: doc.ContentType,
+		Length:      doc.Length,
+		Offset:      doc.Offset,
+		Metadata:    doc.Metadata,
+		ExpiresAt:   expires,
+		Completed:   doc.Completed,
+		Owner:       doc.Owner,
+	}
+}
+
+// chunkName zero-pads an offset so chunk keys sort in upload order.
+func chunkName(offset int64) string {
+	s := strconv.FormatInt(offset, 10)
+	return strings.Repeat("0", max(20-len(s), 0)) + s
+}
+
+func uploadNotFound(id string) error {
+	return &wafer.WaferError{
+		Code:    "not_found",
+		Message: "upload " + id + " not found",
+	}
+}
+
+func uploadDecodeError(err error) error {
+	return &wafer.WaferError{
+		Code:    "internal",
+		Message: "failed to decode upload record: " + err.Error(),
+	}
+}
+
+// uploadErrorResult maps upload errors to the status codes tus clients
+// expect.
+func uploadErrorResult(err error) *wafer.BlockResult {
+	var we *wafer.WaferError
+	if !errors.As(err, &we) {
+		return wafer.ErrorStatus(500, wafer.ErrorCodeInternal, err.Error())
+	}
+	status := 500
+	switch we.Code {
+	case "invalid_argument":
+		status = 400
+	case "permission_denied":
+		status = 403
+	case "not_found":
+		status = 404
+	case "failed_precondition", "aborted":
+		status = 409
+	case "out_of_range", "resource_exhausted":
+		status = 413
+	}
+	if we.Meta["reason"] == UploadTooLarge {
+		status = 413
+	}
+	res := wafer.ErrorStatus(status, we.Code, we.Message)
+	for k, v := range we.Meta {
+		res.Error.Meta[k] = v
+	}
+	res.Error.Meta["resp.header.Tus-Resumable"] = TusVersion
+	return res
+}
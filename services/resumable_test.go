@@ -0,0 +1,106 @@
+package services_test
+
+import (
+	"path"
+	"testing"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+	. "github.com/wafer-run/wafer-sdk-go/services"
+)
+
+// tusRequest builds a tus request for upload id as user.
+func tusRequest(action, id, user string, headers map[string]string, data []byte) *wafer.Message {
+	msg := &wafer.Message{Data: data}
+	msg.SetMeta("req.action", action)
+	msg.SetMeta("req.resource", "/uploads/"+id)
+	if user != "" {
+		msg.SetMeta("auth.user_id", user)
+	}
+	for k, v := range headers {
+		msg.SetMeta("http.header."+k, v)
+	}
+	return msg
+}
+
+func TestResumableUploadsAccess(t *testing.T) {
+	db, st := setup(t)
+	u := NewResumableUploads("files", ResumableOptions{})
+
+	create := tusRequest("create", "", "alice", map[string]string{"upload-length": "4"}, nil)
+	create.SetMeta("req.resource", "/uploads")
+	r := u.Handle(create)
+	if got := status(r); got != "201" {
+		t.Fatalf("create status = %s", got)
+	}
+	recs := db.Records(DefaultUploadsCollection)
+	if len(recs) != 1 {
+		t.Fatalf("%d upload records, want 1", len(recs))
+	}
+	up, err := u.Status(path.Base(r.Response.Meta["resp.header.Location"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if up.ID == recs[0].ID || len(up.ID) != 32 {
+		t.Fatalf("upload ID %q is not a random token", up.ID)
+	}
+	if up.Owner != "alice" {
+		t.Fatalf("Owner = %q, want alice", up.Owner)
+	}
+
+	chunk := map[string]string{"upload-offset": "0"}
+	tests := []struct {
+		name, action, id, user string
+		status                 string
+	}{
+		{name: "record id", action: "retrieve", id: recs[0].ID, user: "alice", status: "404"},
+		{name: "guessed id", action: "retrieve", id: "00000000000000000000000000000000", user: "alice", status: "404"},
+		{name: "empty id", action: "retrieve", id: "", user: "alice", status: "404"},
+		{name: "other user retrieve", action: "retrieve", id: up.ID, user: "mallory", status: "404"},
+		{name: "anonymous retrieve", action: "retrieve", id: up.ID, status: "404"},
+		{name: "other user update", action: "update", id: up.ID, user: "mallory", status: "404"},
+		{name: "other user delete", action: "delete", id: up.ID, user: "mallory", status: "404"},
+		{name: "other user head", action: "head", id: up.ID, user: "mallory", status: "404"},
+		{name: "owner retrieve", action: "retrieve", id: up.ID, user: "alice", status: "200"},
+		{name: "owner head", action: "head", id: up.ID, user: "alice", status: "200"},
+		{name: "owner update", action: "update", id: up.ID, user: "alice", status: "204"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := tusRequest(tt.action, tt.id, tt.user, chunk, []byte("data"))
+			msg.SetMeta("req.content_type", ChunkContentType)
+			if got := status(u.Handle(msg)); got != tt.status {
+				t.Errorf("status = %s, want %s", got, tt.status)
+			}
+		})
+	}
+
+	if data, ok := st.Object("files", up.ID); !ok || string(data) != "data" {
+		t.Errorf("object = %q, %v; want the owner's upload only", data, ok)
+	}
+}
+
+func TestResumableUploadsWithoutOwner(t *testing.T) {
+	setup(t)
+	u := NewResumableUploads("files", ResumableOptions{})
+	up, err := u.Create("", "text/plain", 3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []string{"", "alice"} {
+		msg := tusRequest("retrieve", up.ID, user, nil, nil)
+		want := "200"
+		if user != "" {
+			want = "404"
+		}
+		if got := status(u.Handle(msg)); got != want {
+			t.Errorf("user %q: status = %s, want %s", user, got, want)
+		}
+	}
+	up, err = u.WriteChunk(up.ID, 0, []byte("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !up.Completed || up.Offset != 3 {
+		t.Errorf("upload = %+v, want completed at offset 3", up)
+	}
+}
@@ -0,0 +1,434 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+)
+
+// UnknownColumnError is returned when a query result column has no matching
+// struct field.
+type UnknownColumnError struct {
+	Column string
+	Type   string
+}
+
+func (e *UnknownColumnError) Error() string {
+	return "column " + strconv.Quote(e.Column) + " has no matching field in " + e.Type
+}
+
+// QueryInto runs a raw SELECT query and maps each result row onto a T. Columns
+// are matched to struct fields by `db` tag, then `json` tag, then
+// case-insensitively by field name. Null columns leave fields at their zero
+// value, and numbers, strings and booleans are converted to the field type.
+// A column without a matching field fails with *UnknownColumnError.
+func QueryInto[T any](query string, args ...any) ([]T, error) {
+	recs, err := DatabaseQueryRaw(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]T, len(recs))
+	for i, rec := range recs {
+		if err := ScanRecord(rec, &out[i]); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// QueryOne runs a raw SELECT query and maps the first row onto a T. It
+// returns a "not_found" WaferError when the query yields no rows.
+func QueryOne[T any](query string, args ...any) (T, error) {
+	var out T
+	recs, err := DatabaseQueryRaw(query, args...)
+	if err != nil {
+		return out, err
+	}
+	if len(recs) == 0 {
+		return out, &wafer.WaferError{
+			Code:    "not_found",
+			Message: "query returned no rows",
+		}
+	}
+	err = ScanRecord(recs[0], &out)
+	return out, err
+}
+
+// ScanRecord maps the columns of a raw query row onto the struct pointed to by
+// dst, using the same rules as QueryInto. The record ID is stored in a field
+// named "id" when the row has no "id" column of its own.
+func ScanRecord(rec Record, dst any) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return &wafer.WaferError{
+			Code:    "invalid_argument",
+			Message: "scan destination must be a non-nil pointer to a struct",
+		}
+	}
+	sv := rv.Elem()
+	fields := structColumns(sv.Type())
+
+	dec := json.NewDecoder(strings.NewReader(rec.Data))
+	dec.UseNumber()
+	var row map[string]any
+	if err := dec.Decode(&row); err != nil {
+		return &wafer.WaferError{
+			Code:    "internal",
+			Message: "failed to decode row: " + err.Error(),
+		}
+	}
+
+	for col, val := range row {
+		idx, ok := fields.lookup(col)
+		if !ok {
+			return &UnknownColumnError{Column: col, Type: sv.Type().String()}
+		}
+		fv, err := fieldByIndex(sv, idx)
+		if err != nil {
+			return err
+		}
+		if err := assignColumn(fv, val); err != nil {
+			return &wafer.WaferError{
+				Code:    "internal",
+				Message: "column " + strconv.Quote(col) + ": " + err.Error(),
+			}
+		}
+	}
+	if _, hasID := row["id"]; !hasID && rec.ID != "" {
+		if idx, ok := fields.lookup("id"); ok {
+			fv, err := fieldByIndex(sv, idx)
+			if err != nil {
+				return err
+			}
+			if err := assignColumn(fv, rec.ID); err != nil {
+				return &wafer.WaferError{
+					Code:    "internal",
+					Message: "column \"id\": " + err.Error(),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// columnMap maps column names to struct field index paths.
+type columnMap struct {
+	exact map[string][]int
+	fold  map[string][]int
+}
+
+func (m *columnMap) lookup(col string) ([]int, bool) {
+	if idx, ok := m.exact[col]; ok {
+		return idx, true
+	}
+	idx, ok := m.fold[strings.ToLower(col)]
+	return idx, ok
+}
+
+var columnMaps sync.Map // reflect.Type -> *columnMap
+
+// columnField is a candidate struct field for a column.
+type columnField struct {
+	name   string
+	index  []int
+	tagged bool
+}
+
+func structColumns(t reflect.Type) *columnMap {
+	if m, ok := columnMaps.Load(t); ok {
+		return m.(*columnMap)
+	}
+	var fields []columnField
+	collectColumns(t, nil, map[reflect.Type]bool{t: true}, &fields)
+	m := &columnMap{
+		exact: dominantColumns(fields, func(name string) string { return name }),
+		fold:  dominantColumns(fields, strings.ToLower),
+	}
+	columnMaps.Store(t, m)
+	return m
+}
+
+// collectColumns lists the exported fields of t, descending into untagged
+// embedded structs. Struct types already on the path are not entered again.
+func collectColumns(t reflect.Type, parent []int, seen map[reflect.Type]bool, out *[]columnField) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		idx := append(append([]int(nil), parent...), i)
+		name, tagged := columnName(f)
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && !tagged {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if !seen[ft] {
+					seen[ft] = true
+					collectColumns(ft, idx, seen, out)
+					delete(seen, ft)
+				}
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		*out = append(*out, columnField{name: name, index: idx, tagged: tagged})
+	}
+}
+
+// dominantColumns picks one field per column name the way encoding/json
+// does: the shallowest field wins, a tagged field beats untagged ones at the
+// same depth, and names that are still ambiguous map to no field.
+func dominantColumns(fields []columnField, key func(string) string) map[string][]int {
+	best := make(map[string][]columnField)
+	for _, f := range fields {
+		k := key(f.name)
+		cur := best[k]
+		switch {
+		case len(cur) == 0 || len(f.index) < len(cur[0].index):
+			best[k] = []columnField{f}
+		case len(f.index) == len(cur[0].index):
+			best[k] = append(cur, f)
+		}
+	}
+	out := make(map[string][]int, len(best))
+	for k, cands := range best {
+		if len(cands) == 1 {
+			out[k] = cands[0].index
+			continue
+		}
+		var tagged []columnField
+		for _, f := range cands {
+			if f.tagged {
+				tagged = append(tagged, f)
+			}
+		}
+		if len(tagged) == 1 {
+			out[k] = tagged[0].index
+		}
+	}
+	return out
+}
+
+// columnName returns the column name for a struct field and whether it came
+// from a tag.
+func columnName(f reflect.StructField) (string, bool) {
+	for _, key := range []string{"db", "json"} {
+		if tag, ok := f.Tag.Lookup(key); ok {
+			name, _, _ := strings.Cut(tag, ",")
+			if name != "" {
+				return name, true
+			}
+		}
+	}
+	return f.Name, false
+}
+
+// fieldByIndex is reflect.Value.FieldByIndex that allocates nil embedded
+// struct pointers on the way.
+func fieldByIndex(v reflect.Value, idx []int) (reflect.Value, error) {
+	for i, x := range idx {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, &wafer.WaferError{
+						Code:    "internal",
+						Message: "cannot set embedded pointer to unexported struct " + v.Type().Elem().String(),
+					}
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
+
+var (
+	timeType        = reflect.TypeOf(time.Time{})
+	unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// timeLayouts are the column formats accepted for time.Time fields.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// assignColumn converts a decoded JSON column value to the field's type.
+func assignColumn(fv reflect.Value, val any) error {
+	if val == nil {
+		fv.Set(reflect.Zero(fv.Type()))
+		return nil
+	}
+	if fv.Kind() == reflect.Pointer {
+		ptr := reflect.New(fv.Type().Elem())
+		if err := assignColumn(ptr.Elem(), val); err != nil {
+			return err
+		}
+		fv.Set(ptr)
+		return nil
+	}
+	if fv.Type() != timeType && reflect.PointerTo(fv.Type()).Implements(unmarshalerType) {
+		return assignJSON(fv, val)
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		switch v := val.(type) {
+		case string:
+			fv.SetString(v)
+		case json.Number:
+			fv.SetString(v.String())
+		case bool:
+			fv.SetString(strconv.FormatBool(v))
+		default:
+			return assignJSON(fv, val)
+		}
+		return nil
+	case reflect.Bool:
+		switch v := val.(type) {
+		case bool:
+			fv.SetBool(v)
+		case json.Number:
+			f, err := v.Float64()
+			if err != nil {
+				return err
+			}
+			fv.SetBool(f != 0)
+		case string:
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return convertError(val, fv.Type())
+			}
+			fv.SetBool(b)
+		default:
+			return convertError(val, fv.Type())
+		}
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := columnInt(val)
+		if err != nil || fv.OverflowInt(n) {
+			return convertError(val, fv.Type())
+		}
+		fv.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := columnInt(val)
+		if err != nil || n < 0 || fv.OverflowUint(uint64(n)) {
+			return convertError(val, fv.Type())
+		}
+		fv.SetUint(uint64(n))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f, err := columnFloat(val)
+		if err != nil || fv.OverflowFloat(f) {
+			return convertError(val, fv.Type())
+		}
+		fv.SetFloat(f)
+		return nil
+	case reflect.Struct:
+		if fv.Type() == timeType {
+			return assignTime(fv, val)
+		}
+	case reflect.Slice:
+		if s, ok := val.(string); ok && fv.Type().Elem().Kind() == reflect.Uint8 {
+			fv.SetBytes([]byte(s))
+			return nil
+		}
+	}
+	return assignJSON(fv, val)
+}
+
+func columnInt(val any) (int64, error) {
+	switch v := val.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, nil
+		}
+		f, err := v.Float64()
+		if err != nil || f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, strconv.ErrRange
+		}
+		return int64(f), nil
+	case string:
+		return strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, strconv.ErrSyntax
+}
+
+func columnFloat(val any) (float64, error) {
+	switch v := val.(type) {
+	case json.Number:
+		return v.Float64()
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, strconv.ErrSyntax
+}
+
+func assignTime(fv reflect.Value, val any) error {
+	switch v := val.(type) {
+	case string:
+		for _, layout := range timeLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				fv.Set(reflect.ValueOf(t))
+				return nil
+			}
+		}
+	case json.Number:
+		if secs, err := v.Float64(); err == nil {
+			whole, frac := math.Modf(secs)
+			fv.Set(reflect.ValueOf(time.Unix(int64(whole), int64(frac*1e9)).UTC()))
+			return nil
+		}
+	}
+	return convertError(val, fv.Type())
+}
+
+// assignJSON decodes a column into fv with encoding/json. Strings holding a
+// JSON document, as returned for JSON columns by some databases, are decoded
+// as that document.
+func assignJSON(fv reflect.Value, val any) error {
+	raw, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	if s, ok := val.(string); ok && fv.Kind() != reflect.String {
+		if trimmed := strings.TrimSpace(s); json.Valid([]byte(trimmed)) {
+			raw = []byte(trimmed)
+		}
+	}
+	if err := json.Unmarshal(raw, fv.Addr().Interface()); err != nil {
+		return convertError(val, fv.Type())
+	}
+	return nil
+}
+
+func convertError(val any, t reflect.Type) error {
+	return errors.New("cannot convert " + jsonValue(val) + " to " + t.String())
+}
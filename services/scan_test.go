@@ -0,0 +1,142 @@
+package services_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/wafer-run/wafer-sdk-go/gen/wafer/database"
+	. "github.com/wafer-run/wafer-sdk-go/services"
+)
+
+type scanBase struct {
+	Name string `json:"name"`
+	Kind string
+}
+
+type scanOuter struct {
+	scanBase
+	ID      string
+	Name    string `json:"name"`
+	Count   int    `db:"n"`
+	Score   *float64
+	At      time.Time `json:"at"`
+	Tags    []string  `json:"tags"`
+	Ignored string    `json:"-"`
+}
+
+type scanUntagged struct {
+	scanBase
+	Kind string
+}
+
+type scanAmbiguousA struct{ Title string }
+type scanAmbiguousB struct{ Title string }
+
+type scanAmbiguous struct {
+	scanAmbiguousA
+	scanAmbiguousB
+}
+
+func TestScanRecord(t *testing.T) {
+	score := 1.5
+	at := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	tests := []struct {
+		name    string
+		rec     Record
+		dst     func() any
+		want    any
+		unknown string
+	}{
+		{
+			name: "tagged outer field wins over promoted field",
+			rec:  Record{ID: "r1", Data: `{"name":"outer","kind":"k","n":"3","score":1.5,"at":"2024-05-06T07:08:09Z","tags":"[\"a\"]"}`},
+			dst:  func() any { return &scanOuter{} },
+			want: &scanOuter{scanBase: scanBase{Kind: "k"}, ID: "r1", Name: "outer", Count: 3, Score: &score, At: at, Tags: []string{"a"}},
+		},
+		{
+			name: "shallower untagged field wins",
+			rec:  Record{Data: `{"Kind":"outer"}`},
+			dst:  func() any { return &scanUntagged{} },
+			want: &scanUntagged{Kind: "outer"},
+		},
+		{
+			name: "promoted field when not shadowed",
+			rec:  Record{Data: `{"name":"base"}`},
+			dst:  func() any { return &scanUntagged{} },
+			want: &scanUntagged{scanBase: scanBase{Name: "base"}},
+		},
+		{
+			name: "row id column is kept",
+			rec:  Record{ID: "r1", Data: `{"id":7}`},
+			dst:  func() any { return &scanOuter{} },
+			want: &scanOuter{ID: "7"},
+		},
+		{
+			name: "null leaves zero value",
+			rec:  Record{Data: `{"score":null,"n":null}`},
+			dst:  func() any { return &scanOuter{} },
+			want: &scanOuter{},
+		},
+		{name: "unknown column", rec: Record{Data: `{"missing":1}`}, dst: func() any { return &scanOuter{} }, unknown: "missing"},
+		{name: "ignored field", rec: Record{Data: `{"Ignored":"x"}`}, dst: func() any { return &scanOuter{} }, unknown: "Ignored"},
+		{name: "ambiguous promoted fields", rec: Record{Data: `{"Title":"x"}`}, dst: func() any { return &scanAmbiguous{} }, unknown: "Title"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := tt.dst()
+			err := ScanRecord(tt.rec, dst)
+			if tt.unknown != "" {
+				var ue *UnknownColumnError
+				if !errors.As(err, &ue) || ue.Column != tt.unknown {
+					t.Fatalf("ScanRecord error = %v, want unknown column %q", err, tt.unknown)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("ScanRecord = %+v, want %+v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryInto(t *testing.T) {
+	db, _ := setup(t)
+	var gotArgs string
+	db.QueryRaw = func(query, args string) ([]database.DbRecord, error) {
+		gotArgs = args
+		if query == "empty" {
+			return nil, nil
+		}
+		return []database.DbRecord{
+			{ID: "1", Data: `{"name":"a","n":1}`},
+			{ID: "2", Data: `{"name":"b","n":2}`},
+		}, nil
+	}
+
+	rows, err := QueryInto[scanOuter]("select", "x", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotArgs != `["x",1]` {
+		t.Errorf("args = %s", gotArgs)
+	}
+	if len(rows) != 2 || rows[0].Name != "a" || rows[1].Count != 2 || rows[1].ID != "2" {
+		t.Errorf("QueryInto = %+v", rows)
+	}
+
+	one, err := QueryOne[scanOuter]("select")
+	if err != nil || one.Name != "a" {
+		t.Errorf("QueryOne = %+v, %v", one, err)
+	}
+	if _, err := QueryOne[scanOuter]("empty"); !isCode(err, "not_found") {
+		t.Errorf("QueryOne on no rows = %v, want not_found", err)
+	}
+	if _, err := QueryInto[scanAmbiguous]("select"); err == nil {
+		t.Error("QueryInto into a struct without the columns succeeded")
+	}
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"slices"
+	"strconv"
+	"sync"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+	"github.com/wafer-run/wafer-sdk-go/services/jsonschema"
+)
+
+// MaxReportedViolations caps the number of violations copied into the Meta
+// of a validation error.
+const MaxReportedViolations = 50
+
+var (
+	schemasMu sync.RWMutex
+	schemas   = map[string]*jsonschema.Schema{}
+)
+
+// RegisterSchema compiles a JSON Schema and registers it under name. When
+// name is a collection, DatabaseCreate, DatabaseUpdate, DatabasePatch and the
+// other write helpers reject documents that do not conform to it. The same
+// schema can be used with ValidateJSON and ValidateMessage. Registering a
+// name again replaces its schema.
+func RegisterSchema(name string, schema []byte) error {
+	s, err := jsonschema.Compile(schema)
+	if err != nil {
+		return &wafer.WaferError{
+			Code:    "invalid_argument",
+			Message: "invalid schema for " + name + ": " + err.Error(),
+		}
+	}
+	schemasMu.Lock()
+	schemas[name] = s
+	schemasMu.Unlock()
+	return nil
+}
+
+// UnregisterSchema removes the schema registered under name.
+func UnregisterSchema(name string) {
+	schemasMu.Lock()
+	delete(schemas, name)
+	schemasMu.Unlock()
+}
+
+// LookupSchema returns the schema registered under name.
+func LookupSchema(name string) (*jsonschema.Schema, bool) {
+	schemasMu.RLock()
+	defer schemasMu.RUnlock()
+	s, ok := schemas[name]
+	return s, ok
+}
+
+// ValidateJSON validates a JSON document against the schema registered under
+// name. It returns nil when no schema is registered. A non-conforming
+// document yields an "invalid_argument" WaferError whose Meta has one
+// "violation<pointer>" entry per violation, e.g. "violation/email", with
+// "violation" alone for the document root.
+func ValidateJSON(name string, data []byte) error {
+	return validateDocument(name, data, nil)
+}
+
+// validateDocument is ValidateJSON, dropping the violations for which skip
+// returns true.
+func validateDocument(name string, data []byte, skip func(jsonschema.Violation) bool) error {
+	s, ok := LookupSchema(name)
+	if !ok {
+		return nil
+	}
+	violations, err := s.ValidateJSON(data)
+	if err != nil {
+		return &wafer.WaferError{
+			Code:    "invalid_argument",
+			Message: err.Error(),
+		}
+	}
+	if skip != nil {
+		violations = slices.DeleteFunc(violations, skip)
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	return violationError(name, violations)
+}
+
+// ValidateMessage validates msg.Data against the schema registered under
+// name. It returns nil when the payload is valid, so a block can return the
+// result directly:
+//
+//	if r := services.ValidateMessage(msg, "signup"); r != nil {
+//		return r
+//	}
+func ValidateMessage(msg *wafer.Message, name string) *wafer.BlockResult {
+	err := ValidateJSON(name, msg.Data)
+	if err == nil {
+		return nil
+	}
+	we := err.(*wafer.WaferError)
+	return wafer.ErrorWithMeta(we.Code, we.Message, we.Meta)
+}
+
+// validateRecord checks a serialized record against its collection's schema.
+// Fields that repositories store encrypted only hold ciphertexts here, so
+// they are left out; repositories validate their plaintext before sealing.
+func validateRecord(collection, data string) error {
+	return validateDocument(collection, []byte(data), func(v jsonschema.Violation) bool {
+		return isSealedField(collection, v.Pointer)
+	})
+}
+
+func hasSchema(collection string) bool {
+	_, ok := LookupSchema(collection)
+	return ok
+}
+
+func violationError(name string, violations []jsonschema.Violation) error {
+	meta := make(map[string]string, min(len(violations), MaxReportedViolations)+1)
+	for _, v := range violations[:min(len(violations), MaxReportedViolations)] {
+		key := "violation" + v.Pointer
+		if prev, ok := meta[key]; ok {
+			meta[key] = prev + "; " + v.Message
+		} else {
+			meta[key] = v.Message
+		}
+	}
+	meta["violations"] = strconv.Itoa(len(violations))
+	msg := "document does not match schema " + name + ": " + violations[0].String()
+	if len(violations) > 1 {
+		msg += " (and " + strconv.Itoa(len(violations)-1) + " more)"
+	}
+	return &wafer.WaferError{
+		Code:    "invalid_argument",
+		Message: msg,
+		Meta:    meta,
+	}
+}
@@ -0,0 +1,153 @@
+package services_test
+
+import (
+	"testing"
+
+	"github.com/wafer-run/wafer-sdk-go/gen/wafer/database"
+	. "github.com/wafer-run/wafer-sdk-go/services"
+)
+
+const userSchema = `{
+	"type": "object",
+	"required": ["email"],
+	"properties": {
+		"email": {"type": "string"},
+		"age": {"type": "integer", "minimum": 0}
+	}
+}`
+
+// registerSchema registers schema for collection for the duration of a test.
+func registerSchema(t *testing.T, collection, schema string) {
+	t.Helper()
+	if err := RegisterSchema(collection, []byte(schema)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { UnregisterSchema(collection) })
+}
+
+func TestDatabasePatchWithSchema(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   PatchFormat
+		patch    string
+		noSchema bool
+		wantErr  bool
+		wantHost bool
+		want     string
+	}{
+		{
+			name:     "without a schema the host patches",
+			format:   MergePatch,
+			patch:    `{"age":-1}`,
+			noSchema: true,
+			wantHost: true,
+			want:     `{"age":-1,"email":"a@example.com"}`,
+		},
+		{
+			name:   "valid merge patch is applied in the guest",
+			format: MergePatch,
+			patch:  `{"age":31}`,
+			want:   `{"age":31,"email":"a@example.com"}`,
+		},
+		{
+			name:    "invalid merge patch",
+			format:  MergePatch,
+			patch:   `{"age":-1}`,
+			wantErr: true,
+			want:    `{"age":30,"email":"a@example.com"}`,
+		},
+		{
+			name:    "json patch removing a required field",
+			format:  JSONPatch,
+			patch:   `[{"op":"remove","path":"/email"}]`,
+			wantErr: true,
+			want:    `{"age":30,"email":"a@example.com"}`,
+		},
+		{
+			name:    "root replacement",
+			format:  JSONPatch,
+			patch:   `[{"op":"replace","path":"","value":{"age":1}}]`,
+			wantErr: true,
+			want:    `{"age":30,"email":"a@example.com"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := setup(t)
+			if !tt.noSchema {
+				registerSchema(t, "users", userSchema)
+			}
+			hostCalls := 0
+			database.Patch = func(collection, id string, format database.PatchFormat, patch string) (database.DbRecord, error) {
+				hostCalls++
+				return db.Patch(collection, id, format, patch)
+			}
+			rec, err := DatabaseCreate("users", map[string]any{"email": "a@example.com", "age": 30})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = DatabasePatch("users", rec.ID, tt.format, []byte(tt.patch))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DatabasePatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (hostCalls > 0) != tt.wantHost {
+				t.Errorf("host patch calls = %d, want host %v", hostCalls, tt.wantHost)
+			}
+			got, err := DatabaseGet("users", rec.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Data != tt.want {
+				t.Errorf("stored %s, want %s", got.Data, tt.want)
+			}
+		})
+	}
+}
+
+func TestDatabaseUpdateManyWithSchema(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      map[string]any
+		noSchema  bool
+		wantHost  bool
+		wantFails int
+	}{
+		{name: "without a schema the host updates", data: map[string]any{"age": "old"}, noSchema: true, wantHost: true},
+		{name: "valid update is applied in the guest", data: map[string]any{"age": 40}},
+		{name: "invalid update", data: map[string]any{"age": "old"}, wantFails: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := setup(t)
+			if !tt.noSchema {
+				registerSchema(t, "users", userSchema)
+			}
+			hostCalls := 0
+			database.UpdateMany = func(collection string, filters []database.Filter, data string) ([]database.BatchItem, error) {
+				hostCalls++
+				return db.UpdateMany(collection, filters, data)
+			}
+			for _, email := range []string{"a@example.com", "b@example.com"} {
+				if _, err := DatabaseCreate("users", map[string]any{"email": email}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			results, err := DatabaseUpdateMany("users", nil, tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fails := 0
+			for _, r := range results {
+				if r.Err != nil {
+					fails++
+				}
+			}
+			if fails != tt.wantFails {
+				t.Errorf("%d failed items, want %d", fails, tt.wantFails)
+			}
+			if (hostCalls > 0) != tt.wantHost {
+				t.Errorf("host batch calls = %d, want host %v", hostCalls, tt.wantHost)
+			}
+		})
+	}
+}
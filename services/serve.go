@@ -0,0 +1,257 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+	"github.com/wafer-run/wafer-sdk-go/gen/wafer/storage"
+)
+
+// MaxServeRanges is the most ranges ServeObject honors in one request.
+// Requests for more are answered with the whole object.
+const MaxServeRanges = 32
+
+// ServeObject responds to msg with an object, the way a static file server
+// would. The response carries the object's content type, an ETag, a
+// Last-Modified date and "Accept-Ranges: bytes". If-None-Match and
+// If-Modified-Since are answered with 304 Not Modified, and Range requests
+// with 206 Partial Content; several ranges are returned as
+// multipart/byteranges. Only the requested ranges are read from storage.
+func ServeObject(msg *wafer.Message, folder, key string) *wafer.BlockResult {
+	info, err := StorageHead(folder, key)
+	if err != nil {
+		return storageErrorResult(err)
+	}
+	etag := ObjectETag(info)
+	modified := ParseLastModified(info.LastModified)
+
+	b := wafer.NewResponseBuilder().
+		Header("ETag", etag).
+		Header("Accept-Ranges", "bytes")
+	if !modified.IsZero() {
+		b.Header("Last-Modified", modified.UTC().Format(HTTPTimeFormat))
+	}
+	if notModified(msg, etag, modified) {
+		return b.Status(304).Respond()
+	}
+
+	ranges, ok := parseRange(msg.Header("Range"), info.Size)
+	if !ok || !rangeApplies(msg.Header("If-Range"), etag, modified) {
+		data, info, err := StorageGet(folder, key)
+		if err != nil {
+			return storageErrorResult(err)
+		}
+		return b.Status(200).Meta("content-type", info.ContentType).Data(data).Respond()
+	}
+	if len(ranges) == 0 {
+		return b.Status(416).Header("Content-Range", "bytes */"+strconv.FormatInt(info.Size, 10)).Respond()
+	}
+	return serveRanges(b, folder, key, info, ranges)
+}
+
+// ObjectETag returns a weak entity tag for an object, derived from its key,
+// size and modification time. The tag is weak because those do not pin the
+// content: two writes of the same size within the host's timestamp
+// resolution get the same tag. Weak tags still answer If-None-Match, but
+// never satisfy If-Range, so ranges are only served against a date.
+func ObjectETag(info ObjectInfo) string {
+	sum := sha256.Sum256([]byte(info.Key + "\x00" + strconv.FormatInt(info.Size, 10) + "\x00" + info.LastModified))
+	return `W/"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// byteRange is an inclusive-exclusive span of an object.
+type byteRange struct {
+	start, end int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return "bytes " + strconv.FormatInt(r.start, 10) + "-" + strconv.FormatInt(r.end-1, 10) + "/" + strconv.FormatInt(size, 10)
+}
+
+func serveRanges(b *wafer.ResponseBuilder, folder, key string, info ObjectInfo, ranges []byteRange) *wafer.BlockResult {
+	r, err := openStorageReader(folder, key)
+	if err != nil {
+		return storageErrorResult(err)
+	}
+	defer r.Close()
+	read := func(br byteRange) ([]byte, error) {
+		buf := make([]byte, br.end-br.start)
+		n, err := r.ReadAt(buf, br.start)
+		if n == len(buf) {
+			return buf, nil
+		}
+		if err == nil {
+			err = errors.New("storage: short read")
+		}
+		return nil, err
+	}
+
+	if len(ranges) == 1 {
+		data, err := read(ranges[0])
+		if err != nil {
+			return storageErrorResult(err)
+		}
+		return b.Status(206).
+			Header("Content-Range", ranges[0].contentRange(info.Size)).
+			Meta("content-type", info.ContentType).
+			Data(data).
+			Respond()
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, br := range ranges {
+		data, err := read(br)
+		if err != nil {
+			return storageErrorResult(err)
+		}
+		h := textproto.MIMEHeader{"Content-Range": {br.contentRange(info.Size)}}
+		if info.ContentType != "" {
+			h.Set("Content-Type", info.ContentType)
+		}
+		part, err := mw.CreatePart(h)
+		if err == nil {
+			_, err = part.Write(data)
+		}
+		if err != nil {
+			return wafer.ErrInternal("failed to write range: " + err.Error())
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return wafer.ErrInternal("failed to write range: " + err.Error())
+	}
+	return b.Status(206).
+		Meta("content-type", "multipart/byteranges; boundary="+mw.Boundary()).
+		Data(body.Bytes()).
+		Respond()
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since when no entity
+// tags were sent.
+func notModified(msg *wafer.Message, etag string, modified time.Time) bool {
+	if inm := msg.Header("If-None-Match"); inm != "" {
+		return etagListMatches(inm, etag)
+	}
+	ims := msg.Header("If-Modified-Since")
+	if ims == "" || modified.IsZero() {
+		return false
+	}
+	t, err := time.Parse(HTTPTimeFormat, ims)
+	if err != nil {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(t)
+}
+
+// etagListMatches reports whether a comma-separated If-None-Match list
+// contains etag, using weak comparison.
+func etagListMatches(list, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// rangeApplies evaluates If-Range: the range is only served when the
+// validator still matches the object. Entity tags must match strongly, which
+// weak tags never do.
+func rangeApplies(ifRange, etag string, modified time.Time) bool {
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, `W/`) {
+		return ifRange == etag && !strings.HasPrefix(etag, `W/`)
+	}
+	t, err := time.Parse(HTTPTimeFormat, ifRange)
+	return err == nil && !modified.IsZero() && modified.Truncate(time.Second).Equal(t)
+}
+
+// parseRange parses a Range header against an object of the given size. ok
+// is false when the header is absent, malformed, or not worth honoring, in
+// which case the whole object is served. An empty result with ok true means
+// no range is satisfiable.
+func parseRange(header string, size int64) (ranges []byteRange, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found {
+		return nil, false
+	}
+	var total int64
+	parts := 0
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		parts++
+		first, last, found := strings.Cut(part, "-")
+		if !found {
+			return nil, false
+		}
+		var br byteRange
+		if first == "" {
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, false
+			}
+			if n == 0 {
+				continue
+			}
+			br = byteRange{start: max(size-n, 0), end: size}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, false
+			}
+			end := size
+			if last != "" {
+				e, err := strconv.ParseInt(last, 10, 64)
+				if err != nil || e < start {
+					return nil, false
+				}
+				end = min(e+1, size)
+			}
+			if start >= size {
+				continue
+			}
+			br = byteRange{start: start, end: end}
+		}
+		ranges = append(ranges, br)
+		total += br.end - br.start
+	}
+	if parts == 0 || len(ranges) > MaxServeRanges || total > size {
+		return nil, false
+	}
+	return ranges, true
+}
+
+// storageErrorResult maps a storage error to an HTTP error result. Upload
+// policy violations keep their Meta.
+func storageErrorResult(err error) *wafer.BlockResult {
+	if errors.Is(err, storage.StorageErrorNotFound) {
+		return wafer.ErrorStatus(404, wafer.ErrorCodeNotFound, "object not found")
+	}
+	var we *wafer.WaferError
+	if errors.As(err, &we) && we.Code == wafer.ErrorCodeInvalidArgument {
+		meta := map[string]string{"resp.status": "400"}
+		for k, v := range we.Meta {
+			meta[k] = v
+		}
+		if we.Meta["reason"] == UploadTooLarge {
+			meta["resp.status"] = "413"
+		}
+		return wafer.ErrorWithMeta(we.Code, we.Message, meta)
+	}
+	return wafer.ErrorStatus(500, wafer.ErrorCodeInternal, "storage error: "+err.Error())
+}
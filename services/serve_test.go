@@ -0,0 +1,85 @@
+package services_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+	. "github.com/wafer-run/wafer-sdk-go/services"
+)
+
+func TestServeObject(t *testing.T) {
+	_, st := setup(t)
+	if err := st.Put("files", "a.txt", []byte("0123456789"), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := StorageHead("files", "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := ObjectETag(info)
+	modified := ParseLastModified(info.LastModified).UTC().Format(HTTPTimeFormat)
+	stale := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).Format(HTTPTimeFormat)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  string
+		body    string
+		multi   bool
+	}{
+		{name: "whole object", status: "200", body: "0123456789"},
+		{name: "if-none-match", headers: map[string]string{"If-None-Match": etag}, status: "304"},
+		{name: "if-none-match strong form", headers: map[string]string{"If-None-Match": strings.TrimPrefix(etag, "W/")}, status: "304"},
+		{name: "if-none-match other", headers: map[string]string{"If-None-Match": `"other"`}, status: "200", body: "0123456789"},
+		{name: "if-modified-since", headers: map[string]string{"If-Modified-Since": modified}, status: "304"},
+		{name: "single range", headers: map[string]string{"Range": "bytes=2-4"}, status: "206", body: "234"},
+		{name: "suffix range", headers: map[string]string{"Range": "bytes=-3"}, status: "206", body: "789"},
+		{name: "multiple ranges", headers: map[string]string{"Range": "bytes=0-1,8-"}, status: "206", multi: true},
+		{name: "unsatisfiable", headers: map[string]string{"Range": "bytes=20-"}, status: "416"},
+		{name: "malformed range", headers: map[string]string{"Range": "bytes=4-2"}, status: "200", body: "0123456789"},
+		{name: "if-range date", headers: map[string]string{"Range": "bytes=0-0", "If-Range": modified}, status: "206", body: "0"},
+		{name: "if-range stale date", headers: map[string]string{"Range": "bytes=0-0", "If-Range": stale}, status: "200", body: "0123456789"},
+		{name: "if-range weak etag", headers: map[string]string{"Range": "bytes=0-0", "If-Range": etag}, status: "200", body: "0123456789"},
+		{name: "if-range strong form of weak etag", headers: map[string]string{"Range": "bytes=0-0", "If-Range": strings.TrimPrefix(etag, "W/")}, status: "200", body: "0123456789"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &wafer.Message{}
+			for k, v := range tt.headers {
+				msg.SetMeta("http.header."+strings.ToLower(k), v)
+			}
+			r := ServeObject(msg, "files", "a.txt")
+			if got := status(r); got != tt.status {
+				t.Fatalf("status = %s, want %s", got, tt.status)
+			}
+			if got := r.Response.Meta["resp.header.ETag"]; got != etag {
+				t.Errorf("ETag = %q, want %q", got, etag)
+			}
+			if tt.multi {
+				ct := r.Response.Meta["content-type"]
+				if !strings.HasPrefix(ct, "multipart/byteranges; boundary=") {
+					t.Fatalf("content-type = %q", ct)
+				}
+				body := string(r.Response.Data)
+				for _, want := range []string{"bytes 0-1/10", "01", "bytes 8-9/10", "89"} {
+					if !strings.Contains(body, want) {
+						t.Errorf("body missing %q:\n%s", want, body)
+					}
+				}
+				return
+			}
+			if got := string(r.Response.Data); got != tt.body {
+				t.Errorf("body = %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
+
+func TestObjectETagIsWeak(t *testing.T) {
+	etag := ObjectETag(ObjectInfo{Key: "a", Size: 1, LastModified: "2024-01-01T00:00:00Z"})
+	if !strings.HasPrefix(etag, `W/"`) || !strings.HasSuffix(etag, `"`) {
+		t.Errorf("ObjectETag = %q, want a weak tag", etag)
+	}
+}
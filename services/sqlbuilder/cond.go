@@ -0,0 +1,170 @@
+package sqlbuilder
+
+// Cond is a WHERE, HAVING or JOIN ... ON condition.
+type Cond interface {
+	build(w *writer)
+}
+
+// compare is "<left> <op> <value>" where left is already rendered SQL.
+type compare struct {
+	left  string
+	op    string
+	value any
+}
+
+func (c compare) build(w *writer) {
+	w.write(c.left + " " + c.op + " ")
+	w.bind(c.value)
+}
+
+// Eq matches column = value.
+func Eq(column string, value any) Cond { return compare{Quote(column), "=", value} }
+
+// Neq matches column <> value.
+func Neq(column string, value any) Cond { return compare{Quote(column), "<>", value} }
+
+// Gt matches column > value.
+func Gt(column string, value any) Cond { return compare{Quote(column), ">", value} }
+
+// Gte matches column >= value.
+func Gte(column string, value any) Cond { return compare{Quote(column), ">=", value} }
+
+// Lt matches column < value.
+func Lt(column string, value any) Cond { return compare{Quote(column), "<", value} }
+
+// Lte matches column <= value.
+func Lte(column string, value any) Cond { return compare{Quote(column), "<=", value} }
+
+// Like matches column LIKE pattern.
+func Like(column string, pattern string) Cond { return compare{Quote(column), "LIKE", pattern} }
+
+type columnCompare struct {
+	left, op, right string
+}
+
+func (c columnCompare) build(w *writer) {
+	w.write(Quote(c.left) + " " + c.op + " " + Quote(c.right))
+}
+
+// ColumnEq matches two columns against each other, typically in a JOIN.
+func ColumnEq(left, right string) Cond { return columnCompare{left, "=", right} }
+
+type in struct {
+	left   string
+	values []any
+}
+
+func (c in) build(w *writer) {
+	if len(c.values) == 0 {
+		// An empty IN list is a syntax error in most databases.
+		w.write("1 = 0")
+		return
+	}
+	w.write(c.left + " IN (")
+	for i, v := range c.values {
+		if i > 0 {
+			w.write(", ")
+		}
+		w.bind(v)
+	}
+	w.write(")")
+}
+
+// In matches column IN (values...). An empty list matches nothing.
+func In(column string, values ...any) Cond { return in{Quote(column), values} }
+
+type null struct {
+	left string
+	not  bool
+}
+
+func (c null) build(w *writer) {
+	if c.not {
+		w.write(c.left + " IS NOT NULL")
+		return
+	}
+	w.write(c.left + " IS NULL")
+}
+
+// IsNull matches column IS NULL.
+func IsNull(column string) Cond { return null{left: Quote(column)} }
+
+// IsNotNull matches column IS NOT NULL.
+func IsNotNull(column string) Cond { return null{left: Quote(column), not: true} }
+
+type group struct {
+	op    string
+	conds []Cond
+}
+
+func (g group) build(w *writer) {
+	var conds []Cond
+	for _, c := range g.conds {
+		if c != nil {
+			conds = append(conds, c)
+		}
+	}
+	if len(conds) == 0 {
+		// Empty AND is true and empty OR is false, as in boolean algebra.
+		if g.op == " OR " {
+			w.write("1 = 0")
+		} else {
+			w.write("1 = 1")
+		}
+		return
+	}
+	w.write("(")
+	for i, c := range conds {
+		if i > 0 {
+			w.write(g.op)
+		}
+		c.build(w)
+	}
+	w.write(")")
+}
+
+// And matches when every condition matches. Nil conditions are ignored.
+func And(conds ...Cond) Cond { return group{" AND ", conds} }
+
+// Or matches when any condition matches. Nil conditions are ignored.
+func Or(conds ...Cond) Cond { return group{" OR ", conds} }
+
+type not struct {
+	cond Cond
+}
+
+func (c not) build(w *writer) {
+	w.write("NOT (")
+	c.cond.build(w)
+	w.write(")")
+}
+
+// Not negates a condition.
+func Not(c Cond) Cond { return not{c} }
+
+type raw struct {
+	sql  string
+	args []any
+}
+
+func (c raw) build(w *writer) {
+	w.write("(")
+	w.raw(c.sql, c.args)
+	w.write(")")
+}
+
+// Raw embeds a parenthesized SQL fragment with "?" placeholders bound to
+// args. The fragment is written verbatim, so it must never contain user
+// input.
+func Raw(sql string, args ...any) Cond { return raw{sql, args} }
+
+// andWith combines an existing condition with another using AND.
+func andWith(existing, c Cond) Cond {
+	if existing == nil {
+		return c
+	}
+	if c == nil {
+		return existing
+	}
+	return And(existing, c)
+}
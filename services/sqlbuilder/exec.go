@@ -0,0 +1,23 @@
+package sqlbuilder
+
+import (
+	"github.com/wafer-run/wafer-sdk-go/services"
+)
+
+// Query builds b and runs it with services.DatabaseQueryRaw.
+func Query(b Builder) ([]services.Record, error) {
+	q, args := b.Build()
+	return services.DatabaseQueryRaw(q, args...)
+}
+
+// Exec builds b and runs it with services.DatabaseExecRaw.
+func Exec(b Builder) (int64, error) {
+	q, args := b.Build()
+	return services.DatabaseExecRaw(q, args...)
+}
+
+// QueryInto builds b and maps the result rows onto T with services.QueryInto.
+func QueryInto[T any](b Builder) ([]T, error) {
+	q, args := b.Build()
+	return services.QueryInto[T](q, args...)
+}
@@ -0,0 +1,229 @@
+package sqlbuilder
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+	"github.com/wafer-run/wafer-sdk-go/services"
+)
+
+// FieldMapper renders a ListOptions field name as a SQL expression. The
+// result is written verbatim, so a mapper must quote whatever it embeds.
+type FieldMapper func(field string) string
+
+// Columns maps every field to the quoted column of the same name. It is the
+// mapper used when nil is passed.
+func Columns(field string) string {
+	return Quote(field)
+}
+
+// JSONField maps fields to json_extract lookups into a JSON document column,
+// for tables that store records as a data column the way collections do. The
+// "id" field maps to the id column. Dotted fields address nested values.
+func JSONField(column string) FieldMapper {
+	return func(field string) string {
+		if field == "id" {
+			return Quote("id")
+		}
+		var path strings.Builder
+		path.WriteString("$")
+		for _, part := range strings.Split(field, ".") {
+			part = strings.ReplaceAll(part, `\`, `\\`)
+			part = strings.ReplaceAll(part, `"`, `\"`)
+			path.WriteString(`."` + part + `"`)
+		}
+		return "json_extract(" + Quote(column) + ", '" + strings.ReplaceAll(path.String(), "'", "''") + "')"
+	}
+}
+
+// ListCond translates the Filters and Where tree of a ListOptions into an
+// equivalent condition. It returns nil when the options have no filters.
+func ListCond(opts services.ListOptions, fields FieldMapper) (Cond, error) {
+	if fields == nil {
+		fields = Columns
+	}
+	var conds []Cond
+	for _, f := range opts.Filters {
+		conds = append(conds, filterCond(f, fields))
+	}
+	if opts.Where != nil && len(opts.Where.Groups) > 0 {
+		c, err := treeCond(opts.Where, 0, 0, fields)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, c)
+	}
+	switch len(conds) {
+	case 0:
+		return nil, nil
+	case 1:
+		return conds[0], nil
+	default:
+		return And(conds...), nil
+	}
+}
+
+// ApplyListOptions adds the filters, sort order, limit, offset and, if the
+// builder has no columns yet, the selected fields of a ListOptions. Selected
+// fields are rendered with the mapper and, where that changes them, aliased
+// to the field name.
+func (b *SelectBuilder) ApplyListOptions(opts services.ListOptions, fields FieldMapper) (*SelectBuilder, error) {
+	if fields == nil {
+		fields = Columns
+	}
+	c, err := ListCond(opts, fields)
+	if err != nil {
+		return b, err
+	}
+	b.Where(c)
+	for _, s := range opts.Sort {
+		b.orders = append(b.orders, orderTerm(fields(s.Field), s.Desc))
+	}
+	if opts.Limit > 0 {
+		b.limit = opts.Limit
+	}
+	if opts.Offset > 0 {
+		b.offset = opts.Offset
+	}
+	if len(b.columns) == 0 {
+		for _, f := range opts.Select {
+			expr := fields(f)
+			if expr != Quote(f) {
+				expr += " AS " + `"` + strings.ReplaceAll(f, `"`, `""`) + `"`
+			}
+			b.columns = append(b.columns, expr)
+		}
+	}
+	return b, nil
+}
+
+// WhereClause renders the filters of a ListOptions as a "WHERE ..." clause
+// and its arguments. It returns an empty clause when there are no filters.
+func WhereClause(opts services.ListOptions, fields FieldMapper, dialect Dialect) (string, []any, error) {
+	c, err := ListCond(opts, fields)
+	if err != nil || c == nil {
+		return "", nil, err
+	}
+	w := &writer{dialect: dialect}
+	w.write("WHERE ")
+	c.build(w)
+	sql, args := w.result()
+	return sql, args, nil
+}
+
+// OrderByClause renders the sort order of a ListOptions as an "ORDER BY ..."
+// clause. It returns an empty string when there is no sort order.
+func OrderByClause(opts services.ListOptions, fields FieldMapper) string {
+	if len(opts.Sort) == 0 {
+		return ""
+	}
+	if fields == nil {
+		fields = Columns
+	}
+	terms := make([]string, len(opts.Sort))
+	for i, s := range opts.Sort {
+		terms[i] = orderTerm(fields(s.Field), s.Desc)
+	}
+	return "ORDER BY " + strings.Join(terms, ", ")
+}
+
+func filterCond(f services.Filter, fields FieldMapper) Cond {
+	left := fields(f.Field)
+	switch f.Operator {
+	case services.OpIsNull:
+		return null{left: left}
+	case services.OpIsNotNull:
+		return null{left: left, not: true}
+	}
+	v := filterArg(f.Value)
+	switch f.Operator {
+	case services.OpEqual:
+		if v == nil {
+			return null{left: left}
+		}
+		return compare{left, "=", v}
+	case services.OpNotEqual:
+		if v == nil {
+			return null{left: left, not: true}
+		}
+		// A missing value is not equal to anything, as in
+		// services.MatchRecord, whereas NULL <> v is never true in SQL.
+		return Or(compare{left, "<>", v}, null{left: left})
+	case services.OpGreater:
+		return compare{left, ">", v}
+	case services.OpGreaterEq:
+		return compare{left, ">=", v}
+	case services.OpLess:
+		return compare{left, "<", v}
+	case services.OpLessEq:
+		return compare{left, "<=", v}
+	case services.OpLike:
+		return compare{left, "LIKE", v}
+	case services.OpIn:
+		list, ok := v.([]any)
+		if !ok {
+			list = []any{v}
+		}
+		for i := range list {
+			list[i] = bindable(list[i])
+		}
+		return in{left, list}
+	default:
+		return Raw("1 = 0")
+	}
+}
+
+func treeCond(t *services.FilterTree, idx uint32, depth int, fields FieldMapper) (Cond, error) {
+	if int(idx) >= len(t.Groups) || depth > len(t.Groups) {
+		return nil, &wafer.WaferError{
+			Code:    "invalid_argument",
+			Message: "malformed filter tree",
+		}
+	}
+	g := t.Groups[idx]
+	var conds []Cond
+	for _, f := range g.Filters {
+		conds = append(conds, filterCond(f, fields))
+	}
+	for _, child := range g.Groups {
+		c, err := treeCond(t, child, depth+1, fields)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, c)
+	}
+	switch g.Op {
+	case services.GroupOr:
+		return Or(conds...), nil
+	case services.GroupNot:
+		return Not(And(conds...)), nil
+	default:
+		return And(conds...), nil
+	}
+}
+
+// filterArg decodes a JSON-encoded filter value into a value that can be
+// bound. Values that are not valid JSON are bound as plain strings.
+func filterArg(raw string) any {
+	var v any
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		return raw
+	}
+	return bindable(v)
+}
+
+// bindable turns integral floats into int64 and encodes objects as JSON text.
+func bindable(v any) any {
+	switch x := v.(type) {
+	case float64:
+		if x == math.Trunc(x) && math.Abs(x) < 1<<53 {
+			return int64(x)
+		}
+	case map[string]any:
+		b, _ := json.Marshal(x)
+		return string(b)
+	}
+	return v
+}
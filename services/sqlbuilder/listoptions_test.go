@@ -0,0 +1,62 @@
+package sqlbuilder
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/wafer-run/wafer-sdk-go/services"
+)
+
+func TestApplyListOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     services.ListOptions
+		fields   FieldMapper
+		wantSQL  string
+		wantArgs []any
+	}{
+		{
+			name:    "columns",
+			opts:    services.ListOptions{Select: []string{"id", "title"}, Sort: []services.SortField{{Field: "title", Desc: true}}, Limit: 10},
+			wantSQL: `SELECT "id", "title" FROM "posts" ORDER BY "title" DESC LIMIT 10`,
+		},
+		{
+			name:    "json fields are selected through the mapper",
+			opts:    services.ListOptions{Select: []string{"id", "author.name"}},
+			fields:  JSONField("data"),
+			wantSQL: `SELECT "id", json_extract("data", '$."author"."name"') AS "author.name" FROM "posts"`,
+		},
+		{
+			name:     "not equal matches missing values",
+			opts:     services.ListOptions{Filters: []services.Filter{{Field: "status", Operator: services.OpNotEqual, Value: `"draft"`}}},
+			wantSQL:  `SELECT * FROM "posts" WHERE ("status" <> ? OR "status" IS NULL)`,
+			wantArgs: []any{"draft"},
+		},
+		{
+			name:    "not equal null",
+			opts:    services.ListOptions{Filters: []services.Filter{{Field: "status", Operator: services.OpNotEqual, Value: "null"}}},
+			wantSQL: `SELECT * FROM "posts" WHERE "status" IS NOT NULL`,
+		},
+		{
+			name:     "quoted identifiers",
+			opts:     services.ListOptions{Filters: []services.Filter{{Field: `a"b`, Operator: services.OpEqual, Value: "1"}}},
+			wantSQL:  `SELECT * FROM "posts" WHERE "a""b" = ?`,
+			wantArgs: []any{int64(1)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := Select().From("posts").ApplyListOptions(tt.opts, tt.fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sql, args := b.Build()
+			if sql != tt.wantSQL {
+				t.Errorf("sql = %s\nwant  %s", sql, tt.wantSQL)
+			}
+			if !slices.Equal(args, tt.wantArgs) {
+				t.Errorf("args = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
@@ -0,0 +1,390 @@
+// Package sqlbuilder builds SQL statements for services.DatabaseQueryRaw and
+// services.DatabaseExecRaw without string concatenation. Identifiers are
+// always quoted and values are always bound as arguments.
+//
+//	q, args := sqlbuilder.Select("id", "title").
+//	    From("posts").
+//	    Where(sqlbuilder.And(
+//	        sqlbuilder.Eq("author_id", msg.UserID()),
+//	        sqlbuilder.IsNull("deleted_at"),
+//	    )).
+//	    OrderByDesc("created_at").
+//	    Limit(20).
+//	    Build()
+//	recs, err := services.DatabaseQueryRaw(q, args...)
+package sqlbuilder
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dialect selects the placeholder syntax for bound arguments.
+type Dialect int
+
+const (
+	// Question renders placeholders as "?" (SQLite). Identifiers are
+	// always quoted with double quotes, so MySQL needs the ANSI_QUOTES mode.
+	Question Dialect = iota
+	// Dollar renders placeholders as "$1", "$2", ... (PostgreSQL).
+	Dollar
+)
+
+// Builder is a statement that renders to SQL text and bound arguments.
+type Builder interface {
+	Build() (string, []any)
+}
+
+// Quote quotes an identifier with ANSI double quotes. Dotted names are quoted
+// per part, so "posts.id" becomes "posts"."id", and "*" is left as is.
+func Quote(ident string) string {
+	parts := strings.Split(ident, ".")
+	for i, p := range parts {
+		if p == "*" {
+			continue
+		}
+		parts[i] = `"` + strings.ReplaceAll(p, `"`, `""`) + `"`
+	}
+	return strings.Join(parts, ".")
+}
+
+// writer accumulates SQL text and arguments for one statement.
+type writer struct {
+	sb      strings.Builder
+	args    []any
+	dialect Dialect
+}
+
+func (w *writer) write(s string) {
+	w.sb.WriteString(s)
+}
+
+func (w *writer) bind(v any) {
+	w.args = append(w.args, v)
+	if w.dialect == Dollar {
+		w.sb.WriteString("$" + strconv.Itoa(len(w.args)))
+		return
+	}
+	w.sb.WriteByte('?')
+}
+
+// raw writes a SQL fragment, binding args to its "?" placeholders. Question
+// marks inside quoted strings and identifiers are left alone.
+func (w *writer) raw(sql string, args []any) {
+	next := 0
+	var quote byte
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?' && next < len(args):
+			w.bind(args[next])
+			next++
+			continue
+		}
+		w.sb.WriteByte(c)
+	}
+}
+
+func (w *writer) columns(cols []string) {
+	for i, c := range cols {
+		if i > 0 {
+			w.write(", ")
+		}
+		w.write(Quote(c))
+	}
+}
+
+func (w *writer) result() (string, []any) {
+	return w.sb.String(), w.args
+}
+
+// orderTerm renders an ORDER BY term for an already rendered expression.
+func orderTerm(expr string, desc bool) string {
+	if desc {
+		return expr + " DESC"
+	}
+	return expr
+}
+
+func writeWhere(w *writer, where Cond) {
+	if where == nil {
+		return
+	}
+	w.write(" WHERE ")
+	where.build(w)
+}
+
+// join is a JOIN clause of a SELECT.
+type join struct {
+	kind  string
+	table string
+	on    Cond
+}
+
+// SelectBuilder builds a SELECT statement.
+type SelectBuilder struct {
+	dialect Dialect
+	columns []string // rendered
+	table   string
+	joins   []join
+	where   Cond
+	groupBy []string
+	having  Cond
+	orders  []string
+	limit   int64
+	offset  int64
+}
+
+// Select starts a SELECT of the given columns. No columns selects "*".
+func Select(columns ...string) *SelectBuilder {
+	b := &SelectBuilder{}
+	for _, c := range columns {
+		b.columns = append(b.columns, Quote(c))
+	}
+	return b
+}
+
+// Dialect sets the placeholder syntax.
+func (b *SelectBuilder) Dialect(d Dialect) *SelectBuilder {
+	b.dialect = d
+	return b
+}
+
+// From sets the table to select from.
+func (b *SelectBuilder) From(table string) *SelectBuilder {
+	b.table = table
+	return b
+}
+
+// Join adds an INNER JOIN.
+func (b *SelectBuilder) Join(table string, on Cond) *SelectBuilder {
+	b.joins = append(b.joins, join{kind: "JOIN", table: table, on: on})
+	return b
+}
+
+// LeftJoin adds a LEFT JOIN.
+func (b *SelectBuilder) LeftJoin(table string, on Cond) *SelectBuilder {
+	b.joins = append(b.joins, join{kind: "LEFT JOIN", table: table, on: on})
+	return b
+}
+
+// Where adds a condition. Repeated calls are combined with AND.
+func (b *SelectBuilder) Where(c Cond) *SelectBuilder {
+	b.where = andWith(b.where, c)
+	return b
+}
+
+// GroupBy adds GROUP BY columns.
+func (b *SelectBuilder) GroupBy(columns ...string) *SelectBuilder {
+	b.groupBy = append(b.groupBy, columns...)
+	return b
+}
+
+// Having adds a HAVING condition. Repeated calls are combined with AND.
+func (b *SelectBuilder) Having(c Cond) *SelectBuilder {
+	b.having = andWith(b.having, c)
+	return b
+}
+
+// OrderBy adds an ascending ORDER BY term.
+func (b *SelectBuilder) OrderBy(column string) *SelectBuilder {
+	b.orders = append(b.orders, orderTerm(Quote(column), false))
+	return b
+}
+
+// OrderByDesc adds a descending ORDER BY term.
+func (b *SelectBuilder) OrderByDesc(column string) *SelectBuilder {
+	b.orders = append(b.orders, orderTerm(Quote(column), true))
+	return b
+}
+
+// Limit sets LIMIT. Zero means no limit.
+func (b *SelectBuilder) Limit(n int64) *SelectBuilder {
+	b.limit = n
+	return b
+}
+
+// Offset sets OFFSET.
+func (b *SelectBuilder) Offset(n int64) *SelectBuilder {
+	b.offset = n
+	return b
+}
+
+// Build renders the statement.
+func (b *SelectBuilder) Build() (string, []any) {
+	w := &writer{dialect: b.dialect}
+	w.write("SELECT ")
+	if len(b.columns) == 0 {
+		w.write("*")
+	} else {
+		w.write(strings.Join(b.columns, ", "))
+	}
+	if b.table != "" {
+		w.write(" FROM " + Quote(b.table))
+	}
+	for _, j := range b.joins {
+		w.write(" " + j.kind + " " + Quote(j.table))
+		if j.on != nil {
+			w.write(" ON ")
+			j.on.build(w)
+		}
+	}
+	writeWhere(w, b.where)
+	if len(b.groupBy) > 0 {
+		w.write(" GROUP BY ")
+		w.columns(b.groupBy)
+	}
+	if b.having != nil {
+		w.write(" HAVING ")
+		b.having.build(w)
+	}
+	if len(b.orders) > 0 {
+		w.write(" ORDER BY " + strings.Join(b.orders, ", "))
+	}
+	if b.limit > 0 {
+		w.write(" LIMIT " + strconv.FormatInt(b.limit, 10))
+	}
+	if b.offset > 0 {
+		w.write(" OFFSET " + strconv.FormatInt(b.offset, 10))
+	}
+	return w.result()
+}
+
+// InsertBuilder builds an INSERT statement.
+type InsertBuilder struct {
+	dialect Dialect
+	table   string
+	columns []string
+	rows    [][]any
+}
+
+// InsertInto starts an INSERT into table.
+func InsertInto(table string) *InsertBuilder {
+	return &InsertBuilder{table: table}
+}
+
+// Dialect sets the placeholder syntax.
+func (b *InsertBuilder) Dialect(d Dialect) *InsertBuilder {
+	b.dialect = d
+	return b
+}
+
+// Columns sets the inserted columns.
+func (b *InsertBuilder) Columns(columns ...string) *InsertBuilder {
+	b.columns = columns
+	return b
+}
+
+// Values adds a row of values, one per column.
+func (b *InsertBuilder) Values(values ...any) *InsertBuilder {
+	b.rows = append(b.rows, values)
+	return b
+}
+
+// Build renders the statement.
+func (b *InsertBuilder) Build() (string, []any) {
+	w := &writer{dialect: b.dialect}
+	w.write("INSERT INTO " + Quote(b.table) + " (")
+	w.columns(b.columns)
+	w.write(") VALUES ")
+	for i, row := range b.rows {
+		if i > 0 {
+			w.write(", ")
+		}
+		w.write("(")
+		for j, v := range row {
+			if j > 0 {
+				w.write(", ")
+			}
+			w.bind(v)
+		}
+		w.write(")")
+	}
+	return w.result()
+}
+
+// UpdateBuilder builds an UPDATE statement.
+type UpdateBuilder struct {
+	dialect Dialect
+	table   string
+	columns []string
+	values  []any
+	where   Cond
+}
+
+// Update starts an UPDATE of table.
+func Update(table string) *UpdateBuilder {
+	return &UpdateBuilder{table: table}
+}
+
+// Dialect sets the placeholder syntax.
+func (b *UpdateBuilder) Dialect(d Dialect) *UpdateBuilder {
+	b.dialect = d
+	return b
+}
+
+// Set assigns a value to a column.
+func (b *UpdateBuilder) Set(column string, value any) *UpdateBuilder {
+	b.columns = append(b.columns, column)
+	b.values = append(b.values, value)
+	return b
+}
+
+// Where adds a condition. Repeated calls are combined with AND.
+func (b *UpdateBuilder) Where(c Cond) *UpdateBuilder {
+	b.where = andWith(b.where, c)
+	return b
+}
+
+// Build renders the statement.
+func (b *UpdateBuilder) Build() (string, []any) {
+	w := &writer{dialect: b.dialect}
+	w.write("UPDATE " + Quote(b.table) + " SET ")
+	for i, c := range b.columns {
+		if i > 0 {
+			w.write(", ")
+		}
+		w.write(Quote(c) + " = ")
+		w.bind(b.values[i])
+	}
+	writeWhere(w, b.where)
+	return w.result()
+}
+
+// DeleteBuilder builds a DELETE statement.
+type DeleteBuilder struct {
+	dialect Dialect
+	table   string
+	where   Cond
+}
+
+// DeleteFrom starts a DELETE from table.
+func DeleteFrom(table string) *DeleteBuilder {
+	return &DeleteBuilder{table: table}
+}
+
+// Dialect sets the placeholder syntax.
+func (b *DeleteBuilder) Dialect(d Dialect) *DeleteBuilder {
+	b.dialect = d
+	return b
+}
+
+// Where adds a condition. Repeated calls are combined with AND.
+func (b *DeleteBuilder) Where(c Cond) *DeleteBuilder {
+	b.where = andWith(b.where, c)
+	return b
+}
+
+// Build renders the statement.
+func (b *DeleteBuilder) Build() (string, []any) {
+	w := &writer{dialect: b.dialect}
+	w.write("DELETE FROM " + Quote(b.table))
+	writeWhere(w, b.where)
+	return w.result()
+}
@@ -1,6 +1,12 @@
 package services
 
 import (
+	"errors"
+	"io"
+	"sort"
+	"time"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
 	"github.com/wafer-run/wafer-sdk-go/gen/wafer/storage"
 )
 
@@ -10,17 +16,126 @@ type ObjectInfo = storage.ObjectInfo
 // ObjectList is a convenience alias for the WIT-generated ObjectList.
 type ObjectList = storage.ObjectList
 
-// StoragePut stores content in a folder under the given key.
+// StoragePut stores content in a folder under the given key. If the folder
+// has an upload policy, content that violates it is rejected.
 func StoragePut(folder, key string, data []byte, contentType string) error {
+	if err := CheckUpload(folder, data, contentType); err != nil {
+		return err
+	}
 	return storage.Put(folder, key, data, contentType)
 }
 
+// StoragePutWithMetadata stores content together with user-defined metadata,
+// which is returned in the object's ObjectInfo. Hosts may normalize metadata
+// keys to lower case.
+func StoragePutWithMetadata(folder, key string, data []byte, contentType string, metadata map[string]string) error {
+	if err := CheckUpload(folder, data, contentType); err != nil {
+		return err
+	}
+	return putObject(folder, key, data, contentType, metadataEntries(metadata))
+}
+
 // StorageGet retrieves content from a folder by key. Returns the data and
 // object metadata.
 func StorageGet(folder, key string) ([]byte, ObjectInfo, error) {
 	return storage.Get(folder, key)
 }
 
+// StorageHead returns an object's metadata without its content. When the
+// host has no head operation an empty range is read instead, or as a last
+// resort the whole object.
+func StorageHead(folder, key string) (ObjectInfo, error) {
+	if storage.Head != nil {
+		info, err := storage.Head(folder, key)
+		if !isStorageUnsupported(err) {
+			return info, err
+		}
+	}
+	if storage.GetRange != nil {
+		_, info, err := storage.GetRange(folder, key, 0, 0)
+		if !isStorageUnsupported(err) {
+			return info, err
+		}
+	}
+	_, info, err := storage.Get(folder, key)
+	return info, err
+}
+
+// StorageExists reports whether an object exists.
+func StorageExists(folder, key string) (bool, error) {
+	_, err := StorageHead(folder, key)
+	if errors.Is(err, storage.StorageErrorNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// StorageCopy copies an object, including its content type and metadata, to
+// another key, possibly in another folder, subject to the destination
+// folder's upload policy. The copy is made by the host when it supports it,
+// and streamed through the guest otherwise.
+func StorageCopy(srcFolder, srcKey, dstFolder, dstKey string) (ObjectInfo, error) {
+	if srcFolder == dstFolder && srcKey == dstKey {
+		return StorageHead(srcFolder, srcKey)
+	}
+	if err := checkStoredUpload(srcFolder, srcKey, dstFolder); err != nil {
+		return ObjectInfo{}, err
+	}
+	if storage.Copy != nil {
+		info, err := storage.Copy(srcFolder, srcKey, dstFolder, dstKey)
+		if !isStorageUnsupported(err) {
+			return info, err
+		}
+	}
+	return streamCopy(srcFolder, srcKey, dstFolder, dstKey)
+}
+
+// StorageMove moves an object to another key, possibly in another folder.
+// Without host support it is a StorageCopy followed by deleting the source.
+func StorageMove(srcFolder, srcKey, dstFolder, dstKey string) (ObjectInfo, error) {
+	if srcFolder == dstFolder && srcKey == dstKey {
+		return StorageHead(srcFolder, srcKey)
+	}
+	if err := checkStoredUpload(srcFolder, srcKey, dstFolder); err != nil {
+		return ObjectInfo{}, err
+	}
+	if storage.Move != nil {
+		info, err := storage.Move(srcFolder, srcKey, dstFolder, dstKey)
+		if !isStorageUnsupported(err) {
+			return info, err
+		}
+	}
+	info, err := StorageCopy(srcFolder, srcKey, dstFolder, dstKey)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return info, storage.Delete(srcFolder, srcKey)
+}
+
+// ObjectMetadata returns the user-defined metadata of an object as a map.
+func ObjectMetadata(info ObjectInfo) map[string]string {
+	out := make(map[string]string, len(info.Metadata))
+	for _, e := range info.Metadata {
+		out[e.Key] = e.Value
+	}
+	return out
+}
+
+// HTTPTimeFormat is the IMF-fixdate layout of HTTP date headers.
+const HTTPTimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// ParseLastModified parses ObjectInfo.LastModified, which hosts report as
+// RFC 3339 or HTTP date strings. It returns the zero time if the value
+// cannot be parsed.
+func ParseLastModified(s string) time.Time {
+	for _, layout := range []string{time.RFC3339Nano, HTTPTimeFormat, time.RFC1123Z, time.RFC1123} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
 // StorageDelete removes content from a folder by key.
 func StorageDelete(folder, key string) error {
 	return storage.Delete(folder, key)
@@ -35,3 +150,54 @@ func StorageList(folder, prefix string, limit, offset int64) (ObjectList, error)
 func StorageListAll(folder string) (ObjectList, error) {
 	return storage.List(folder, "", 0, 0)
 }
+
+func putObject(folder, key string, data []byte, contentType string, metadata []storage.MetadataEntry) error {
+	if len(metadata) == 0 {
+		return storage.Put(folder, key, data, contentType)
+	}
+	if storage.PutWithMetadata != nil {
+		err := storage.PutWithMetadata(folder, key, data, contentType, metadata)
+		if !isStorageUnsupported(err) {
+			return err
+		}
+	}
+	return &wafer.WaferError{
+		Code:    "unimplemented",
+		Message: "the host does not support storage object metadata",
+	}
+}
+
+func streamCopy(srcFolder, srcKey, dstFolder, dstKey string) (ObjectInfo, error) {
+	r, err := openStorageReader(srcFolder, srcKey)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer r.Close()
+	w := &StorageWriter{
+		folder:      dstFolder,
+		key:         dstKey,
+		contentType: r.info.ContentType,
+		metadata:    r.info.Metadata,
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Abort()
+		return ObjectInfo{}, err
+	}
+	if err := w.Close(); err != nil {
+		return ObjectInfo{}, err
+	}
+	return w.Info(), nil
+}
+
+// metadataEntries converts a metadata map to entries sorted by key.
+func metadataEntries(metadata map[string]string) []storage.MetadataEntry {
+	if len(metadata) == 0 {
+		return nil
+	}
+	out := make([]storage.MetadataEntry, 0, len(metadata))
+	for k, v := range metadata {
+		out = append(out, storage.MetadataEntry{Key: k, Value: v})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
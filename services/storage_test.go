@@ -0,0 +1,31 @@
+package services_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/wafer-run/wafer-sdk-go/services"
+)
+
+func TestParseLastModified(t *testing.T) {
+	want := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	tests := []struct {
+		name string
+		in   string
+		want time.Time
+	}{
+		{name: "rfc3339", in: "2026-03-04T05:06:07Z", want: want},
+		{name: "rfc3339 fraction", in: "2026-03-04T05:06:07.250Z", want: want.Add(250 * time.Millisecond)},
+		{name: "http date", in: "Wed, 04 Mar 2026 05:06:07 GMT", want: want},
+		{name: "rfc1123z", in: "Wed, 04 Mar 2026 07:06:07 +0200", want: want},
+		{name: "empty"},
+		{name: "garbage", in: "yesterday"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseLastModified(tt.in); !got.Equal(tt.want) {
+				t.Errorf("ParseLastModified(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
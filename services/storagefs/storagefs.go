@@ -0,0 +1,302 @@
+// Package storagefs exposes a storage folder as an io/fs file system, so
+// standard library consumers such as template.ParseFS, http.FS and
+// fs.WalkDir can read runtime storage. Keys are treated as slash-separated
+// paths: "img/logo.png" is the file "logo.png" in the directory "img".
+// Directories exist implicitly whenever some key lies beneath them.
+//
+//	tmpl, err := template.ParseFS(storagefs.New("templates"), "*.html")
+package storagefs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wafer-run/wafer-sdk-go/gen/wafer/storage"
+	"github.com/wafer-run/wafer-sdk-go/services"
+)
+
+// listPageSize is the number of objects requested per StorageList call.
+const listPageSize = 1000
+
+// FS is a read-only file system over one storage folder. It implements
+// fs.FS, fs.ReadDirFS, fs.ReadFileFS, fs.StatFS and fs.SubFS.
+type FS struct {
+	folder string
+	prefix string
+}
+
+// New returns a file system over folder.
+func New(folder string) *FS {
+	return &FS{folder: folder}
+}
+
+// Open implements fs.FS. Files are read lazily in chunks, so opening a
+// large object does not load it into memory.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	info, err := fsys.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &dir{fsys: fsys, name: name, info: info}, nil
+	}
+	return &file{fsys: fsys, name: name, info: info}, nil
+}
+
+// Stat implements fs.StatFS.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	return fsys.stat("stat", name)
+}
+
+// ReadFile implements fs.ReadFileFS.
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrInvalid}
+	}
+	data, _, err := services.StorageGet(fsys.folder, fsys.key(name))
+	if err != nil {
+		if errors.Is(err, storage.StorageErrorNotFound) {
+			if _, serr := fsys.stat("readfile", name); serr == nil {
+				return nil, &fs.PathError{Op: "readfile", Path: name, Err: errors.New("is a directory")}
+			}
+		}
+		return nil, pathError("readfile", name, err)
+	}
+	return data, nil
+}
+
+// ReadDir implements fs.ReadDirFS. Entries are sorted by name.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	prefix := fsys.dirPrefix(name)
+	entries := make(map[string]fs.DirEntry)
+	for offset := int64(0); ; offset += listPageSize {
+		list, err := services.StorageList(fsys.folder, prefix, listPageSize, offset)
+		if err != nil {
+			return nil, pathError("readdir", name, err)
+		}
+		for _, obj := range list.Objects {
+			rest, ok := strings.CutPrefix(obj.Key, prefix)
+			if !ok || !fs.ValidPath(rest) {
+				continue
+			}
+			if child, _, isDir := strings.Cut(rest, "/"); isDir {
+				if _, seen := entries[child]; !seen {
+					entries[child] = fs.FileInfoToDirEntry(dirInfo(child))
+				}
+			} else {
+				entries[rest] = fs.FileInfoToDirEntry(fileInfo{name: rest, obj: obj})
+			}
+		}
+		if len(list.Objects) < listPageSize {
+			break
+		}
+	}
+	if len(entries) == 0 && name != "." {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	out := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name() < out[j].Name() })
+	return out, nil
+}
+
+// Sub implements fs.SubFS.
+func (fsys *FS) Sub(dir string) (fs.FS, error) {
+	if !fs.ValidPath(dir) {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: fs.ErrInvalid}
+	}
+	if dir == "." {
+		return fsys, nil
+	}
+	return &FS{folder: fsys.folder, prefix: fsys.key(dir) + "/"}, nil
+}
+
+// stat resolves name to a file or, failing that, an implicit directory.
+func (fsys *FS) stat(op, name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return dirInfo("."), nil
+	}
+	obj, err := services.StorageHead(fsys.folder, fsys.key(name))
+	if err == nil {
+		return fileInfo{name: path.Base(name), obj: obj}, nil
+	}
+	if !errors.Is(err, storage.StorageErrorNotFound) {
+		return nil, pathError(op, name, err)
+	}
+	list, err := services.StorageList(fsys.folder, fsys.dirPrefix(name), 1, 0)
+	if err != nil {
+		return nil, pathError(op, name, err)
+	}
+	if len(list.Objects) == 0 {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return dirInfo(path.Base(name)), nil
+}
+
+func (fsys *FS) key(name string) string {
+	return fsys.prefix + name
+}
+
+func (fsys *FS) dirPrefix(name string) string {
+	if name == "." {
+		return fsys.prefix
+	}
+	return fsys.prefix + name + "/"
+}
+
+// file is an open object. Content is fetched on first read.
+type file struct {
+	fsys   *FS
+	name   string
+	info   fs.FileInfo
+	r      *services.StorageReader
+	closed bool
+}
+
+func (f *file) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *file) Read(p []byte) (int, error) {
+	r, err := f.reader("read")
+	if err != nil {
+		return 0, err
+	}
+	return r.Read(p)
+}
+
+// ReadAt implements io.ReaderAt.
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	r, err := f.reader("read")
+	if err != nil {
+		return 0, err
+	}
+	return r.ReadAt(p, off)
+}
+
+// Seek implements io.Seeker, which http.FileServer needs for range requests.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	r, err := f.reader("seek")
+	if err != nil {
+		return 0, err
+	}
+	return r.Seek(offset, whence)
+}
+
+func (f *file) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	if f.r != nil {
+		return f.r.Close()
+	}
+	return nil
+}
+
+func (f *file) reader(op string) (*services.StorageReader, error) {
+	if f.closed {
+		return nil, &fs.PathError{Op: op, Path: f.name, Err: fs.ErrClosed}
+	}
+	if f.r == nil {
+		rc, _, err := services.StorageOpenReader(f.fsys.folder, f.fsys.key(f.name))
+		if err != nil {
+			return nil, pathError(op, f.name, err)
+		}
+		f.r = rc.(*services.StorageReader)
+	}
+	return f.r, nil
+}
+
+// dir is an open directory. Entries are listed on the first ReadDir call.
+type dir struct {
+	fsys    *FS
+	name    string
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	listed  bool
+	closed  bool
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return d.info, nil }
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *dir) Close() error {
+	if d.closed {
+		return &fs.PathError{Op: "close", Path: d.name, Err: fs.ErrClosed}
+	}
+	d.closed = true
+	return nil
+}
+
+// ReadDir implements fs.ReadDirFile.
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: fs.ErrClosed}
+	}
+	if !d.listed {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.listed = entries, true
+	}
+	if n <= 0 {
+		out := d.entries
+		d.entries = nil
+		return out, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	out := d.entries[:n]
+	d.entries = d.entries[n:]
+	return out, nil
+}
+
+// fileInfo describes an object.
+type fileInfo struct {
+	name string
+	obj  services.ObjectInfo
+}
+
+func (fi fileInfo) Name() string       { return path.Base(fi.name) }
+func (fi fileInfo) Size() int64        { return fi.obj.Size }
+func (fi fileInfo) Mode() fs.FileMode  { return 0o444 }
+func (fi fileInfo) ModTime() time.Time { return services.ParseLastModified(fi.obj.LastModified) }
+func (fi fileInfo) IsDir() bool        { return false }
+
+// Sys returns the object's services.ObjectInfo.
+func (fi fileInfo) Sys() any { return fi.obj }
+
+// dirInfo describes an implicit directory.
+type dirInfo string
+
+func (di dirInfo) Name() string       { return string(di) }
+func (di dirInfo) Size() int64        { return 0 }
+func (di dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0o555 }
+func (di dirInfo) ModTime() time.Time { return time.Time{} }
+func (di dirInfo) IsDir() bool        { return true }
+func (di dirInfo) Sys() any           { return nil }
+
+func pathError(op, name string, err error) error {
+	if errors.Is(err, storage.StorageErrorNotFound) {
+		err = fs.ErrNotExist
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
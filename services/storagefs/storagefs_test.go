@@ -0,0 +1,74 @@
+package storagefs_test
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/wafer-run/wafer-sdk-go/services"
+	"github.com/wafer-run/wafer-sdk-go/services/fakes"
+	"github.com/wafer-run/wafer-sdk-go/services/storagefs"
+)
+
+// setup installs a fake storage holding files.
+func setup(t *testing.T, files map[string]string) {
+	t.Helper()
+	st := fakes.NewStorage()
+	st.Install()
+	for key, data := range files {
+		st.Put("site", key, []byte(data), "text/plain")
+	}
+}
+
+func TestFS(t *testing.T) {
+	setup(t, map[string]string{
+		"index.html":        "<h1>hi</h1>",
+		"img/logo.png":      "png",
+		"img/icons/a.svg":   "<svg/>",
+		"docs/guide/one.md": strings.Repeat("x", services.StorageChunkSize+100), // read in two chunks
+		"empty.txt":         "",
+	})
+	fsys := storagefs.New("site")
+	if err := fstest.TestFS(fsys, "index.html", "img/logo.png", "img/icons/a.svg", "docs/guide/one.md", "empty.txt"); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := fs.Sub(fsys, "img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(sub, "logo.png", "icons/a.svg"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFSErrors(t *testing.T) {
+	setup(t, map[string]string{"img/logo.png": "png"})
+	fsys := storagefs.New("site")
+	tests := []struct {
+		name string
+		op   func() error
+		want error
+	}{
+		{name: "open missing", op: func() error { _, err := fsys.Open("missing"); return err }, want: fs.ErrNotExist},
+		{name: "open invalid", op: func() error { _, err := fsys.Open("../x"); return err }, want: fs.ErrInvalid},
+		{name: "stat missing", op: func() error { _, err := fsys.Stat("img/missing.png"); return err }, want: fs.ErrNotExist},
+		{name: "read missing", op: func() error { _, err := fsys.ReadFile("missing"); return err }, want: fs.ErrNotExist},
+		{name: "read directory", op: func() error { _, err := fsys.ReadFile("img"); return err }},
+		{name: "readdir missing", op: func() error { _, err := fsys.ReadDir("missing"); return err }, want: fs.ErrNotExist},
+		{name: "sub invalid", op: func() error { _, err := fsys.Sub("/img"); return err }, want: fs.ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.op()
+			var pe *fs.PathError
+			if !errors.As(err, &pe) {
+				t.Fatalf("error = %v, want a *fs.PathError", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"errors"
+	"io"
+
+	"github.com/wafer-run/wafer-sdk-go/gen/wafer/storage"
+)
+
+// StorageChunkSize is the size of each host call made by StorageReader and
+// StorageWriter, and so the most object data either holds in memory.
+const StorageChunkSize = 1 << 20
+
+// StorageMaxBuffered is the most a StorageWriter holds in memory when the
+// host does not support chunked uploads. Writing more fails with
+// storage.StorageErrorUnsupported.
+const StorageMaxBuffered = 32 << 20
+
+// StorageReader reads an object in StorageChunkSize ranges. It implements
+// io.ReadSeekCloser and io.ReaderAt.
+type StorageReader struct {
+	folder string
+	key    string
+	info   ObjectInfo
+	off    int64
+
+	// chunk holds the range starting at chunkOff; whole holds the entire
+	// object when the host cannot serve ranges.
+	chunk    []byte
+	chunkOff int64
+	whole    []byte
+}
+
+// StorageOpenReader opens an object for streaming reads. When the host does
+// not support ranged reads the object is fetched with a single StorageGet.
+func StorageOpenReader(folder, key string) (io.ReadCloser, ObjectInfo, error) {
+	r, err := openStorageReader(folder, key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	return r, r.info, nil
+}
+
+func openStorageReader(folder, key string) (*StorageReader, error) {
+	r := &StorageReader{folder: folder, key: key}
+	if storage.GetRange != nil {
+		data, info, err := storage.GetRange(folder, key, 0, StorageChunkSize)
+		if !isStorageUnsupported(err) {
+			if err != nil {
+				return nil, err
+			}
+			r.info, r.chunk = info, data
+			return r, nil
+		}
+	}
+	data, info, err := storage.Get(folder, key)
+	if err != nil {
+		return nil, err
+	}
+	r.info, r.whole = info, data
+	return r, nil
+}
+
+// Info returns the metadata of the object being read.
+func (r *StorageReader) Info() ObjectInfo {
+	return r.info
+}
+
+// Read implements io.Reader.
+func (r *StorageReader) Read(p []byte) (int, error) {
+	if r.off >= r.info.Size {
+		return 0, io.EOF
+	}
+	if r.whole != nil {
+		n := copy(p, r.whole[r.off:])
+		r.off += int64(n)
+		return n, nil
+	}
+	if r.off < r.chunkOff || r.off >= r.chunkOff+int64(len(r.chunk)) {
+		data, _, err := storage.GetRange(r.folder, r.key, r.off, StorageChunkSize)
+		if err != nil {
+			return 0, err
+		}
+		if len(data) == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		r.chunk, r.chunkOff = data, r.off
+	}
+	n := copy(p, r.chunk[r.off-r.chunkOff:])
+	r.off += int64(n)
+	return n, nil
+}
+
+// ReadAt implements io.ReaderAt. It does not move the read offset.
+func (r *StorageReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("storage: negative offset")
+	}
+	if off >= r.info.Size {
+		return 0, io.EOF
+	}
+	if r.whole != nil {
+		n := copy(p, r.whole[off:])
+		if n < len(p) {
+			return n, io.EOF
+		}
+		return n, nil
+	}
+	n := 0
+	for n < len(p) && off+int64(n) < r.info.Size {
+		want := min(int64(len(p)-n), StorageChunkSize)
+		data, _, err := storage.GetRange(r.folder, r.key, off+int64(n), want)
+		if err != nil {
+			return n, err
+		}
+		if len(data) == 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+		n += copy(p[n:], data)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Seek implements io.Seeker.
+func (r *StorageReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.info.Size
+	default:
+		return 0, errors.New("storage: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("storage: negative position")
+	}
+	r.off = offset
+	return offset, nil
+}
+
+// Close releases the buffered data.
+func (r *StorageReader) Close() error {
+	r.chunk, r.whole = nil, nil
+	r.off = r.info.Size
+	return nil
+}
+
+// StorageWriter writes an object in StorageChunkSize chunks. Nothing is
+// visible in storage until Close succeeds.
+type StorageWriter struct {
+	folder      string
+	key         string
+	contentType string
+	metadata    []storage.MetadataEntry
+	check       *uploadCheck
+
+	upload   string
+	buffered bool // the host has no chunked uploads; keep everything for Close
+	buf      []byte
+	info     ObjectInfo
+	err      error
+	closed   bool
+}
+
+// StorageCreateWriter returns a writer that stores everything written to it
+// as one object once closed. Objects that fit in one chunk are stored with a
+// single StoragePut. When the host does not support chunked uploads the whole
+// object is buffered, up to StorageMaxBuffered, and stored on Close. The
+// folder's upload policy is applied as data is written. The writer is a
+// *StorageWriter, whose Abort method discards a partial upload.
+func StorageCreateWriter(folder, key, contentType string) io.WriteCloser {
+	return &StorageWriter{folder: folder, key: key, contentType: contentType, check: newUploadCheck(folder, contentType)}
+}
+
+// StorageCreateWriterWithMetadata is StorageCreateWriter with user-defined
+// metadata stored on the object.
+func StorageCreateWriterWithMetadata(folder, key, contentType string, metadata map[string]string) io.WriteCloser {
+	return &StorageWriter{
+		folder:      folder,
+		key:         key,
+		contentType: contentType,
+		metadata:    metadataEntries(metadata),
+		check:       newUploadCheck(folder, contentType),
+	}
+}
+
+// Write implements io.Writer.
+func (w *StorageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("storage: write to closed writer")
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.check != nil {
+		if w.err = w.check.write(p); w.err != nil {
+			w.abort()
+			return 0, w.err
+		}
+	}
+	n := 0
+	for len(p) > 0 {
+		if w.buffered {
+			if len(w.buf)+len(p) > StorageMaxBuffered {
+				w.err = storage.StorageErrorUnsupported
+				w.abort()
+				return n, w.err
+			}
+			w.buf = append(w.buf, p...)
+			return n + len(p), nil
+		}
+		take := min(len(p), StorageChunkSize-len(w.buf))
+		w.buf = append(w.buf, p[:take]...)
+		p, n = p[take:], n+take
+		if len(w.buf) < StorageChunkSize {
+			continue
+		}
+		if w.err = w.flush(w.buf); w.err != nil {
+			w.abort()
+			return n, w.err
+		}
+		if !w.buffered {
+			w.buf = w.buf[:0]
+		}
+	}
+	return n, nil
+}
+
+// Close stores the object.
+func (w *StorageWriter) Close() error {
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	if w.err == nil && w.check != nil {
+		w.err = w.check.close()
+	}
+	if w.err != nil {
+		w.abort()
+		return w.err
+	}
+	if w.upload == "" {
+		w.err = putObject(w.folder, w.key, w.buf, w.contentType, w.metadata)
+		if w.err == nil {
+			w.info = ObjectInfo{Key: w.key, Size: int64(len(w.buf)), ContentType: w.contentType, Metadata: w.metadata}
+		}
+		w.buf = nil
+		return w.err
+	}
+	if len(w.buf) > 0 {
+		if w.err = storage.UploadChunk(w.upload, w.buf); w.err != nil {
+			w.abort()
+			return w.err
+		}
+	}
+	w.buf = nil
+	w.info, w.err = storage.CommitUpload(w.upload)
+	return w.err
+}
+
+// Abort discards everything written so far. The writer cannot be used
+// afterwards.
+func (w *StorageWriter) Abort() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.err = errors.New("storage: writer aborted")
+	return w.abort()
+}
+
+// Info returns the metadata of the stored object once Close has succeeded.
+func (w *StorageWriter) Info() ObjectInfo {
+	return w.info
+}
+
+// flush sends a full chunk, starting the upload on the first one.
+func (w *StorageWriter) flush(chunk []byte) error {
+	if w.upload == "" {
+		if storage.BeginUpload == nil {
+			w.buffered = true
+			return nil
+		}
+		id, err := storage.BeginUpload(w.folder, w.key, w.contentType, w.metadata)
+		if isStorageUnsupported(err) {
+			w.buffered = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		w.upload = id
+	}
+	return storage.UploadChunk(w.upload, chunk)
+}
+
+func (w *StorageWriter) abort() error {
+	w.buf = nil
+	if w.upload == "" {
+		return nil
+	}
+	id := w.upload
+	w.upload = ""
+	return storage.AbortUpload(id)
+}
+
+func isStorageUnsupported(err error) bool {
+	return errors.Is(err, storage.StorageErrorUnsupported)
+}
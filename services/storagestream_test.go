@@ -0,0 +1,56 @@
+package services_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/wafer-run/wafer-sdk-go/gen/wafer/storage"
+	. "github.com/wafer-run/wafer-sdk-go/services"
+)
+
+func TestStorageWriter(t *testing.T) {
+	tests := []struct {
+		name      string
+		chunked   bool
+		size      int
+		wantLimit bool
+	}{
+		{name: "small", size: 10},
+		{name: "chunked", chunked: true, size: 3*StorageChunkSize + 5},
+		{name: "buffered", size: 3*StorageChunkSize + 5},
+		{name: "buffered at the limit", size: StorageMaxBuffered},
+		{name: "buffered over the limit", size: StorageMaxBuffered + 1, wantLimit: true},
+		{name: "chunked over the buffer limit", chunked: true, size: StorageMaxBuffered + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, st := setup(t)
+			if !tt.chunked {
+				storage.BeginUpload = nil
+			}
+			data := bytes.Repeat([]byte("x"), tt.size)
+			w := StorageCreateWriter("files", "big", "application/octet-stream")
+			var err error
+			for off := 0; off < len(data) && err == nil; off += 1 << 20 {
+				_, err = w.Write(data[off:min(off+1<<20, len(data))])
+			}
+			if cerr := w.Close(); err == nil {
+				err = cerr
+			}
+			if got := errors.Is(err, storage.StorageErrorUnsupported); got != tt.wantLimit {
+				t.Fatalf("error = %v, want limit %v", err, tt.wantLimit)
+			}
+			stored, ok := st.Object("files", "big")
+			if tt.wantLimit {
+				if ok {
+					t.Error("object stored past the limit")
+				}
+				return
+			}
+			if !bytes.Equal(stored, data) {
+				t.Errorf("stored %d bytes, want %d", len(stored), len(data))
+			}
+		})
+	}
+}
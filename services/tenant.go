@@ -0,0 +1,418 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+)
+
+// DefaultTenantField is the record field that holds the owning tenant's ID.
+const DefaultTenantField = "tenant_id"
+
+// TenantResolver extracts a tenant ID from a message. It returns "" when the
+// message does not identify a tenant the way the resolver looks for.
+type TenantResolver func(msg *wafer.Message) (string, error)
+
+// TenantFromHeader resolves the tenant from a request header such as
+// "X-Tenant-ID".
+func TenantFromHeader(name string) TenantResolver {
+	return func(msg *wafer.Message) (string, error) {
+		return strings.TrimSpace(msg.Header(name)), nil
+	}
+}
+
+// TenantFromMeta resolves the tenant from a message meta key set by an
+// earlier block in the chain.
+func TenantFromMeta(key string) TenantResolver {
+	return func(msg *wafer.Message) (string, error) {
+		return msg.GetMeta(key), nil
+	}
+}
+
+// TenantFromClaim resolves the tenant from a top-level claim of the bearer
+// token in the Authorization header. The token is verified with CryptoVerify,
+// and an invalid token, or a presign token from StoragePresign, is an
+// "unauthenticated" error.
+func TenantFromClaim(claim string) TenantResolver {
+	return func(msg *wafer.Message) (string, error) {
+		scheme, token, _ := strings.Cut(strings.TrimSpace(msg.Header("Authorization")), " ")
+		token = strings.TrimSpace(token)
+		if !strings.EqualFold(scheme, "Bearer") || token == "" {
+			return "", nil
+		}
+		claims, err := CryptoVerify(token)
+		if err != nil {
+			return "", &wafer.WaferError{
+				Code:    "unauthenticated",
+				Message: "invalid bearer token: " + err.Error(),
+			}
+		}
+		var doc map[string]any
+		if err := json.Unmarshal([]byte(claims), &doc); err != nil || doc["typ"] == PresignTokenType {
+			return "", &wafer.WaferError{
+				Code:    "unauthenticated",
+				Message: "invalid token claims",
+			}
+		}
+		switch v := doc[claim].(type) {
+		case string:
+			return v, nil
+		case float64:
+			return jsonValue(v), nil
+		}
+		return "", nil
+	}
+}
+
+// TenantFromSubdomain resolves the tenant from the first label of the Host
+// header under baseDomain, so "acme.example.com" yields "acme" for base
+// domain "example.com". Hosts with more than one label under the base domain
+// do not resolve.
+func TenantFromSubdomain(baseDomain string) TenantResolver {
+	suffix := "." + strings.ToLower(strings.Trim(baseDomain, "."))
+	return func(msg *wafer.Message) (string, error) {
+		host := strings.ToLower(msg.Header("Host"))
+		if i := strings.LastIndexByte(host, ':'); i >= 0 && !strings.Contains(host[i:], "]") {
+			host = host[:i]
+		}
+		label, ok := strings.CutSuffix(host, suffix)
+		if !ok || label == "" || strings.Contains(label, ".") {
+			return "", nil
+		}
+		return label, nil
+	}
+}
+
+// ResolveTenant runs the resolvers in order and returns the tenant they
+// identify. Every resolver that finds a tenant must agree: a header naming
+// one tenant and a token issued for another is a cross-tenant attempt and
+// fails with "permission_denied", as does a message with no tenant at all.
+func ResolveTenant(msg *wafer.Message, resolvers ...TenantResolver) (string, error) {
+	tenant := ""
+	for _, resolve := range resolvers {
+		id, err := resolve(msg)
+		if err != nil {
+			return "", err
+		}
+		if id == "" {
+			continue
+		}
+		if tenant != "" && id != tenant {
+			return "", &wafer.WaferError{
+				Code:    "permission_denied",
+				Message: "request identifies conflicting tenants",
+			}
+		}
+		tenant = id
+	}
+	if tenant == "" {
+		return "", &wafer.WaferError{
+			Code:    "permission_denied",
+			Message: "request does not identify a tenant",
+		}
+	}
+	return tenant, nil
+}
+
+// TenantScope confines database and storage calls to one tenant. Records
+// are stamped with the tenant's ID on write and filtered by it on read, and
+// storage folders are prefixed with it. Touching another tenant's records
+// fails with "permission_denied".
+type TenantScope struct {
+	id    string
+	field string
+}
+
+// NewTenantScope creates a scope for a tenant ID. IDs are limited to
+// letters, digits, '-', '_' and '.' so they are safe as folder prefixes.
+func NewTenantScope(id string) (*TenantScope, error) {
+	if !validTenantID(id) {
+		return nil, &wafer.WaferError{
+			Code:    "invalid_argument",
+			Message: "invalid tenant ID " + jsonValue(id),
+		}
+	}
+	return &TenantScope{id: id, field: DefaultTenantField}, nil
+}
+
+// TenantScopeFor resolves the tenant of msg and returns its scope.
+func TenantScopeFor(msg *wafer.Message, resolvers ...TenantResolver) (*TenantScope, error) {
+	id, err := ResolveTenant(msg, resolvers...)
+	if err != nil {
+		return nil, err
+	}
+	return NewTenantScope(id)
+}
+
+// WithField returns a copy of the scope that stores the tenant ID in field
+// instead of DefaultTenantField.
+func (t *TenantScope) WithField(field string) *TenantScope {
+	c := *t
+	c.field = field
+	return &c
+}
+
+// ID returns the tenant ID.
+func (t *TenantScope) ID() string { return t.id }
+
+// Field returns the record field that holds the tenant ID.
+func (t *TenantScope) Field() string { return t.field }
+
+// Filter returns the filter that selects the tenant's records.
+func (t *TenantScope) Filter() Filter {
+	return Filter{Field: t.field, Operator: OpEqual, Value: jsonValue(t.id)}
+}
+
+// Filters returns filters with the tenant filter added.
+func (t *TenantScope) Filters(filters []Filter) []Filter {
+	out := append([]Filter(nil), filters...)
+	return append(out, t.Filter())
+}
+
+// Folder returns the tenant's storage folder for folder. Folders that try to
+// climb out of the tenant's prefix are rejected with "permission_denied".
+func (t *TenantScope) Folder(folder string) (string, error) {
+	if !safeStoragePath(strings.TrimPrefix(folder, "/")) {
+		return "", t.denied("folder " + folder)
+	}
+	return t.id + "/" + strings.TrimPrefix(folder, "/"), nil
+}
+
+// CheckKey rejects object keys and list prefixes that could resolve outside
+// the tenant's folder on hosts that treat keys as paths: keys with ".."
+// segments, a leading '/' or backslashes fail with "permission_denied".
+func (t *TenantScope) CheckKey(key string) error {
+	if !safeStoragePath(key) {
+		return t.denied("key " + key)
+	}
+	return nil
+}
+
+// DatabaseGet retrieves one of the tenant's records by ID.
+func (t *TenantScope) DatabaseGet(collection, id string) (Record, error) {
+	rec, err := DatabaseGet(collection, id)
+	if err != nil {
+		return Record{}, err
+	}
+	return rec, t.Check(rec)
+}
+
+// DatabaseList retrieves the tenant's records matching opts.
+func (t *TenantScope) DatabaseList(collection string, opts ListOptions) (RecordList, error) {
+	opts.Filters = t.Filters(opts.Filters)
+	return DatabaseList(collection, opts)
+}
+
+// DatabaseCount counts the tenant's records matching filters.
+func (t *TenantScope) DatabaseCount(collection string, filters []Filter) (int64, error) {
+	return DatabaseCount(collection, t.Filters(filters))
+}
+
+// DatabaseCreate inserts a record owned by the tenant. The data argument must
+// encode to a JSON object.
+func (t *TenantScope) DatabaseCreate(collection string, data any) (Record, error) {
+	doc, err := t.Stamp(data)
+	if err != nil {
+		return Record{}, err
+	}
+	return DatabaseCreate(collection, doc)
+}
+
+// DatabaseUpdate replaces one of the tenant's records.
+func (t *TenantScope) DatabaseUpdate(collection, id string, data any) (Record, error) {
+	doc, err := t.Stamp(data)
+	if err != nil {
+		return Record{}, err
+	}
+	if _, err := t.DatabaseGet(collection, id); err != nil {
+		return Record{}, err
+	}
+	return DatabaseUpdate(collection, id, doc)
+}
+
+// DatabasePatch applies a patch to one of the tenant's records. Patches may
+// not change the tenant field.
+func (t *TenantScope) DatabasePatch(collection, id string, format PatchFormat, patch []byte) (Record, error) {
+	if err := t.CheckPatch(format, patch); err != nil {
+		return Record{}, err
+	}
+	if _, err := t.DatabaseGet(collection, id); err != nil {
+		return Record{}, err
+	}
+	return DatabasePatch(collection, id, format, patch)
+}
+
+// DatabaseDelete removes one of the tenant's records.
+func (t *TenantScope) DatabaseDelete(collection, id string) error {
+	if _, err := t.DatabaseGet(collection, id); err != nil {
+		return err
+	}
+	return DatabaseDelete(collection, id)
+}
+
+// StoragePut stores content in the tenant's folder.
+func (t *TenantScope) StoragePut(folder, key string, data []byte, contentType string) error {
+	if err := t.CheckKey(key); err != nil {
+		return err
+	}
+	f, err := t.Folder(folder)
+	if err != nil {
+		return err
+	}
+	return StoragePut(f, key, data, contentType)
+}
+
+// StorageGet retrieves content from the tenant's folder.
+func (t *TenantScope) StorageGet(folder, key string) ([]byte, ObjectInfo, error) {
+	if err := t.CheckKey(key); err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	f, err := t.Folder(folder)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	return StorageGet(f, key)
+}
+
+// StorageDelete removes content from the tenant's folder.
+func (t *TenantScope) StorageDelete(folder, key string) error {
+	if err := t.CheckKey(key); err != nil {
+		return err
+	}
+	f, err := t.Folder(folder)
+	if err != nil {
+		return err
+	}
+	return StorageDelete(f, key)
+}
+
+// StorageList lists objects in the tenant's folder.
+func (t *TenantScope) StorageList(folder, prefix string, limit, offset int64) (ObjectList, error) {
+	if err := t.CheckKey(prefix); err != nil {
+		return ObjectList{}, err
+	}
+	f, err := t.Folder(folder)
+	if err != nil {
+		return ObjectList{}, err
+	}
+	return StorageList(f, prefix, limit, offset)
+}
+
+// Check returns a "permission_denied" WaferError unless rec belongs to the
+// tenant.
+func (t *TenantScope) Check(rec Record) error {
+	var doc map[string]any
+	_ = json.Unmarshal([]byte(rec.Data), &doc)
+	if owner, _ := doc[t.field].(string); owner != t.id {
+		return t.denied("record " + rec.ID)
+	}
+	return nil
+}
+
+// Stamp encodes data as a JSON object owned by the tenant. Data that already
+// names a different tenant is rejected with "permission_denied".
+func (t *TenantScope) Stamp(data any) (map[string]json.RawMessage, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, &wafer.WaferError{
+			Code:    "internal",
+			Message: "failed to marshal record: " + err.Error(),
+		}
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &doc); err != nil || doc == nil {
+		return nil, &wafer.WaferError{
+			Code:    "invalid_argument",
+			Message: "tenant-scoped records must encode to a JSON object",
+		}
+	}
+	if err := t.checkValue(doc[t.field]); err != nil {
+		return nil, err
+	}
+	doc[t.field] = json.RawMessage(jsonValue(t.id))
+	return doc, nil
+}
+
+// CheckPatch rejects patches that would move a record to another tenant,
+// including patches that replace the whole document.
+func (t *TenantScope) CheckPatch(format PatchFormat, patch []byte) error {
+	if format != JSONPatch {
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal(patch, &doc); err != nil || doc == nil {
+			return t.denied("the document root")
+		}
+		return t.checkValue(doc[t.field])
+	}
+	var ops []struct {
+		Op   string `json:"op"`
+		Path string `json:"path"`
+		From string `json:"from"`
+	}
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return patchError("invalid JSON patch: " + err.Error())
+	}
+	field := "/" + escapePointer(t.field)
+	for _, op := range ops {
+		if op.Op == "test" {
+			continue
+		}
+		if op.Path == "" || ((op.Op == "move" || op.Op == "copy") && op.From == "") {
+			return t.denied("the document root")
+		}
+		for _, p := range []string{op.Path, op.From} {
+			if p == field || strings.HasPrefix(p, field+"/") {
+				return t.denied("the tenant field")
+			}
+		}
+	}
+	return nil
+}
+
+// checkValue accepts a missing tenant field or one naming this tenant.
+func (t *TenantScope) checkValue(raw json.RawMessage) error {
+	if raw == nil {
+		return nil
+	}
+	var owner string
+	if json.Unmarshal(raw, &owner) != nil || owner != t.id {
+		return t.denied("another tenant's data")
+	}
+	return nil
+}
+
+func (t *TenantScope) denied(what string) error {
+	return &wafer.WaferError{
+		Code:    "permission_denied",
+		Message: "tenant " + t.id + " may not access " + what,
+	}
+}
+
+// safeStoragePath reports whether a folder, key or prefix stays where it is
+// put: it has no ".." segments, no leading '/' and no backslashes.
+func safeStoragePath(p string) bool {
+	if strings.HasPrefix(p, "/") || strings.ContainsRune(p, '\\') {
+		return false
+	}
+	for _, part := range strings.Split(p, "/") {
+		if part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func validTenantID(id string) bool {
+	if id == "" || id == "." || id == ".." {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,146 @@
+package services_test
+
+import (
+	"errors"
+	"testing"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+	"github.com/wafer-run/wafer-sdk-go/gen/wafer/crypto"
+	"github.com/wafer-run/wafer-sdk-go/gen/wafer/storage"
+	. "github.com/wafer-run/wafer-sdk-go/services"
+)
+
+func isCode(err error, code string) bool {
+	var we *wafer.WaferError
+	return errors.As(err, &we) && we.Code == code
+}
+
+func TestTenantScopeCheckPatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		format PatchFormat
+		patch  string
+		denied bool
+	}{
+		{name: "merge field", format: MergePatch, patch: `{"title":"x"}`},
+		{name: "merge own tenant", format: MergePatch, patch: `{"tenant_id":"acme"}`},
+		{name: "merge other tenant", format: MergePatch, patch: `{"tenant_id":"evil"}`, denied: true},
+		{name: "merge null tenant", format: MergePatch, patch: `{"tenant_id":null}`, denied: true},
+		{name: "merge non-object", format: MergePatch, patch: `[1]`, denied: true},
+		{name: "merge null", format: MergePatch, patch: `null`, denied: true},
+		{name: "replace field", format: JSONPatch, patch: `[{"op":"replace","path":"/title","value":"x"}]`},
+		{name: "test tenant", format: JSONPatch, patch: `[{"op":"test","path":"/tenant_id","value":"acme"}]`},
+		{name: "replace tenant", format: JSONPatch, patch: `[{"op":"replace","path":"/tenant_id","value":"evil"}]`, denied: true},
+		{name: "remove tenant", format: JSONPatch, patch: `[{"op":"remove","path":"/tenant_id"}]`, denied: true},
+		{name: "move onto tenant", format: JSONPatch, patch: `[{"op":"move","from":"/title","path":"/tenant_id"}]`, denied: true},
+		{name: "replace root", format: JSONPatch, patch: `[{"op":"replace","path":"","value":{"tenant_id":"evil"}}]`, denied: true},
+		{name: "add root", format: JSONPatch, patch: `[{"op":"add","path":"","value":{}}]`, denied: true},
+		{name: "remove root", format: JSONPatch, patch: `[{"op":"remove","path":""}]`, denied: true},
+		{name: "copy root", format: JSONPatch, patch: `[{"op":"copy","from":"","path":"/backup"}]`, denied: true},
+		{name: "move root", format: JSONPatch, patch: `[{"op":"move","from":"","path":"/backup"}]`, denied: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup(t)
+			scope, err := NewTenantScope("acme")
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec, err := scope.DatabaseCreate("tasks", map[string]any{"title": "a"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = scope.DatabasePatch("tasks", rec.ID, tt.format, []byte(tt.patch))
+			if got := isCode(err, "permission_denied"); got != tt.denied {
+				t.Fatalf("DatabasePatch() error = %v, want denied %v", err, tt.denied)
+			}
+			if _, err := scope.DatabaseGet("tasks", rec.ID); err != nil {
+				t.Errorf("record left the tenant: %v", err)
+			}
+		})
+	}
+}
+
+func TestTenantScopeStorageKeys(t *testing.T) {
+	tests := []struct {
+		name   string
+		folder string
+		key    string
+		denied bool
+	}{
+		{name: "plain", folder: "docs", key: "a/b.txt"},
+		{name: "leading slash folder", folder: "/docs", key: "a.txt"},
+		{name: "dot segment", folder: "docs", key: "./a.txt"},
+		{name: "parent key", folder: "docs", key: "../../other/docs/a.txt", denied: true},
+		{name: "inner parent key", folder: "docs", key: "a/../../b", denied: true},
+		{name: "absolute key", folder: "docs", key: "/other/a.txt", denied: true},
+		{name: "backslash key", folder: "docs", key: `..\other\a.txt`, denied: true},
+		{name: "parent folder", folder: "../other", key: "a.txt", denied: true},
+		{name: "backslash folder", folder: `docs\..\..`, key: "a.txt", denied: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, st := setup(t)
+			puts := 0
+			storage.Put = func(folder, key string, data []byte, contentType string) error {
+				puts++
+				return st.Put(folder, key, data, contentType)
+			}
+			scope, err := NewTenantScope("acme")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ops := map[string]error{
+				"put": scope.StoragePut(tt.folder, tt.key, []byte("x"), "text/plain"),
+			}
+			_, _, ops["get"] = scope.StorageGet(tt.folder, tt.key)
+			_, ops["list"] = scope.StorageList(tt.folder, tt.key, 0, 0)
+			ops["delete"] = scope.StorageDelete(tt.folder, tt.key)
+			for op, err := range ops {
+				if got := isCode(err, "permission_denied"); got != tt.denied {
+					t.Errorf("%s: error = %v, want denied %v", op, err, tt.denied)
+				}
+			}
+			if tt.denied && puts != 0 {
+				t.Errorf("%d objects written", puts)
+			}
+		})
+	}
+}
+
+func TestTenantFromClaim(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{name: "bearer", header: "Bearer good", want: "acme"},
+		{name: "lower case scheme", header: "bearer good", want: "acme"},
+		{name: "upper case scheme", header: "BEARER  good ", want: "acme"},
+		{name: "other scheme", header: "Basic good"},
+		{name: "no token", header: "Bearer "},
+		{name: "no header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup(t)
+			crypto.Verify = func(token string) (string, error) {
+				if token != "good" {
+					return "", errors.New("bad token")
+				}
+				return `{"tenant":"acme"}`, nil
+			}
+			msg := &wafer.Message{}
+			if tt.header != "" {
+				msg.SetMeta("http.header.authorization", tt.header)
+			}
+			got, err := TenantFromClaim("tenant")(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("tenant = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,446 @@
+package services
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+)
+
+// SniffLength is the number of leading bytes SniffContentType inspects.
+const SniffLength = 512
+
+// Reasons reported in the "reason" Meta entry of upload policy errors.
+const (
+	UploadTooLarge       = "too_large"
+	UploadTypeMismatch   = "type_mismatch"
+	UploadTypeNotAllowed = "type_not_allowed"
+)
+
+// UploadPolicy restricts what may be stored in a folder. Content types in
+// Allow and Deny are exact ("image/png"), wildcard subtypes ("image/*") or
+// "*". Active content, which a browser runs scripts in when it is served
+// inline (SVG and HTML), is only accepted when Allow lists its type
+// exactly, so "image/*" admits PNG but not SVG.
+type UploadPolicy struct {
+	// MaxSize is the largest object accepted in bytes, or 0 for no limit.
+	MaxSize int64
+	// Allow lists the declared content types accepted. Empty allows all.
+	Allow []string
+	// Deny lists content types rejected whether declared or detected. It
+	// takes precedence over Allow.
+	Deny []string
+}
+
+var (
+	uploadPoliciesMu sync.RWMutex
+	uploadPolicies   = map[string]UploadPolicy{}
+)
+
+// RegisterUploadPolicy applies p to folder. StoragePut,
+// StoragePutWithMetadata, the storage writers, StorageCopy and StorageMove
+// then reject objects that violate it: objects larger than MaxSize, objects
+// whose leading bytes do not match the declared content type, and types
+// excluded by Allow or Deny. Registering a folder again replaces its policy.
+func RegisterUploadPolicy(folder string, p UploadPolicy) {
+	uploadPoliciesMu.Lock()
+	uploadPolicies[folder] = p
+	uploadPoliciesMu.Unlock()
+}
+
+// UnregisterUploadPolicy removes the policy of folder.
+func UnregisterUploadPolicy(folder string) {
+	uploadPoliciesMu.Lock()
+	delete(uploadPolicies, folder)
+	uploadPoliciesMu.Unlock()
+}
+
+// LookupUploadPolicy returns the policy registered for folder.
+func LookupUploadPolicy(folder string) (UploadPolicy, bool) {
+	uploadPoliciesMu.RLock()
+	defer uploadPoliciesMu.RUnlock()
+	p, ok := uploadPolicies[folder]
+	return p, ok
+}
+
+// CheckUpload checks data and its declared content type against the policy
+// registered for folder. It returns nil when there is none. A violation is
+// an "invalid_argument" WaferError whose Meta has "reason" (UploadTooLarge,
+// UploadTypeMismatch or UploadTypeNotAllowed), "declared_type",
+// "detected_type" and, for size violations, "max_size".
+func CheckUpload(folder string, data []byte, contentType string) error {
+	p, ok := LookupUploadPolicy(folder)
+	if !ok {
+		return nil
+	}
+	return p.Check(data, contentType)
+}
+
+// Check checks data and its declared content type against the policy.
+func (p UploadPolicy) Check(data []byte, contentType string) error {
+	c := &uploadCheck{policy: p, contentType: contentType}
+	if err := c.write(data); err != nil {
+		return err
+	}
+	return c.close()
+}
+
+// SniffContentType identifies content from its leading bytes. It recognises
+// common image, audio, video, document, archive and executable formats,
+// reports SVG and HTML documents as "image/svg+xml" and "text/html", other
+// UTF-8 text without control characters as "text/plain", and anything else
+// as "application/octet-stream".
+func SniffContentType(data []byte) string {
+	data = data[:min(len(data), SniffLength)]
+	for _, s := range signatures {
+		if len(data) >= s.offset+len(s.magic) && bytes.Equal(data[s.offset:s.offset+len(s.magic)], s.magic) &&
+			(s.and == nil || len(data) >= s.andOffset+len(s.and) && bytes.Equal(data[s.andOffset:s.andOffset+len(s.and)], s.and)) {
+			return s.contentType
+		}
+	}
+	if isText(data) {
+		return sniffMarkup(data)
+	}
+	return "application/octet-stream"
+}
+
+// sniffMarkup tells SVG and HTML documents apart from other text.
+func sniffMarkup(data []byte) string {
+	text := strings.ToLower(strings.TrimLeft(string(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))), " \t\r\n\f"))
+	switch {
+	case !strings.HasPrefix(text, "<"):
+		return "text/plain"
+	case strings.HasPrefix(text, "<!doctype html"), strings.HasPrefix(text, "<html"),
+		strings.HasPrefix(text, "<head"), strings.HasPrefix(text, "<body"), strings.HasPrefix(text, "<script"):
+		return "text/html"
+	case strings.Contains(text, "<svg"):
+		return "image/svg+xml"
+	}
+	return "text/plain"
+}
+
+// signature matches magic at offset, and optionally and at andOffset.
+type signature struct {
+	offset      int
+	magic       []byte
+	andOffset   int
+	and         []byte
+	contentType string
+}
+
+var signatures = []signature{
+	{magic: []byte("\x89PNG\r\n\x1a\n"), contentType: "image/png"},
+	{magic: []byte("\xff\xd8\xff"), contentType: "image/jpeg"},
+	{magic: []byte("GIF87a"), contentType: "image/gif"},
+	{magic: []byte("GIF89a"), contentType: "image/gif"},
+	{magic: []byte("RIFF"), andOffset: 8, and: []byte("WEBP"), contentType: "image/webp"},
+	{magic: []byte("RIFF"), andOffset: 8, and: []byte("WAVE"), contentType: "audio/wav"},
+	{magic: []byte("RIFF"), andOffset: 8, and: []byte("AVI "), contentType: "video/x-msvideo"},
+	{magic: []byte("II*\x00"), contentType: "image/tiff"},
+	{magic: []byte("MM\x00*"), contentType: "image/tiff"},
+	{magic: []byte("\x00\x00\x01\x00"), contentType: "image/x-icon"},
+	{offset: 4, magic: []byte("ftypavif"), contentType: "image/avif"},
+	{offset: 4, magic: []byte("ftypheic"), contentType: "image/heic"},
+	{offset: 4, magic: []byte("ftypqt"), contentType: "video/quicktime"},
+	{offset: 4, magic: []byte("ftyp"), contentType: "video/mp4"},
+	{magic: []byte("\x1aE\xdf\xa3"), contentType: "video/webm"},
+	{magic: []byte("ID3"), contentType: "audio/mpeg"},
+	{magic: []byte("OggS"), contentType: "audio/ogg"},
+	{magic: []byte("fLaC"), contentType: "audio/flac"},
+	{magic: []byte("%PDF-"), contentType: "application/pdf"},
+	{magic: []byte("PK\x03\x04"), contentType: "application/zip"},
+	{magic: []byte("PK\x05\x06"), contentType: "application/zip"},
+	{magic: []byte("\x1f\x8b\x08"), contentType: "application/gzip"},
+	{offset: 257, magic: []byte("ustar"), contentType: "application/x-tar"},
+	{magic: []byte("7z\xbc\xaf\x27\x1c"), contentType: "application/x-7z-compressed"},
+	{magic: []byte("Rar!\x1a\x07"), contentType: "application/vnd.rar"},
+	{magic: []byte("\x00asm"), contentType: "application/wasm"},
+	{magic: []byte("MZ"), contentType: "application/x-msdownload"},
+	{magic: []byte("\x7fELF"), contentType: "application/x-executable"},
+	{magic: []byte("\xfe\xed\xfa\xce"), contentType: "application/x-mach-binary"},
+	{magic: []byte("\xfe\xed\xfa\xcf"), contentType: "application/x-mach-binary"},
+	{magic: []byte("\xce\xfa\xed\xfe"), contentType: "application/x-mach-binary"},
+	{magic: []byte("\xcf\xfa\xed\xfe"), contentType: "application/x-mach-binary"},
+	{magic: []byte("\xca\xfe\xba\xbe"), contentType: "application/x-mach-binary"},
+	{magic: []byte("#!"), contentType: "text/x-shellscript"},
+}
+
+// executableTypes are detected types that are only accepted when declared
+// as such.
+var executableTypes = map[string]bool{
+	"application/x-msdownload":  true,
+	"application/x-executable":  true,
+	"application/x-mach-binary": true,
+	"text/x-shellscript":        true,
+}
+
+// activeTypes are types a browser runs scripts in. They are only accepted
+// when a policy allows them by name.
+var activeTypes = map[string]bool{
+	"image/svg+xml":         true,
+	"text/html":             true,
+	"application/xhtml+xml": true,
+}
+
+// compatibleTypes lists declared types that legitimately carry another
+// format's signature.
+var compatibleTypes = map[string][]string{
+	"application/zip": {
+		"application/x-zip-compressed", "application/java-archive", "application/epub+zip",
+		"application/vnd.openxmlformats-officedocument.*", "application/vnd.oasis.opendocument.*",
+		"application/vnd.android.package-archive",
+	},
+	"application/gzip":         {"application/x-gzip", "application/x-tar+gzip"},
+	"image/jpeg":               {"image/jpg", "image/pjpeg"},
+	"image/x-icon":             {"image/vnd.microsoft.icon"},
+	"audio/wav":                {"audio/x-wav", "audio/wave"},
+	"audio/mpeg":               {"audio/mp3"},
+	"video/mp4":                {"video/quicktime", "audio/mp4", "video/x-m4v", "audio/x-m4a", "video/3gpp", "image/heic", "image/heif", "image/avif"},
+	"application/x-msdownload": {"application/vnd.microsoft.portable-executable", "application/x-dosexec"},
+	"text/x-shellscript":       {"application/x-sh", "text/x-sh", "application/x-shellscript"},
+	"text/html":                {"application/xhtml+xml"},
+}
+
+// typesCompatible reports whether content detected as sniffed may be
+// declared as declared.
+func typesCompatible(declared, sniffed string) bool {
+	if declared == sniffed {
+		return true
+	}
+	for _, alias := range compatibleTypes[sniffed] {
+		if matchContentType(alias, declared) {
+			return true
+		}
+	}
+	if executableTypes[sniffed] {
+		return false
+	}
+	switch sniffed {
+	case "text/plain":
+		return declared == "application/octet-stream" || isTextType(declared)
+	case "application/octet-stream":
+		return !hasSignature(declared) && !isTextType(declared)
+	}
+	return declared == "application/octet-stream"
+}
+
+// hasSignature reports whether a type is one SniffContentType can recognise,
+// so content declared as it must carry its signature.
+func hasSignature(contentType string) bool {
+	for _, s := range signatures {
+		if s.contentType == contentType {
+			return true
+		}
+		for _, alias := range compatibleTypes[s.contentType] {
+			if matchContentType(alias, contentType) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isTextType(contentType string) bool {
+	if strings.HasPrefix(contentType, "text/") ||
+		strings.HasSuffix(contentType, "+json") || strings.HasSuffix(contentType, "+xml") {
+		return true
+	}
+	switch contentType {
+	case "application/json", "application/xml", "application/javascript",
+		"application/x-ndjson", "application/yaml", "application/x-yaml", "application/toml":
+		return true
+	}
+	return false
+}
+
+// isText reports whether data is UTF-8 text without control characters other
+// than whitespace. A rune cut off at the end of data is ignored.
+func isText(data []byte) bool {
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size == 1 {
+			return len(data) < utf8.UTFMax && !utf8.FullRune(data)
+		}
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' && r != '\f' || r == 0x7f {
+			return false
+		}
+		data = data[size:]
+	}
+	return true
+}
+
+// matchContentType matches a type against an exact type, "type/*",
+// "prefix.*" or "*".
+func matchContentType(pattern, contentType string) bool {
+	switch {
+	case pattern == "*":
+		return true
+	case strings.HasSuffix(pattern, "*"):
+		return strings.HasPrefix(contentType, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == contentType
+}
+
+// listsType reports whether patterns names contentType without a wildcard.
+func listsType(patterns []string, contentType string) bool {
+	for _, p := range patterns {
+		if strings.ToLower(p) == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, contentType string) bool {
+	for _, p := range patterns {
+		if matchContentType(strings.ToLower(p), contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeContentType lower-cases a content type and drops its parameters.
+func normalizeContentType(contentType string) string {
+	t, _, _ := strings.Cut(contentType, ";")
+	t = strings.ToLower(strings.TrimSpace(t))
+	if t == "" {
+		return "application/octet-stream"
+	}
+	return t
+}
+
+// uploadCheck applies a policy to content as it is written, holding only
+// the first SniffLength bytes.
+type uploadCheck struct {
+	policy      UploadPolicy
+	contentType string
+	head        []byte
+	size        int64
+	typed       bool
+}
+
+// newUploadCheck returns the check for writes to folder, or nil if the
+// folder has no policy.
+func newUploadCheck(folder, contentType string) *uploadCheck {
+	p, ok := LookupUploadPolicy(folder)
+	if !ok {
+		return nil
+	}
+	return &uploadCheck{policy: p, contentType: contentType}
+}
+
+func (c *uploadCheck) write(p []byte) error {
+	c.size += int64(len(p))
+	if c.policy.MaxSize > 0 && c.size > c.policy.MaxSize {
+		return c.tooLarge()
+	}
+	if c.typed {
+		return nil
+	}
+	c.head = append(c.head, p[:min(len(p), SniffLength-len(c.head))]...)
+	if len(c.head) < SniffLength {
+		return nil
+	}
+	return c.checkType()
+}
+
+func (c *uploadCheck) close() error {
+	if c.typed {
+		return nil
+	}
+	return c.checkType()
+}
+
+func (c *uploadCheck) checkType() error {
+	c.typed = true
+	declared := normalizeContentType(c.contentType)
+	sniffed := c.detected()
+	if !typesCompatible(declared, sniffed) {
+		return c.violation(UploadTypeMismatch, "content looks like "+sniffed+", not the declared "+declared)
+	}
+	return c.checkAllowed(declared, sniffed)
+}
+
+// checkAllowed applies Allow and Deny to the declared type and, when the
+// content could be sniffed, the detected one.
+func (c *uploadCheck) checkAllowed(declared string, sniffed ...string) error {
+	for _, t := range append([]string{declared}, sniffed...) {
+		if matchAny(c.policy.Deny, t) {
+			return c.violation(UploadTypeNotAllowed, "content type "+t+" is not allowed")
+		}
+		if activeTypes[t] && !listsType(c.policy.Allow, t) {
+			return c.violation(UploadTypeNotAllowed, "content type "+t+" is active content and must be allowed explicitly")
+		}
+	}
+	if len(c.policy.Allow) > 0 && !matchAny(c.policy.Allow, declared) {
+		return c.violation(UploadTypeNotAllowed, "content type "+declared+" is not allowed")
+	}
+	return nil
+}
+
+func (c *uploadCheck) tooLarge() error {
+	return c.violation(UploadTooLarge, "upload exceeds the maximum size of "+strconv.FormatInt(c.policy.MaxSize, 10)+" bytes")
+}
+
+func (c *uploadCheck) detected() string {
+	return SniffContentType(c.head)
+}
+
+func (c *uploadCheck) violation(reason, message string) error {
+	meta := map[string]string{
+		"reason":        reason,
+		"declared_type": normalizeContentType(c.contentType),
+	}
+	if len(c.head) > 0 || c.typed {
+		meta["detected_type"] = c.detected()
+	}
+	if reason == UploadTooLarge {
+		meta["max_size"] = strconv.FormatInt(c.policy.MaxSize, 10)
+	}
+	return &wafer.WaferError{
+		Code:    "invalid_argument",
+		Message: message,
+		Meta:    meta,
+	}
+}
+
+// checkStoredUpload applies the policy of dstFolder to an existing object
+// about to be copied or moved there. Objects written by EncryptedStorage
+// cannot be sniffed, so only their stored size and declared type are
+// checked.
+func checkStoredUpload(srcFolder, srcKey, dstFolder string) error {
+	c := newUploadCheck(dstFolder, "")
+	if c == nil {
+		return nil
+	}
+	r, err := openStorageReader(srcFolder, srcKey)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	c.contentType = r.info.ContentType
+	if ObjectMetadata(r.info)[MetaEncryptionAlgorithm] != "" {
+		c.size = r.info.Size
+		if c.policy.MaxSize > 0 && c.size > c.policy.MaxSize {
+			return c.tooLarge()
+		}
+		return c.checkAllowed(normalizeContentType(c.contentType))
+	}
+	head := make([]byte, min(r.info.Size, SniffLength))
+	if _, err := io.ReadFull(r, head); err != nil {
+		return err
+	}
+	if err := c.write(head); err != nil {
+		return err
+	}
+	c.size = r.info.Size
+	if c.policy.MaxSize > 0 && c.size > c.policy.MaxSize {
+		return c.tooLarge()
+	}
+	return c.close()
+}
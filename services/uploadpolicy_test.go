@@ -0,0 +1,138 @@
+package services_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+	. "github.com/wafer-run/wafer-sdk-go/services"
+)
+
+// policyReason returns the "reason" Meta of an upload policy violation.
+func policyReason(err error) string {
+	var we *wafer.WaferError
+	if errors.As(err, &we) {
+		return we.Meta["reason"]
+	}
+	return ""
+}
+
+// registerPolicy registers p for folder for the duration of a test.
+func registerPolicy(t *testing.T, folder string, p UploadPolicy) {
+	RegisterUploadPolicy(folder, p)
+	t.Cleanup(func() { UnregisterUploadPolicy(folder) })
+}
+
+func TestUploadPolicySniffing(t *testing.T) {
+	svg := `<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`
+	html := `<!DOCTYPE html><html><script>alert(1)</script></html>`
+	tests := []struct {
+		name        string
+		policy      UploadPolicy
+		data        string
+		contentType string
+		reason      string
+	}{
+		{name: "plain text", data: "hello", contentType: "text/plain"},
+		{name: "png", data: "\x89PNG\r\n\x1a\nrest", contentType: "image/png", policy: UploadPolicy{Allow: []string{"image/*"}}},
+		{name: "html declared as png", data: html, contentType: "image/png", reason: UploadTypeMismatch},
+		{name: "svg declared as png", data: svg, contentType: "image/png", reason: UploadTypeMismatch},
+		{name: "svg declared as text", data: svg, contentType: "text/plain", reason: UploadTypeMismatch},
+		{name: "svg declared as xml", data: svg, contentType: "application/xml", reason: UploadTypeMismatch},
+		{name: "svg after prolog", data: "\xef\xbb\xbf \n<?xml version=\"1.0\"?>\n" + svg, contentType: "text/plain", reason: UploadTypeMismatch},
+		{name: "html in upper case", data: "  <HTML><BODY onload=alert(1)>", contentType: "text/plain", reason: UploadTypeMismatch},
+		{name: "svg with no allow list", data: svg, contentType: "image/svg+xml", reason: UploadTypeNotAllowed},
+		{name: "svg under wildcard", data: svg, contentType: "image/svg+xml", policy: UploadPolicy{Allow: []string{"image/*"}}, reason: UploadTypeNotAllowed},
+		{name: "svg under star", data: svg, contentType: "image/svg+xml", policy: UploadPolicy{Allow: []string{"*"}}, reason: UploadTypeNotAllowed},
+		{name: "svg as octet stream", data: svg, contentType: "application/octet-stream", reason: UploadTypeNotAllowed},
+		{name: "svg allowed by name", data: svg, contentType: "image/svg+xml", policy: UploadPolicy{Allow: []string{"image/png", "image/svg+xml"}}},
+		{name: "svg allowed but denied", data: svg, contentType: "image/svg+xml", policy: UploadPolicy{Allow: []string{"image/svg+xml"}, Deny: []string{"image/*"}}, reason: UploadTypeNotAllowed},
+		{name: "html with no allow list", data: html, contentType: "text/html", reason: UploadTypeNotAllowed},
+		{name: "html allowed by name", data: html, contentType: "text/html; charset=utf-8", policy: UploadPolicy{Allow: []string{"text/html"}}},
+		{name: "xhtml declared for html", data: html, contentType: "application/xhtml+xml", reason: UploadTypeNotAllowed},
+		{name: "elf declared as octet stream", data: "\x7fELF\x02\x01", contentType: "application/octet-stream", reason: UploadTypeMismatch},
+		{name: "shell script declared as text", data: "#!/bin/sh\nrm -rf /", contentType: "text/plain", reason: UploadTypeMismatch},
+		{name: "markup later in text", data: "notes about <svg> tags", contentType: "text/plain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Check([]byte(tt.data), tt.contentType)
+			if got := policyReason(err); got != tt.reason {
+				t.Errorf("Check = %v, want reason %q", err, tt.reason)
+			}
+		})
+	}
+}
+
+func TestStorageWriterAbortsOnViolation(t *testing.T) {
+	tests := []struct {
+		name  string
+		open  func() (io.Writer, error)
+		first int
+	}{
+		{
+			name:  "plain",
+			open:  func() (io.Writer, error) { return StorageCreateWriter("files", "a", "text/plain"), nil },
+			first: StorageChunkSize,
+		},
+		{
+			name: "encrypted",
+			open: func() (io.Writer, error) {
+				return NewEncryptedStorage(testKeyring()).CreateWriter("files", "a", "text/plain")
+			},
+			first: StorageChunkSize + EncryptedSegmentSize,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, st := setup(t)
+			registerPolicy(t, "files", UploadPolicy{MaxSize: int64(tt.first) + 10})
+			w, err := tt.open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(bytes.Repeat([]byte("x"), tt.first)); err != nil {
+				t.Fatal(err)
+			}
+			if st.Uploads() != 1 {
+				t.Fatalf("%d uploads open, want 1", st.Uploads())
+			}
+			if _, err := w.Write(bytes.Repeat([]byte("x"), 100)); policyReason(err) != UploadTooLarge {
+				t.Fatalf("Write = %v, want %s", err, UploadTooLarge)
+			}
+			if st.Uploads() != 0 {
+				t.Errorf("%d uploads still open after the violation", st.Uploads())
+			}
+			if _, err := w.Write([]byte("x")); policyReason(err) != UploadTooLarge {
+				t.Errorf("Write after violation = %v, want %s", err, UploadTooLarge)
+			}
+		})
+	}
+}
+
+func TestCopyEncryptedObjectIntoPolicyFolder(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy UploadPolicy
+		reason string
+	}{
+		{name: "declared type allowed", policy: UploadPolicy{Allow: []string{"text/plain"}}},
+		{name: "declared type not allowed", policy: UploadPolicy{Allow: []string{"image/png"}}, reason: UploadTypeNotAllowed},
+		{name: "too large", policy: UploadPolicy{MaxSize: 4}, reason: UploadTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup(t)
+			if err := NewEncryptedStorage(testKeyring()).Put("src", "a", []byte("hello"), "text/plain"); err != nil {
+				t.Fatal(err)
+			}
+			registerPolicy(t, "dst", tt.policy)
+			_, err := StorageCopy("src", "a", "dst", "a")
+			if got := policyReason(err); got != tt.reason || tt.reason == "" && err != nil {
+				t.Errorf("StorageCopy = %v, want reason %q", err, tt.reason)
+			}
+		})
+	}
+}
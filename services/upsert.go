@@ -0,0 +1,170 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+
+	wafer "github.com/wafer-run/wafer-sdk-go"
+	"github.com/wafer-run/wafer-sdk-go/gen/wafer/database"
+)
+
+// DefaultRetryAttempts is the number of attempts RetryOnConflict makes when
+// given a non-positive attempt count.
+const DefaultRetryAttempts = 5
+
+// DatabaseUpsert updates the first record matching matchFilters, or creates a
+// new record if none matches. The data argument is JSON-encoded. When the host
+// does not support upserts the lookup and write are separate calls, so two
+// concurrent callers may both create a record.
+func DatabaseUpsert(collection string, matchFilters []Filter, data any) (Record, error) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return Record{}, &wafer.WaferError{
+			Code:    "internal",
+			Message: "failed to marshal record: " + err.Error(),
+		}
+	}
+
+	if err := validateRecord(collection, string(jsonData)); err != nil {
+		return Record{}, err
+	}
+
+	if database.Upsert != nil {
+		rec, err := database.Upsert(collection, matchFilters, string(jsonData))
+		if !isUnsupported(err) {
+			return rec, err
+		}
+	}
+
+	rl, err := database.List(collection, ListOptions{Filters: matchFilters, Limit: 1})
+	if err != nil {
+		return Record{}, err
+	}
+	if len(rl.Records) == 0 {
+		return database.Create(collection, string(jsonData))
+	}
+	return database.Update(collection, rl.Records[0].ID, string(jsonData))
+}
+
+// DatabaseUpdateIf replaces the record only if its field still equals
+// expected, making it a compare-and-swap on a version or updated-at field. A
+// nil expected value matches a missing or null field. If the record changed
+// in the meantime an "aborted" WaferError is returned.
+func DatabaseUpdateIf(collection, id, field string, expected, data any) (Record, error) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return Record{}, &wafer.WaferError{
+			Code:    "internal",
+			Message: "failed to marshal record: " + err.Error(),
+		}
+	}
+	return updateIf(collection, id, field, expected, string(jsonData))
+}
+
+// DatabaseUpdateVersioned replaces the record only if its integer version
+// field equals version, and stores version+1 in that field. The data argument
+// must encode to a JSON object. On conflict an "aborted" WaferError is
+// returned.
+func DatabaseUpdateVersioned(collection, id, field string, version int64, data any) (Record, error) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return Record{}, &wafer.WaferError{
+			Code:    "internal",
+			Message: "failed to marshal record: " + err.Error(),
+		}
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(jsonData, &doc); err != nil || doc == nil {
+		return Record{}, &wafer.WaferError{
+			Code:    "invalid_argument",
+			Message: "versioned update data must be a JSON object",
+		}
+	}
+	doc[field] = version + 1
+	jsonData, err = json.Marshal(doc)
+	if err != nil {
+		return Record{}, &wafer.WaferError{
+			Code:    "internal",
+			Message: "failed to marshal record: " + err.Error(),
+		}
+	}
+	return updateIf(collection, id, field, version, string(jsonData))
+}
+
+// RetryOnConflict runs fn until it succeeds, fails with an error other than a
+// conflict, or attempts are exhausted. It is meant for read-modify-write loops
+// built on DatabaseUpdateIf or DatabaseUpdateVersioned, where fn re-reads the
+// record on every attempt.
+func RetryOnConflict(attempts int, fn func() error) error {
+	if attempts <= 0 {
+		attempts = DefaultRetryAttempts
+	}
+	var err error
+	for i := 0; i < attempts; i++ {
+		if err = fn(); !IsConflict(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// IsConflict reports whether err is an "aborted" error caused by a concurrent
+// modification.
+func IsConflict(err error) bool {
+	var we *wafer.WaferError
+	if errors.As(err, &we) {
+		return we.Code == "aborted"
+	}
+	return errors.Is(err, database.DatabaseErrorConflict)
+}
+
+func updateIf(collection, id, field string, expected any, data string) (Record, error) {
+	if err := validateRecord(collection, data); err != nil {
+		return Record{}, err
+	}
+	if database.UpdateIf != nil {
+		cond := Filter{Field: field, Operator: OpIsNull}
+		if expected != nil {
+			cond = Filter{Field: field, Operator: OpEqual, Value: jsonValue(expected)}
+		}
+		rec, err := database.UpdateIf(collection, id, []Filter{cond}, data)
+		if !isUnsupported(err) {
+			return rec, conflictError(err, collection, id)
+		}
+	}
+
+	// Without host support the check and the write are separate calls; this
+	// still catches most lost updates but is not atomic.
+	current, err := database.Get(collection, id)
+	if err != nil {
+		return Record{}, err
+	}
+	var doc map[string]any
+	if err := json.Unmarshal([]byte(current.Data), &doc); err != nil {
+		return Record{}, &wafer.WaferError{
+			Code:    "internal",
+			Message: "failed to decode record: " + err.Error(),
+		}
+	}
+	var want any
+	if expected != nil {
+		_ = json.Unmarshal([]byte(jsonValue(expected)), &want)
+	}
+	if !reflect.DeepEqual(doc[field], want) {
+		return Record{}, conflictError(database.DatabaseErrorConflict, collection, id)
+	}
+	return database.Update(collection, id, data)
+}
+
+// conflictError maps a host conflict to an "aborted" WaferError and passes
+// every other error through unchanged.
+func conflictError(err error, collection, id string) error {
+	if !errors.Is(err, database.DatabaseErrorConflict) {
+		return err
+	}
+	return &wafer.WaferError{
+		Code:    "aborted",
+		Message: "record " + id + " in " + collection + " was modified concurrently",
+	}
+}